@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"os"
+
+	"go.uber.org/zap"
+
+	metadata "helloworld-http/pkg/gcp"
+	logging "helloworld-http/pkg/logging"
+)
+
+// fakemetadata serves a canned GCE metadata tree so helloworld can be run
+// off of GCP, e.g.
+//
+//	METADATA_FAKE_PLATFORM=gke PORT=8081 go run ./cmd/fakemetadata
+//	GCE_METADATA_HOST=localhost:8081 go run ./cmd/helloworld
+func main() {
+	// structured logs in Cloud Logging's format unless LOG_FORMAT says otherwise
+	cfg, err := logging.NewConfigFromEnv()
+	logger, _ := cfg.Build()
+	defer logger.Sync()
+	zap.ReplaceGlobals(logger)
+	if err != nil {
+		zap.S().Panicf("Failed to configure logging: %v", err)
+	}
+
+	// serve the in-memory fake unless told to serve a file or env var instead
+	if os.Getenv("METADATA_SOURCE") == "" {
+		os.Setenv("METADATA_SOURCE", metadata.SOURCE_FAKE)
+	}
+
+	src, err := metadata.NewMetadataSourceFromEnv()
+	if err != nil {
+		zap.S().Fatalf("Failed to create metadata source: %v", err)
+	}
+
+	// use PORT environment variable, or default to 8081
+	port := "8081"
+	if fromEnv := os.Getenv("PORT"); fromEnv != "" {
+		port = fromEnv
+	}
+
+	zap.S().Infof("Fake metadata server listening on port %s", port)
+	err = http.ListenAndServe(":"+port, metadata.NewFakeServer(src))
+	if err != nil {
+		zap.S().Fatalf("Error listening: %v", err)
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.uber.org/zap"
+
+	attrs "helloworld-http/pkg/attrs"
+	load "helloworld-http/pkg/load"
+	trace "helloworld-http/pkg/trace"
+)
+
+// Exit codes of a job task. Cloud Run and Kubernetes retry any non-zero exit,
+// these just make it clear from the logs why a task failed.
+const (
+	JOB_EXIT_OK = 0
+	// the injected failure, unless -fail-exit-code says otherwise
+	JOB_EXIT_FAILED = 1
+	JOB_EXIT_USAGE  = 2
+	// a workload couldn't be run
+	JOB_EXIT_ERROR = 3
+	// killed by a signal, 128 plus the signal number is added to this
+	JOB_EXIT_SIGNAL = 128
+)
+
+// jobTask identifies this task within its job. Cloud Run jobs set all of it,
+// Kubernetes indexed jobs only set the index.
+type jobTask struct {
+	Job       string `json:"job,omitempty"`
+	Execution string `json:"execution,omitempty"`
+	Index     int    `json:"index"`
+	Count     int    `json:"count"`
+	Attempt   int    `json:"attempt"`
+}
+
+// envInt reads the first of the environment variables that is set as an int
+func envInt(def int, names ...string) (int, error) {
+	for _, name := range names {
+		if fromEnv := os.Getenv(name); fromEnv != "" {
+			v, err := strconv.Atoi(fromEnv)
+			if err != nil {
+				return def, fmt.Errorf("invalid value for %v: %v", name, fromEnv)
+			}
+			return v, nil
+		}
+	}
+
+	return def, nil
+}
+
+func getJobTask() (jobTask, error) {
+	task := jobTask{
+		Job:       os.Getenv("CLOUD_RUN_JOB"),
+		Execution: os.Getenv("CLOUD_RUN_EXECUTION"),
+	}
+
+	var err error
+	if task.Index, err = envInt(0, "CLOUD_RUN_TASK_INDEX", "JOB_COMPLETION_INDEX"); err != nil {
+		return task, err
+	}
+	if task.Count, err = envInt(1, "CLOUD_RUN_TASK_COUNT"); err != nil {
+		return task, err
+	}
+	if task.Attempt, err = envInt(0, "CLOUD_RUN_TASK_ATTEMPT"); err != nil {
+		return task, err
+	}
+
+	return task, nil
+}
+
+// jobConfig is the workload a task runs. The CPU and memory loads and the
+// sleep all run at the same time, the task finishes when the last one does.
+type jobConfig struct {
+	cpu        time.Duration
+	cores      int
+	percent    float64
+	memoryMB   int64
+	memoryHold time.Duration
+	memoryRamp time.Duration
+	sleep      time.Duration
+
+	failRate     float64
+	failExitCode int
+}
+
+func parseJobFlags(args []string) (jobConfig, error) {
+	var cfg jobConfig
+
+	fs := flag.NewFlagSet("job", flag.ContinueOnError)
+	fs.DurationVar(&cfg.cpu, "cpu", 0, "how long to burn CPU for")
+	fs.IntVar(&cfg.cores, "cores", 1, "how many cores to burn")
+	fs.Float64Var(&cfg.percent, "percent", 100, "how busy to keep each core, 1-100")
+	fs.Int64Var(&cfg.memoryMB, "memory-mb", 0, "how much memory to allocate")
+	fs.DurationVar(&cfg.memoryHold, "memory-hold", 10*time.Second, "how long to hold the memory once allocated")
+	fs.DurationVar(&cfg.memoryRamp, "memory-ramp", 0, "how long to take allocating the memory")
+	fs.DurationVar(&cfg.sleep, "sleep", 0, "how long to sleep for")
+	fs.Float64Var(&cfg.failRate, "fail-rate", 0, "chance of the task failing after its workload, 0-1, to exercise retries")
+	fs.IntVar(&cfg.failExitCode, "fail-exit-code", JOB_EXIT_FAILED, "exit code of an injected failure")
+
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+	if fs.NArg() > 0 {
+		return cfg, fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+	// checked before it's shifted into bytes, which could overflow
+	if max := load.DefaultMemoryLoads.MaxMegabytes(); cfg.memoryMB < 0 || cfg.memoryMB > max {
+		return cfg, fmt.Errorf("memory-mb must be between 0 and %d, got %d", max, cfg.memoryMB)
+	}
+	for _, d := range []struct {
+		name  string
+		value time.Duration
+	}{{"cpu", cfg.cpu}, {"memory-hold", cfg.memoryHold}, {"memory-ramp", cfg.memoryRamp}, {"sleep", cfg.sleep}} {
+		if d.value < 0 {
+			return cfg, fmt.Errorf("%v can't be negative, got %v", d.name, d.value)
+		}
+	}
+	if cfg.failRate < 0 || cfg.failRate > 1 {
+		return cfg, fmt.Errorf("fail-rate must be between 0 and 1, got %v", cfg.failRate)
+	}
+	if cfg.failExitCode <= 0 || cfg.failExitCode > 255 {
+		return cfg, fmt.Errorf("fail-exit-code must be between 1 and 255, got %v", cfg.failExitCode)
+	}
+
+	return cfg, nil
+}
+
+// runWorkload runs the configured loads side by side, returning the first
+// error from any of them
+func runWorkload(ctx context.Context, cfg jobConfig) error {
+	var wg sync.WaitGroup
+	errs := make(chan error, 3)
+
+	if cfg.cpu > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- load.DefaultCPULoads.Run(ctx, cfg.cores, cfg.percent, cfg.cpu)
+		}()
+	}
+
+	if cfg.memoryMB > 0 {
+		m, err := load.DefaultMemoryLoads.Start(cfg.memoryMB<<20, cfg.memoryHold, cfg.memoryRamp)
+		if err != nil {
+			return err
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer load.DefaultMemoryLoads.Release(m.ID)
+
+			select {
+			case <-ctx.Done():
+				errs <- ctx.Err()
+			case <-time.After(cfg.memoryRamp + cfg.memoryHold):
+			}
+		}()
+	}
+
+	if cfg.sleep > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			select {
+			case <-ctx.Done():
+				errs <- ctx.Err()
+			case <-time.After(cfg.sleep):
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// runJob runs one task of a batch job instead of serving, logs the payload
+// and what happened, and returns the exit code
+func runJob(ctx context.Context, t *trace.TraceConfig, args []string) int {
+	cfg, err := parseJobFlags(args)
+	if err != nil {
+		zap.S().Errorf("Invalid job arguments: %v", err)
+		return JOB_EXIT_USAGE
+	}
+
+	task, err := getJobTask()
+	if err != nil {
+		zap.S().Errorf("Invalid job environment: %v", err)
+		return JOB_EXIT_USAGE
+	}
+
+	// the platform sends SIGTERM when the task times out or is cancelled
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	sigCh := make(chan os.Signal, 1)
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+	go func() {
+		select {
+		case sig := <-sigCh:
+			zap.S().Warnf("Received %v, stopping the job task", sig)
+			interrupted <- sig
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	ctx, span := otel.Tracer("helloworld-http").Start(ctx, "job task")
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("job.name", task.Job),
+		attribute.String("job.execution", task.Execution),
+		attribute.Int("job.task.index", task.Index),
+		attribute.Int("job.task.count", task.Count),
+		attribute.Int("job.task.attempt", task.Attempt),
+	)
+
+	zap.L().Info("Starting job task", zap.Any("task", task))
+	started := time.Now()
+
+	payload, err := attrs.GetProcessAttrs(ctx, t)
+	if err != nil {
+		// still worth running, the payload will just be missing the metadata
+		zap.S().Warnf("Unable to get attributes for the job task: %v", err)
+	}
+
+	code := JOB_EXIT_OK
+	outcome := "succeeded"
+	if err := runWorkload(ctx, cfg); err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		select {
+		case sig := <-interrupted:
+			code = JOB_EXIT_SIGNAL + int(sig.(syscall.Signal))
+			outcome = "interrupted"
+		default:
+			zap.S().Errorf("Job task workload failed: %v", err)
+			code = JOB_EXIT_ERROR
+			outcome = "errored"
+		}
+	} else if cfg.failRate > 0 {
+		rnd := rand.New(rand.NewSource(time.Now().UnixNano() + int64(task.Index)))
+		if rnd.Float64() < cfg.failRate {
+			span.SetStatus(codes.Error, "injected failure")
+			code = cfg.failExitCode
+			outcome = "failed"
+		}
+	}
+
+	span.SetAttributes(attribute.Int("job.exit_code", code))
+	zap.L().Info(fmt.Sprintf("Job task %v", outcome),
+		zap.Any("task", task),
+		zap.Any("payload", payload),
+		zap.String("outcome", outcome),
+		zap.Int("exitCode", code),
+		zap.Duration("elapsed", time.Since(started)))
+
+	return code
+}
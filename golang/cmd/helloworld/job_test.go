@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseJobFlags(t *testing.T) {
+	defaults := jobConfig{cores: 1, percent: 100, memoryHold: 10 * time.Second, failExitCode: JOB_EXIT_FAILED}
+
+	tests := []struct {
+		name    string
+		args    []string
+		want    jobConfig
+		wantErr bool
+	}{
+		{name: "no flags", want: defaults},
+		{
+			name: "everything",
+			args: []string{"-cpu", "30s", "-cores", "2", "-percent", "50", "-memory-mb", "64", "-memory-hold", "1m",
+				"-memory-ramp", "5s", "-sleep", "2s", "-fail-rate", "0.5", "-fail-exit-code", "42"},
+			want: jobConfig{cpu: 30 * time.Second, cores: 2, percent: 50, memoryMB: 64, memoryHold: time.Minute,
+				memoryRamp: 5 * time.Second, sleep: 2 * time.Second, failRate: 0.5, failExitCode: 42},
+		},
+		{name: "unknown flag", args: []string{"-disk", "1"}, wantErr: true},
+		{name: "not a duration", args: []string{"-cpu", "30"}, wantErr: true},
+		{name: "left over arguments", args: []string{"-sleep", "1s", "extra"}, wantErr: true},
+		{name: "negative memory", args: []string{"-memory-mb", "-1"}, wantErr: true},
+		{name: "more memory than can be shifted into bytes", args: []string{"-memory-mb", "8796093022208"}, wantErr: true},
+		{name: "negative sleep", args: []string{"-sleep", "-1s"}, wantErr: true},
+		{name: "fail rate over 1", args: []string{"-fail-rate", "1.5"}, wantErr: true},
+		{name: "negative fail rate", args: []string{"-fail-rate", "-0.1"}, wantErr: true},
+		{name: "exit code 0", args: []string{"-fail-exit-code", "0"}, wantErr: true},
+		{name: "exit code over 255", args: []string{"-fail-exit-code", "256"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseJobFlags(tt.args)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%v: error = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("%v: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGetJobTask(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    jobTask
+		wantErr bool
+	}{
+		{name: "nothing set", want: jobTask{Count: 1}},
+		{
+			name: "Cloud Run",
+			env: map[string]string{"CLOUD_RUN_JOB": "hello", "CLOUD_RUN_EXECUTION": "hello-abc", "CLOUD_RUN_TASK_INDEX": "3",
+				"CLOUD_RUN_TASK_COUNT": "5", "CLOUD_RUN_TASK_ATTEMPT": "1"},
+			want: jobTask{Job: "hello", Execution: "hello-abc", Index: 3, Count: 5, Attempt: 1},
+		},
+		{name: "Kubernetes indexed job", env: map[string]string{"JOB_COMPLETION_INDEX": "2"}, want: jobTask{Index: 2, Count: 1}},
+		{name: "bad index", env: map[string]string{"CLOUD_RUN_TASK_INDEX": "first"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, k := range []string{"CLOUD_RUN_JOB", "CLOUD_RUN_EXECUTION", "CLOUD_RUN_TASK_INDEX", "JOB_COMPLETION_INDEX", "CLOUD_RUN_TASK_COUNT", "CLOUD_RUN_TASK_ATTEMPT"} {
+				t.Setenv(k, tt.env[k])
+			}
+
+			got, err := getJobTask()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunWorkload(t *testing.T) {
+	cfg := jobConfig{sleep: 10 * time.Millisecond, memoryMB: 1, memoryHold: 10 * time.Millisecond, cpu: 10 * time.Millisecond, cores: 1, percent: 10}
+	if err := runWorkload(context.Background(), cfg); err != nil {
+		t.Errorf("runWorkload() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := runWorkload(ctx, jobConfig{sleep: time.Minute}); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled runWorkload() error = %v, want context.Canceled", err)
+	}
+}
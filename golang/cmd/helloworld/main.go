@@ -19,18 +19,27 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"math"
 	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
 	"syscall"
+	"time"
 
 	chi "github.com/go-chi/chi/v5"
 	"go.uber.org/zap"
 
+	chain "helloworld-http/pkg/chain"
+	fault "helloworld-http/pkg/fault"
 	metadata "helloworld-http/pkg/gcp"
 	handler "helloworld-http/pkg/handler"
 	health "helloworld-http/pkg/health"
+	load "helloworld-http/pkg/load"
+	logging "helloworld-http/pkg/logging"
 	metrics "helloworld-http/pkg/metrics"
 	trace "helloworld-http/pkg/trace"
 	util "helloworld-http/pkg/util"
@@ -38,26 +47,74 @@ import (
 
 
 
+// getEnvSecs reads a duration in whole seconds from the environment
+func getEnvSecs(name string, def int) time.Duration {
+	secs := def
+	if fromEnv, exists := os.LookupEnv(name); exists {
+		var err error
+		secs, err = strconv.Atoi(fromEnv)
+		if err != nil {
+			zap.S().Panicf("Invalid value for %v: %v", name, fromEnv)
+		}
+	}
+
+	return time.Duration(secs) * time.Second
+}
+
+// getEnvBool reads a boolean from the environment
+func getEnvBool(name string, def bool) bool {
+	fromEnv, exists := os.LookupEnv(name)
+	if !exists {
+		return def
+	}
+
+	b, err := strconv.ParseBool(fromEnv)
+	if err != nil {
+		zap.S().Panicf("Invalid value for %v: %v", name, fromEnv)
+	}
+
+	return b
+}
+
 func main() {
-	ctx := context.Background();
+	// cancelled at shutdown to stop background work like the metadata refresh
+	ctx, cancel := context.WithCancel(context.Background())
 
-	cfg := zap.NewProductionConfig()
-	a := zap.NewAtomicLevel()
-	a.UnmarshalText([]byte(os.Getenv("LOG_LEVEL")))
-	cfg.Level.SetLevel(a.Level())
+	// structured logs in Cloud Logging's format unless LOG_FORMAT says otherwise
+	cfg, err := logging.NewConfigFromEnv()
 	logger, _ := cfg.Build()
-	defer logger.Sync()
 	zap.ReplaceGlobals(logger)
+	if err != nil {
+		zap.S().Panicf("Failed to configure logging: %v", err)
+	}
+
+	// pick where metadata comes from, the real metadata server by default
+	metadataSource, err := metadata.NewMetadataSourceFromEnv()
+	if err != nil {
+		zap.S().Panicf("Failed to configure metadata source: %v", err)
+	}
+
+	// keep the metadata tree in memory and refresh it in the background
+	// instead of fetching it on every request
+	metadataSource, err = metadata.NewMetadataCacheFromEnv(metadataSource)
+	if err != nil {
+		zap.S().Panicf("Failed to configure metadata cache: %v", err)
+	}
+	if cache, ok := metadataSource.(*metadata.MetadataCache); ok {
+		cache.Start(ctx)
+	}
+	metadata.SetMetadataSource(metadataSource)
 
 	project := os.Getenv("PROJECT_ID")
 	if project == "" {
 		// try to get it from the environment
 		projectStr, err := metadata.GetProjectID(ctx)
 		if err != nil {
-			zap.S().Panicf("Failed to get metadata: %v", err)
+			// not fatal, the trace exporter can still find the project from credentials
+			zap.S().Warnf("Failed to get project ID from metadata: %v", err)
+		} else {
+			project = *projectStr
 		}
-
-		project = *projectStr
 	}
 
 	zap.S().Infof("Project ID: %v", project)
@@ -65,18 +122,38 @@ func main() {
 	if err != nil {
 		zap.S().Panicf("Failed to initialize trace: %v", err)
 	}
-	defer traceConfig.Shutdown(ctx)
 
 
-	startup_cpuloop, cpu_loop_exists := os.LookupEnv("STARTUP_CPULOOP_SECS")
-	if (cpu_loop_exists) {
-		busyloopSecs, err := strconv.Atoi(startup_cpuloop)
-		if err != nil {
-			zap.S().Panicf("Invalid value for STARTUP_CPULOOP_SECS: %v", startup_cpuloop)
+	// how much memory /memload and jobs can hold between them
+	if fromEnv, exists := os.LookupEnv("MEMLOAD_MAX_MB"); exists {
+		maxMB, err := strconv.ParseInt(fromEnv, 10, 64)
+		if err != nil || maxMB <= 0 || maxMB > math.MaxInt64>>20 {
+			zap.S().Panicf("Invalid value for MEMLOAD_MAX_MB: %v", fromEnv)
+		}
+		load.DefaultMemoryLoads.MaxBytes = maxMB << 20
+	}
+
+	// how many cores /busyloop and job loads can keep busy, each and between
+	// them, by default as many as there are
+	if fromEnv, exists := os.LookupEnv("CPULOAD_MAX_CORES"); exists {
+		maxCores, err := strconv.Atoi(fromEnv)
+		if err != nil || maxCores <= 0 {
+			zap.S().Panicf("Invalid value for CPULOAD_MAX_CORES: %v", fromEnv)
 		}
+		load.MaxCores = maxCores
+	}
+
+	// run a single batch task instead of serving, e.g. as a Cloud Run job
+	if len(os.Args) > 1 && os.Args[1] == "job" {
+		code := runJob(ctx, traceConfig, os.Args[2:])
 
-		util.BusyLoop(ctx, busyloopSecs)
+		cancel()
+		flushCtx, flushCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		traceConfig.Shutdown(flushCtx)
+		flushCancel()
 
+		logger.Sync()
+		os.Exit(code)
 	}
 
 	handler, err := handler.InitHandler(*logger, traceConfig)
@@ -84,6 +161,15 @@ func main() {
 		zap.S().Panicf("Failed to initialize handler: %v", err)
 	}
 
+	// let the HTML page be rebranded without a rebuild, reloading on changes
+	if templateDir := os.Getenv("TEMPLATE_DIR"); templateDir != "" {
+		err := handler.LoadTemplates(ctx, templateDir, getEnvSecs("TEMPLATE_RELOAD_SECS", 5))
+		if err != nil {
+			zap.S().Panicf("Failed to load templates from %v: %v", templateDir, err)
+		}
+		zap.S().Infof("Loaded template overrides from %v", templateDir)
+	}
+
 	// use PORT environment variable, or default to 8080
 	port := "8080"
 	if fromEnv := os.Getenv("PORT"); fromEnv != "" {
@@ -101,43 +187,227 @@ func main() {
 
 	r.Use(metrics.Middleware)
 	r.Use(trace.Middleware)
+	// one entry per request once it's served, and a logger per request while
+	// it's being served, both correlated with the request's trace
+	accessLog, err := logging.NewAccessLogFromEnv(project)
+	if err != nil {
+		zap.S().Panicf("Failed to configure access log: %v", err)
+	}
+	r.Use(accessLog.Middleware)
+	r.Use(logging.Middleware(project))
+
+	// Enable health check /livez, /readyz and /startupz endpoints
+	health.RegisterDefaultChecks()
+	health.AddReadinessCheck("metadata", health.Cached(func() error {
+		_, err := metadata.GetMetaDataSnapshot(ctx)
+		return err
+	}, 10*time.Second))
+	health.AddInformationalCheck("tracer-exporter", traceConfig.ExporterCheck)
+
+	startupLoopDone := health.NewFlag("startup busy loop still running")
+	health.AddStartupCheck("startup-busyloop", startupLoopDone.Check())
 
-	// Enable health check /healthz endpoint
-	zap.S().Debug("Healthcheck available at /healthz")
-	r.Get("/healthz", health.HealthCheckHandler())
+	zap.S().Debugf("Health checks available at /livez, /readyz and /startupz: %v", health.DefaultRegistry.Names())
+	r.Get("/livez", health.LivezHandler())
+	r.Get("/readyz", health.ReadyzHandler())
+	r.Get("/startupz", health.StartupzHandler())
 
-	// add /metrics endpoint to extract and examine stats.
-	metrics.InitMetrics()
+	// /healthz predates the split, keep it around as readiness
+	r.Get("/healthz", health.ReadyzHandler())
+
+	// add /metrics endpoint to extract and examine stats, and push them to
+	// any other exporters, described by the same resource as the traces
+	metricsConfig, err := metrics.InitMetrics(ctx, traceConfig.Resource, project)
+	if err != nil {
+		zap.S().Panicf("Failed to configure metrics: %v", err)
+	}
 	zap.S().Debug("Metrics available at /metrics")
 	r.Get("/metrics", metrics.MetricsHandler())
 
-	r.Post("/busyloop", http.HandlerFunc(handler.BusyLoop))
+	// burn CPU, in the request or as a background job
+	r.Route("/busyloop", func(r chi.Router) {
+		r.Get("/", handler.BusyLoopList)
+		r.Post("/", handler.BusyLoop)
+		r.Get("/{id}", handler.BusyLoopGet)
+		r.Delete("/{id}", handler.BusyLoopCancel)
+	})
+
+	// hold on to memory for a while, to exercise memory-based autoscaling and OOMs
+	r.Route("/memload", func(r chi.Router) {
+		r.Get("/", handler.MemLoadList)
+		r.Post("/", handler.MemLoad)
+		r.Delete("/", handler.MemLoadReleaseAll)
+		r.Get("/{id}", handler.MemLoadGet)
+		r.Delete("/{id}", handler.MemLoadRelease)
+	})
+
+	// JSON Schema of the payload served by /, ?version= for older shapes
+	r.Get("/schema", handler.Schema)
+
+	// reflect the request back, for debugging what proxies do to it
+	if fromEnv, exists := os.LookupEnv("ECHO_MAX_BODY_BYTES"); exists {
+		maxBody, err := strconv.ParseInt(fromEnv, 10, 64)
+		if err != nil {
+			zap.S().Panicf("Invalid value for ECHO_MAX_BODY_BYTES: %v", fromEnv)
+		}
+		handler.EchoMaxBodyBytes = maxBody
+		if handler.EchoBodyLimit < maxBody {
+			handler.EchoBodyLimit = maxBody
+		}
+	}
+	if fromEnv, exists := os.LookupEnv("ECHO_BODY_LIMIT_BYTES"); exists {
+		limit, err := strconv.ParseInt(fromEnv, 10, 64)
+		if err != nil || limit < handler.EchoMaxBodyBytes {
+			zap.S().Panicf("Invalid value for ECHO_BODY_LIMIT_BYTES, it can't be less than ECHO_MAX_BODY_BYTES: %v", fromEnv)
+		}
+		handler.EchoBodyLimit = limit
+	}
+	r.HandleFunc("/echo", handler.Echo)
+	r.HandleFunc("/echo/*", handler.Echo)
+
+	// call other instances downstream, to build multi-hop topologies
+	handler.Caller, err = chain.NewCallerFromEnv()
+	if err != nil {
+		zap.S().Panicf("Failed to configure downstream calls: %v", err)
+	}
+	if len(handler.Caller.Upstreams) > 0 {
+		zap.S().Infof("Calling upstreams from /call: %v", handler.Caller.Upstreams)
+	}
+	r.Get("/call", handler.Call)
+
+	// the admin APIs change how the app behaves for every caller, so they're
+	// only served to callers presenting ADMIN_TOKEN, and not at all without it
+	adminToken := os.Getenv("ADMIN_TOKEN")
+	mountAdmin := func(pattern string, h http.Handler) {
+		if adminToken == "" {
+			zap.S().Infof("ADMIN_TOKEN not set, not serving %v", pattern)
+			return
+		}
+		zap.S().Debugf("Admin API available at %v", pattern)
+		r.With(util.RequireToken(adminToken)).Mount(pattern, h)
+	}
+
+	// fault injection for the root handler, off unless asked for, controlled
+	// at runtime through /admin/faults and, if FAULT_HEADERS is set, per
+	// request through X-Fault-* headers
+	helloHandler := http.Handler(http.HandlerFunc(handler.Hello))
+	if getEnvBool("FAULT_INJECTION", false) {
+		fault.HeaderOverrides = getEnvBool("FAULT_HEADERS", false)
+		if rules := os.Getenv("FAULT_RULES"); rules != "" {
+			if err := fault.DefaultStore.LoadJSON([]byte(rules)); err != nil {
+				zap.S().Panicf("Invalid value for FAULT_RULES: %v", err)
+			}
+		}
+
+		mountAdmin("/admin/faults", fault.AdminRouter())
+		helloHandler = fault.Middleware(helloHandler)
+	}
+
+	mountAdmin("/admin/sampler", trace.SamplerAdminRouter(traceConfig.Sampler))
 
 	// root handler which serves up responses
-	r.Get("/*", http.HandlerFunc(handler.Hello))
+	r.Get("/*", helloHandler.ServeHTTP)
+
+	// how long to keep serving, while failing readiness, before we stop
+	// accepting connections so that load balancers have time to notice
+	shutdownDelay := getEnvSecs("SHUTDOWN_DELAY_SECS", 0)
+
+	// how long to wait for in-flight requests to finish
+	drainTimeout := getEnvSecs("SHUTDOWN_DRAIN_SECS", 20)
+
+	srv := &http.Server{
+		Addr:    ":" + port,
+		Handler: r,
+	}
+
+	// serve TLS ourselves if given a certificate, e.g. to see the client's
+	// TLS details in /echo without a load balancer terminating it first
+	tlsCert := os.Getenv("TLS_CERT_FILE")
+	tlsKey := os.Getenv("TLS_KEY_FILE")
+	if clientCA := os.Getenv("TLS_CLIENT_CA_FILE"); clientCA != "" {
+		pem, err := ioutil.ReadFile(clientCA)
+		if err != nil {
+			zap.S().Panicf("Failed to read TLS_CLIENT_CA_FILE: %v", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			zap.S().Panicf("No certificates found in TLS_CLIENT_CA_FILE %v", clientCA)
+		}
+
+		srv.TLSConfig = &tls.Config{
+			ClientCAs:  pool,
+			ClientAuth: tls.VerifyClientCertIfGiven,
+		}
+	}
 
 	go func() {
 		// start the web server on port and accept requests
-		zap.S().Infof("Server listening on port %s", port)
-		err := http.ListenAndServe(":"+port, r)
-		if err != nil {
+		var err error
+		if tlsCert != "" {
+			zap.S().Infof("Server listening with TLS on port %s", port)
+			err = srv.ListenAndServeTLS(tlsCert, tlsKey)
+		} else {
+			zap.S().Infof("Server listening on port %s", port)
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			zap.S().Fatalf("Error listening: %v", err)
 		}
 	}()
 
+	// burn CPU at startup after we're listening, so /startupz can report on it
+	go func() {
+		startup_cpuloop, cpu_loop_exists := os.LookupEnv("STARTUP_CPULOOP_SECS")
+		if (cpu_loop_exists) {
+			busyloopSecs, err := strconv.Atoi(startup_cpuloop)
+			if err != nil {
+				zap.S().Panicf("Invalid value for STARTUP_CPULOOP_SECS: %v", startup_cpuloop)
+			}
+
+			util.BusyLoop(ctx, busyloopSecs)
+		}
+
+		startupLoopDone.Set()
+	}()
+
 	sig := <-sigCh
-	switch sig {
-	case os.Interrupt:
-		zap.S().Info("Received SIGINT, terminating ...")
-		os.Exit(0)
-	case syscall.SIGTERM:
-		zap.S().Info("Received SIGTERM, terminating ...")
-		os.Exit(0)
-	case syscall.SIGQUIT:
-		zap.S().Info("Received SIGQUIT, terminating ...")
-		os.Exit(0)
-	}
-	
+	zap.S().Infof("Received %v, shutting down ...", sig)
+
+	// a second signal means don't wait around
+	go func() {
+		sig := <-sigCh
+		zap.S().Warnf("Received %v during shutdown, terminating ...", sig)
+		logger.Sync()
+		os.Exit(1)
+	}()
+
+	// 1. fail readiness and keep serving until load balancers catch up
+	health.SetShuttingDown()
+	if shutdownDelay > 0 {
+		zap.S().Infof("Failing readiness for %v before draining", shutdownDelay)
+		time.Sleep(shutdownDelay)
+	}
+
+	// 2. stop accepting new connections and drain in-flight requests
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), drainTimeout)
+	if err := srv.Shutdown(drainCtx); err != nil {
+		zap.S().Warnf("Requests still in flight after %v, closing: %v", drainTimeout, err)
+		srv.Close()
+	}
+	drainCancel()
+
+	// 3. stop background work and flush any spans and metrics from the
+	// requests we just drained
+	cancel()
+	flushCtx, flushCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	traceConfig.Shutdown(flushCtx)
+	metricsConfig.Shutdown(flushCtx)
+	flushCancel()
+
+	// 4. flush the logger last so everything above makes it out
+	zap.S().Info("Shutdown complete")
+	logger.Sync()
 }
 // [END all]
 
@@ -0,0 +1,103 @@
+package attrs
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	CF_GEN1 = "gen1"
+	CF_GEN2 = "gen2"
+)
+
+// cgroup memory limits, v2 then v1. "max" or a huge number means unlimited.
+var cgroupMemoryFiles = []string{
+	"/sys/fs/cgroup/memory.max",
+	"/sys/fs/cgroup/memory/memory.limit_in_bytes",
+}
+
+// getMemoryLimitMB reads the container's memory limit, or 0 if there isn't one
+func getMemoryLimitMB() int {
+	for _, f := range cgroupMemoryFiles {
+		body, err := ioutil.ReadFile(f)
+		if err != nil {
+			continue
+		}
+
+		limit, err := strconv.ParseInt(strings.TrimSpace(string(body)), 10, 64)
+		if err != nil || limit <= 0 || limit >= 1<<50 {
+			return 0
+		}
+
+		return int(limit >> 20)
+	}
+
+	return 0
+}
+
+// firstEnv returns the first of the environment variables that is set
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+
+	return ""
+}
+
+// getCfAttrs detects Cloud Functions from the environment the functions
+// framework runs in, returning nil if we're not in one.
+//
+// 1st gen functions on the older runtimes set FUNCTION_NAME, FUNCTION_REGION
+// and FUNCTION_MEMORY_MB (or their X_GOOGLE_ variants). Newer 1st gen
+// runtimes and 2nd gen (Cloud Run functions) both set K_SERVICE, K_REVISION,
+// FUNCTION_TARGET and FUNCTION_SIGNATURE_TYPE; only 2nd gen, being a Cloud
+// Run service, also sets K_CONFIGURATION. The region comes from the metadata
+// server when the environment doesn't have it.
+func getCfAttrs(metadata map[string]interface{}) *CfAttrs {
+	legacyName := firstEnv("FUNCTION_NAME", "X_GOOGLE_FUNCTION_NAME")
+	target := os.Getenv("FUNCTION_TARGET")
+
+	if legacyName == "" && target == "" {
+		return nil
+	}
+
+	cf := &CfAttrs{
+		Name:          firstEnv("K_SERVICE", "FUNCTION_NAME", "X_GOOGLE_FUNCTION_NAME"),
+		Revision:      firstEnv("K_REVISION", "X_GOOGLE_FUNCTION_VERSION"),
+		Target:        target,
+		SignatureType: os.Getenv("FUNCTION_SIGNATURE_TYPE"),
+		Region:        firstEnv("FUNCTION_REGION", "X_GOOGLE_FUNCTION_REGION"),
+		Runtime:       firstEnv("GOOGLE_RUNTIME", "X_GOOGLE_FUNCTION_RUNTIME"),
+	}
+
+	if legacyName == "" && os.Getenv("K_CONFIGURATION") != "" {
+		cf.Generation = CF_GEN2
+	} else {
+		cf.Generation = CF_GEN1
+	}
+
+	if cf.Runtime != "" {
+		if version := os.Getenv("GOOGLE_RUNTIME_VERSION"); version != "" {
+			cf.Runtime = cf.Runtime + " " + version
+		}
+	}
+
+	if cf.Region == "" {
+		if regionStr, ok := metadataStr("instance/region", metadata); ok {
+			cf.Region = regionPrefix.ReplaceAllString(regionStr, "")
+		}
+	}
+
+	if memory := firstEnv("FUNCTION_MEMORY_MB", "X_GOOGLE_FUNCTION_MEMORY_MB"); memory != "" {
+		cf.MemoryMB, _ = strconv.Atoi(memory)
+	}
+	if cf.MemoryMB == 0 {
+		cf.MemoryMB = getMemoryLimitMB()
+	}
+
+	return cf
+}
@@ -0,0 +1,113 @@
+package attrs
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+var cfEnv = []string{
+	"FUNCTION_NAME", "X_GOOGLE_FUNCTION_NAME", "FUNCTION_TARGET", "FUNCTION_SIGNATURE_TYPE",
+	"FUNCTION_REGION", "X_GOOGLE_FUNCTION_REGION", "FUNCTION_MEMORY_MB", "X_GOOGLE_FUNCTION_MEMORY_MB",
+	"X_GOOGLE_FUNCTION_VERSION", "X_GOOGLE_FUNCTION_RUNTIME", "GOOGLE_RUNTIME", "GOOGLE_RUNTIME_VERSION",
+	"K_SERVICE", "K_REVISION", "K_CONFIGURATION",
+}
+
+// cgroupLimit points the memory limit lookup at a file holding limit
+func cgroupLimit(t *testing.T, limit string) {
+	t.Helper()
+
+	saved := cgroupMemoryFiles
+	t.Cleanup(func() { cgroupMemoryFiles = saved })
+
+	f := filepath.Join(t.TempDir(), "memory.max")
+	if err := ioutil.WriteFile(f, []byte(limit+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cgroupMemoryFiles = []string{f}
+}
+
+func TestGetCfAttrs(t *testing.T) {
+	metadata := map[string]interface{}{
+		"instance": map[string]interface{}{"region": "projects/123/regions/europe-west1"},
+	}
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		want *CfAttrs
+	}{
+		{
+			name: "not a function",
+			env:  map[string]string{"K_SERVICE": "hello", "K_CONFIGURATION": "hello"},
+		},
+		{
+			name: "1st gen, older runtime",
+			env: map[string]string{
+				"FUNCTION_NAME": "hello", "FUNCTION_REGION": "us-central1", "FUNCTION_MEMORY_MB": "256",
+				"X_GOOGLE_FUNCTION_VERSION": "3", "X_GOOGLE_FUNCTION_RUNTIME": "go111",
+			},
+			want: &CfAttrs{Name: "hello", Generation: CF_GEN1, Revision: "3", Region: "us-central1", Runtime: "go111", MemoryMB: 256},
+		},
+		{
+			name: "1st gen, newer runtime",
+			env: map[string]string{
+				"K_SERVICE": "hello", "K_REVISION": "4", "FUNCTION_TARGET": "Hello", "FUNCTION_SIGNATURE_TYPE": "http",
+				"GOOGLE_RUNTIME": "go121", "GOOGLE_RUNTIME_VERSION": "1.21.0",
+			},
+			want: &CfAttrs{Name: "hello", Generation: CF_GEN1, Revision: "4", Region: "europe-west1", Runtime: "go121 1.21.0", MemoryMB: 512, Target: "Hello", SignatureType: "http"},
+		},
+		{
+			name: "2nd gen",
+			env: map[string]string{
+				"K_SERVICE": "hello", "K_REVISION": "hello-00002-abc", "K_CONFIGURATION": "hello",
+				"FUNCTION_TARGET": "Hello", "FUNCTION_SIGNATURE_TYPE": "cloudevent",
+			},
+			want: &CfAttrs{Name: "hello", Generation: CF_GEN2, Revision: "hello-00002-abc", Region: "europe-west1", MemoryMB: 512, Target: "Hello", SignatureType: "cloudevent"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, k := range cfEnv {
+				t.Setenv(k, tt.env[k])
+			}
+			cgroupLimit(t, "536870912")
+
+			got := getCfAttrs(metadata)
+			if tt.want == nil || got == nil {
+				if got != tt.want {
+					t.Errorf("got %+v, want %+v", got, tt.want)
+				}
+				return
+			}
+			if *got != *tt.want {
+				t.Errorf("got %+v, want %+v", *got, *tt.want)
+			}
+		})
+	}
+}
+
+func TestGetMemoryLimitMB(t *testing.T) {
+	tests := []struct {
+		limit string
+		want  int
+	}{
+		{limit: "268435456", want: 256},
+		{limit: "max", want: 0},
+		{limit: "9223372036854771712", want: 0},
+		{limit: "0", want: 0},
+	}
+
+	for _, tt := range tests {
+		cgroupLimit(t, tt.limit)
+		if got := getMemoryLimitMB(); got != tt.want {
+			t.Errorf("limit %v: got %v MB, want %v", tt.limit, got, tt.want)
+		}
+	}
+
+	cgroupMemoryFiles = []string{filepath.Join(t.TempDir(), "missing")}
+	if got := getMemoryLimitMB(); got != 0 {
+		t.Errorf("no cgroup files: got %v MB", got)
+	}
+}
@@ -0,0 +1,240 @@
+package attrs
+
+// Older payload shapes, still served for ?version=. Each is a frozen copy of
+// Payload as it was, down to the nested attributes, so that its schema keeps
+// matching the one checked in whatever happens to the current types.
+
+// payloadV1_1 is the 1.1.0 payload, where cf was declared but always empty
+type payloadV1_1 struct {
+	Version string `json:"version"`
+
+	Request requestAttrsV1_1 `json:"request"`
+
+	NodeName       string `json:"nodename,omitempty"`
+	Zone           string `json:"zone"`
+	Project        string `json:"project"`
+	ServiceAccount string `json:"serviceAccount"`
+
+	Guest  guestAttrsV1_1  `json:"guest"`
+	Client clientAttrsV1_1 `json:"client"`
+
+	Gae *gaeAttrsV1_1 `json:"gae,omitempty"`
+	Gce *gceAttrsV1_1 `json:"gce,omitempty"`
+	Gke *gkeAttrsV1_1 `json:"gke,omitempty"`
+	Run *runAttrsV1_1 `json:"run,omitempty"`
+	Cf  *cfAttrsV1_1  `json:"cf,omitempty"`
+	K8s *k8sAttrsV1_1 `json:"k8s,omitempty"`
+}
+
+type requestAttrsV1_1 struct {
+	RequestPath    string              `json:"requestPath"`
+	RequestHeaders map[string][]string `json:"requestHeaders"`
+}
+
+type guestAttrsV1_1 struct {
+	Hostname    string `json:"hostname"`
+	GuestIpAddr string `json:"guestIp"`
+}
+
+type clientAttrsV1_1 struct {
+	SourceAddr string  `json:"sourceAddr"`
+	LbAddr     *string `json:"lbAddr,omitempty"`
+}
+
+type gaeAttrsV1_1 struct {
+	Region     string `json:"region"`
+	InstanceId string `json:"instanceId"`
+}
+
+type gceAttrsV1_1 struct {
+	PrivateIpAddr string  `json:"privateIp"`
+	MachineType   string  `json:"machineType,omitempty"`
+	Preemptible   bool    `json:"preemptible"`
+	MigName       *string `json:"migName,omitempty"`
+}
+
+type gkeAttrsV1_1 struct {
+	ClusterName   string `json:"clusterName"`
+	ClusterRegion string `json:"clusterRegion"`
+}
+
+type k8sAttrsV1_1 struct {
+	PodName        string             `json:"podName"`
+	PodIpAddr      string             `json:"podIpAddr"`
+	Namespace      string             `json:"namespace"`
+	ServiceAccount string             `json:"serviceAccount"`
+	Labels         *map[string]string `json:"labels,omitempty"`
+	NodeName       string             `json:"nodeName"`
+	NodeIpAddr     string             `json:"nodeIpAddr"`
+}
+
+type cfAttrsV1_1 struct {
+}
+
+// runAttrsV1_1 is run up to 1.2.0, before the service, revision and
+// configuration were added
+type runAttrsV1_1 struct {
+	Region     string `json:"region"`
+	InstanceId string `json:"instanceId"`
+}
+
+func toGaeV1_1(gae *GaeAttrs) *gaeAttrsV1_1 {
+	if gae == nil {
+		return nil
+	}
+
+	return &gaeAttrsV1_1{
+		Region:     gae.Region,
+		InstanceId: gae.InstanceId,
+	}
+}
+
+func toGceV1_1(gce *GceAttrs) *gceAttrsV1_1 {
+	if gce == nil {
+		return nil
+	}
+
+	return &gceAttrsV1_1{
+		PrivateIpAddr: gce.PrivateIpAddr,
+		MachineType:   gce.MachineType,
+		Preemptible:   gce.Preemptible,
+		MigName:       gce.MigName,
+	}
+}
+
+func toGkeV1_1(gke *GkeAttrs) *gkeAttrsV1_1 {
+	if gke == nil {
+		return nil
+	}
+
+	return &gkeAttrsV1_1{
+		ClusterName:   gke.ClusterName,
+		ClusterRegion: gke.ClusterRegion,
+	}
+}
+
+func toK8sV1_1(k8s *K8sAttrs) *k8sAttrsV1_1 {
+	if k8s == nil {
+		return nil
+	}
+
+	return &k8sAttrsV1_1{
+		PodName:        k8s.PodName,
+		PodIpAddr:      k8s.PodIpAddr,
+		Namespace:      k8s.Namespace,
+		ServiceAccount: k8s.ServiceAccount,
+		Labels:         k8s.Labels,
+		NodeName:       k8s.NodeName,
+		NodeIpAddr:     k8s.NodeIpAddr,
+	}
+}
+
+func toRunV1_1(run *RunAttrs) *runAttrsV1_1 {
+	if run == nil {
+		return nil
+	}
+
+	return &runAttrsV1_1{
+		Region:     run.Region,
+		InstanceId: run.InstanceId,
+	}
+}
+
+func toV1_1(p Payload) interface{} {
+	return payloadV1_1{
+		Version: p.Version,
+		Request: requestAttrsV1_1{
+			RequestPath:    p.Request.RequestPath,
+			RequestHeaders: p.Request.RequestHeaders,
+		},
+		NodeName:       p.NodeName,
+		Zone:           p.Zone,
+		Project:        p.Project,
+		ServiceAccount: p.ServiceAccount,
+		Guest: guestAttrsV1_1{
+			Hostname:    p.Guest.Hostname,
+			GuestIpAddr: p.Guest.GuestIpAddr,
+		},
+		Client: clientAttrsV1_1{
+			SourceAddr: p.Client.SourceAddr,
+			LbAddr:     p.Client.LbAddr,
+		},
+		Gae: toGaeV1_1(p.Gae),
+		Gce: toGceV1_1(p.Gce),
+		Gke: toGkeV1_1(p.Gke),
+		Run: toRunV1_1(p.Run),
+		K8s: toK8sV1_1(p.K8s),
+	}
+}
+
+// payloadV1_2 is the 1.2.0 payload, before platform detection. Only cf
+// changed since 1.1.0.
+type payloadV1_2 struct {
+	Version string `json:"version"`
+
+	Request requestAttrsV1_1 `json:"request"`
+
+	NodeName       string `json:"nodename,omitempty"`
+	Zone           string `json:"zone"`
+	Project        string `json:"project"`
+	ServiceAccount string `json:"serviceAccount"`
+
+	Guest  guestAttrsV1_1  `json:"guest"`
+	Client clientAttrsV1_1 `json:"client"`
+
+	Gae *gaeAttrsV1_1 `json:"gae,omitempty"`
+	Gce *gceAttrsV1_1 `json:"gce,omitempty"`
+	Gke *gkeAttrsV1_1 `json:"gke,omitempty"`
+	Run *runAttrsV1_1 `json:"run,omitempty"`
+	Cf  *cfAttrsV1_2  `json:"cf,omitempty"`
+	K8s *k8sAttrsV1_1 `json:"k8s,omitempty"`
+}
+
+type cfAttrsV1_2 struct {
+	Name          string `json:"name"`
+	Generation    string `json:"generation"`
+	Revision      string `json:"revision,omitempty"`
+	Region        string `json:"region"`
+	Runtime       string `json:"runtime,omitempty"`
+	MemoryMB      int    `json:"memoryMb,omitempty"`
+	Target        string `json:"target,omitempty"`
+	SignatureType string `json:"signatureType,omitempty"`
+}
+
+func toCfV1_2(cf *CfAttrs) *cfAttrsV1_2 {
+	if cf == nil {
+		return nil
+	}
+
+	return &cfAttrsV1_2{
+		Name:          cf.Name,
+		Generation:    cf.Generation,
+		Revision:      cf.Revision,
+		Region:        cf.Region,
+		Runtime:       cf.Runtime,
+		MemoryMB:      cf.MemoryMB,
+		Target:        cf.Target,
+		SignatureType: cf.SignatureType,
+	}
+}
+
+func toV1_2(p Payload) interface{} {
+	v1_1 := toV1_1(p).(payloadV1_1)
+
+	return payloadV1_2{
+		Version:        v1_1.Version,
+		Request:        v1_1.Request,
+		NodeName:       v1_1.NodeName,
+		Zone:           v1_1.Zone,
+		Project:        v1_1.Project,
+		ServiceAccount: v1_1.ServiceAccount,
+		Guest:          v1_1.Guest,
+		Client:         v1_1.Client,
+		Gae:            v1_1.Gae,
+		Gce:            v1_1.Gce,
+		Gke:            v1_1.Gke,
+		Run:            v1_1.Run,
+		Cf:             toCfV1_2(p.Cf),
+		K8s:            v1_1.K8s,
+	}
+}
@@ -0,0 +1,181 @@
+package attrs
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+	"unicode/utf8"
+)
+
+// EchoPayload reflects a request back at the client as the server saw it,
+// after any proxies and load balancers in between have had their way with it
+type EchoPayload struct {
+	Method     string              `json:"method"`
+	URL        string              `json:"url"`
+	Proto      string              `json:"proto"`
+	Host       string              `json:"host"`
+	Path       string              `json:"path"`
+	RawQuery   string              `json:"rawQuery,omitempty"`
+	Query      map[string][]string `json:"query,omitempty"`
+	Headers    map[string][]string `json:"headers"`
+	RemoteAddr string              `json:"remoteAddr"`
+	LocalAddr  string              `json:"localAddr,omitempty"`
+
+	ContentLength    int64    `json:"contentLength"`
+	TransferEncoding []string `json:"transferEncoding,omitempty"`
+
+	Body   EchoBody   `json:"body"`
+	TLS    *EchoTLS   `json:"tls,omitempty"`
+	Timing EchoTiming `json:"timing"`
+}
+
+type EchoBody struct {
+	Size      int64  `json:"size"`
+	Truncated bool   `json:"truncated"`
+	Encoding  string `json:"encoding,omitempty"`
+	Content   string `json:"content,omitempty"`
+}
+
+type EchoTLS struct {
+	Version            string `json:"version"`
+	CipherSuite        string `json:"cipherSuite"`
+	ServerName         string `json:"serverName,omitempty"`
+	NegotiatedProtocol string `json:"negotiatedProtocol,omitempty"`
+	Resumed            bool   `json:"resumed"`
+	ClientCertSubject  string `json:"clientCertSubject,omitempty"`
+	ClientCertIssuer   string `json:"clientCertIssuer,omitempty"`
+}
+
+type EchoTiming struct {
+	Received time.Time `json:"received"`
+	BodyRead string    `json:"bodyRead"`
+	Elapsed  string    `json:"elapsed"`
+}
+
+var tlsVersions = map[uint16]string{
+	tls.VersionTLS10: "TLS 1.0",
+	tls.VersionTLS11: "TLS 1.1",
+	tls.VersionTLS12: "TLS 1.2",
+	tls.VersionTLS13: "TLS 1.3",
+}
+
+func getTLSAttrs(cs *tls.ConnectionState) *EchoTLS {
+	if cs == nil {
+		return nil
+	}
+
+	version, found := tlsVersions[cs.Version]
+	if !found {
+		version = fmt.Sprintf("0x%04x", cs.Version)
+	}
+
+	attrs := &EchoTLS{
+		Version:            version,
+		CipherSuite:        tls.CipherSuiteName(cs.CipherSuite),
+		ServerName:         cs.ServerName,
+		NegotiatedProtocol: cs.NegotiatedProtocol,
+		Resumed:            cs.DidResume,
+	}
+
+	if len(cs.PeerCertificates) > 0 {
+		attrs.ClientCertSubject = cs.PeerCertificates[0].Subject.String()
+		attrs.ClientCertIssuer = cs.PeerCertificates[0].Issuer.String()
+	}
+
+	return attrs
+}
+
+// ErrBodyTooLarge is returned for a body longer than the most we'll read
+var ErrBodyTooLarge = errors.New("request body too large")
+
+// readBody keeps up to maxBytes of the body, and counts the rest up to limit.
+// Bodies that aren't valid UTF-8 are base64 encoded.
+func readBody(r *http.Request, maxBytes int64, limit int64) (EchoBody, error) {
+	body := EchoBody{}
+
+	if r.ContentLength > limit {
+		return body, ErrBodyTooLarge
+	}
+
+	kept, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBytes))
+	if err != nil {
+		return body, err
+	}
+
+	// a byte past the limit is enough to know it's over, otherwise a client
+	// could keep us reading for as long as it keeps sending
+	rest, err := io.Copy(ioutil.Discard, io.LimitReader(r.Body, limit-int64(len(kept))+1))
+	if err != nil {
+		return body, err
+	}
+
+	body.Size = int64(len(kept)) + rest
+	if body.Size > limit {
+		return body, ErrBodyTooLarge
+	}
+	body.Truncated = rest > 0
+
+	if len(kept) == 0 {
+		return body, nil
+	}
+
+	if utf8.Valid(kept) {
+		body.Content = string(kept)
+	} else {
+		body.Encoding = "base64"
+		body.Content = base64.StdEncoding.EncodeToString(kept)
+	}
+
+	return body, nil
+}
+
+// GetEchoAttrs describes the request, keeping up to maxBodyBytes of its body
+// and failing with ErrBodyTooLarge if it's longer than bodyLimit. received is
+// when the handler got the request, for the timings.
+func GetEchoAttrs(r *http.Request, received time.Time, maxBodyBytes int64, bodyLimit int64) (EchoPayload, error) {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	echo := EchoPayload{
+		Method:           r.Method,
+		URL:              fmt.Sprintf("%v://%v%v", scheme, r.Host, r.RequestURI),
+		Proto:            r.Proto,
+		Host:             r.Host,
+		Path:             r.URL.Path,
+		RawQuery:         r.URL.RawQuery,
+		Query:            r.URL.Query(),
+		Headers:          r.Header,
+		RemoteAddr:       r.RemoteAddr,
+		ContentLength:    r.ContentLength,
+		TransferEncoding: r.TransferEncoding,
+		TLS:              getTLSAttrs(r.TLS),
+	}
+
+	if len(echo.Query) == 0 {
+		echo.Query = nil
+	}
+
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		echo.LocalAddr = addr.String()
+	}
+
+	body, err := readBody(r, maxBodyBytes, bodyLimit)
+	if err != nil {
+		return echo, fmt.Errorf("error reading request body: %w", err)
+	}
+	echo.Body = body
+
+	echo.Timing.Received = received
+	echo.Timing.BodyRead = time.Since(received).String()
+	echo.Timing.Elapsed = echo.Timing.BodyRead
+
+	return echo, nil
+}
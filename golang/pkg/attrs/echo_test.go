@@ -0,0 +1,125 @@
+package attrs
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// endless is a body a client never stops sending
+type endless struct{}
+
+func (endless) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'a'
+	}
+	return len(p), nil
+}
+
+func TestReadBody(t *testing.T) {
+	tests := []struct {
+		name string
+		body io.Reader
+		// the Content-Length sent, -1 for a chunked body
+		contentLength int64
+		want          EchoBody
+		wantErr       error
+	}{
+		{
+			name: "empty",
+			body: strings.NewReader(""),
+			want: EchoBody{},
+		},
+		{
+			name:          "kept whole",
+			body:          strings.NewReader("hello"),
+			contentLength: 5,
+			want:          EchoBody{Size: 5, Content: "hello"},
+		},
+		{
+			name:          "truncated",
+			body:          strings.NewReader("hello, world"),
+			contentLength: 12,
+			want:          EchoBody{Size: 12, Truncated: true, Content: "hello, wor"},
+		},
+		{
+			name:          "counted up to the limit",
+			body:          strings.NewReader(strings.Repeat("a", 64)),
+			contentLength: -1,
+			want:          EchoBody{Size: 64, Truncated: true, Content: "aaaaaaaaaa"},
+		},
+		{
+			name:          "not UTF-8",
+			body:          strings.NewReader("\xff\xfe"),
+			contentLength: 2,
+			want:          EchoBody{Size: 2, Encoding: "base64", Content: "//4="},
+		},
+		{
+			name:          "Content-Length over the limit",
+			body:          strings.NewReader(""),
+			contentLength: 65,
+			wantErr:       ErrBodyTooLarge,
+		},
+		{
+			name:          "chunked over the limit",
+			body:          strings.NewReader(strings.Repeat("a", 65)),
+			contentLength: -1,
+			wantErr:       ErrBodyTooLarge,
+		},
+		{
+			name:          "never ending",
+			body:          endless{},
+			contentLength: -1,
+			wantErr:       ErrBodyTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/echo", tt.body)
+		r.ContentLength = tt.contentLength
+
+		got, err := readBody(r, 10, 64)
+		if tt.wantErr != nil {
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("%v: error = %v, want %v", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%v: unexpected error %v", tt.name, err)
+		} else if got != tt.want {
+			t.Errorf("%v: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGetEchoAttrs(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPut, "/echo/a?b=1", strings.NewReader("body"))
+	r.Header.Set("X-Forwarded-For", "10.0.0.1")
+
+	echo, err := GetEchoAttrs(r, time.Now(), 1024, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if echo.Method != http.MethodPut || echo.URL != "http://example.com/echo/a?b=1" || echo.Path != "/echo/a" {
+		t.Errorf("got %v %v (path %v)", echo.Method, echo.URL, echo.Path)
+	}
+	if echo.Query["b"][0] != "1" || echo.Headers["X-Forwarded-For"][0] != "10.0.0.1" {
+		t.Errorf("query %v, headers %v", echo.Query, echo.Headers)
+	}
+	if echo.Body.Content != "body" || echo.TLS != nil {
+		t.Errorf("body %+v, TLS %+v", echo.Body, echo.TLS)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/echo", endless{})
+	r.ContentLength = -1
+	if _, err := GetEchoAttrs(r, time.Now(), 1024, 4096); !errors.Is(err, ErrBodyTooLarge) {
+		t.Errorf("error = %v, want ErrBodyTooLarge", err)
+	}
+}
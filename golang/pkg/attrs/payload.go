@@ -3,78 +3,94 @@ package attrs
 import (
 	"bufio"
 	"context"
-	"encoding/json"
-	"fmt"
+	"errors"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
 	"regexp"
 	"strings"
+	"sync"
 
 	"go.uber.org/zap"
 
 	trace "helloworld-http/pkg/trace"
 	gcp "helloworld-http/pkg/gcp"
-	util "helloworld-http/pkg/util"
+	platform "helloworld-http/pkg/platform"
 )
 
-const PAYLOAD_VERSION = "1.1.0"
+const PAYLOAD_VERSION = "1.3.0"
 
 type Payload struct {
 	Version     	string `json:"version"`
 
-	Request 	requestAttrs `json:"request"`
+	Request 	RequestAttrs `json:"request"`
 
 	NodeName       string `json:"nodename,omitempty"`
 	Zone           string `json:"zone"`
 	Project        string `json:"project"`
 	ServiceAccount string `json:"serviceAccount"`
 
-	Guest  guestAttrs  `json:"guest"`
-	Client clientAttrs `json:"client"`
+	Guest  GuestAttrs  `json:"guest"`
+	Client ClientAttrs `json:"client"`
 
-	Gae *gaeAttrs `json:"gae,omitempty"`
-	Gce *gceAttrs `json:"gce,omitempty"`
-	Gke *gkeAttrs `json:"gke,omitempty"`
-	Run *runAttrs `json:"run,omitempty"`
-	Cf  *cfAttrs  `json:"cf,omitempty"`
-	K8s *k8sAttrs `json:"k8s,omitempty"`
+	Platform PlatformAttrs `json:"platform"`
+
+	Gae *GaeAttrs `json:"gae,omitempty"`
+	Gce *GceAttrs `json:"gce,omitempty"`
+	Gke *GkeAttrs `json:"gke,omitempty"`
+	Run *RunAttrs `json:"run,omitempty"`
+	Cf  *CfAttrs  `json:"cf,omitempty"`
+	K8s *K8sAttrs `json:"k8s,omitempty"`
 }
 
-type requestAttrs struct {
+type RequestAttrs struct {
 	RequestPath 	string `json:"requestPath"`
 	RequestHeaders 	map[string][]string `json:"requestHeaders"`
 }
 
-type guestAttrs struct {
+type GuestAttrs struct {
 	Hostname    string `json:"hostname"`
 	GuestIpAddr string `json:"guestIp"`
 }
 
-type clientAttrs struct {
+type ClientAttrs struct {
 	SourceAddr string  `json:"sourceAddr"`
 	LbAddr     *string `json:"lbAddr,omitempty"`
 }
 
-type gaeAttrs struct {
+// PlatformAttrs is where we think we're running, and why
+type PlatformAttrs struct {
+	Name       string              `json:"name"`
+	Confidence string              `json:"confidence"`
+	Signals    []string            `json:"signals,omitempty"`
+	Candidates []PlatformCandidate `json:"candidates,omitempty"`
+}
+
+// PlatformCandidate is a less likely platform that also matched
+type PlatformCandidate struct {
+	Name       string `json:"name"`
+	Confidence string `json:"confidence"`
+}
+
+type GaeAttrs struct {
 	Region     string `json:"region"`
 	InstanceId string `json:"instanceId"`
 }
 
-type gceAttrs struct {
+type GceAttrs struct {
 	PrivateIpAddr string  `json:"privateIp"`
 	MachineType   string  `json:"machineType,omitempty"`
 	Preemptible   bool    `json:"preemptible"`
 	MigName       *string `json:"migName,omitempty"`
 }
 
-type gkeAttrs struct {
+type GkeAttrs struct {
 	ClusterName   string `json:"clusterName"`
 	ClusterRegion string `json:"clusterRegion"`
 }
 
-type k8sAttrs struct {
+type K8sAttrs struct {
 	PodName        string             `json:"podName"`
 	PodIpAddr      string             `json:"podIpAddr"`
 	Namespace      string             `json:"namespace"`
@@ -84,12 +100,24 @@ type k8sAttrs struct {
 	NodeIpAddr     string             `json:"nodeIpAddr"`
 }
 
-type runAttrs struct {
-	Region     string `json:"region"`
-	InstanceId string `json:"instanceId"`
+type RunAttrs struct {
+	Region        string `json:"region"`
+	InstanceId    string `json:"instanceId"`
+	Service       string `json:"service"`
+	Revision      string `json:"revision"`
+	Configuration string `json:"configuration"`
+	Concurrency   int    `json:"concurrency,omitempty"`
 }
 
-type cfAttrs struct {
+type CfAttrs struct {
+	Name          string `json:"name"`
+	Generation    string `json:"generation"`
+	Revision      string `json:"revision,omitempty"`
+	Region        string `json:"region"`
+	Runtime       string `json:"runtime,omitempty"`
+	MemoryMB      int    `json:"memoryMb,omitempty"`
+	Target        string `json:"target,omitempty"`
+	SignatureType string `json:"signatureType,omitempty"`
 }
 
 // GetLocalIP returns the non loopback local IP of the host
@@ -138,94 +166,75 @@ func getKeyValsFromDisk(filename string) *map[string]string {
 	return &labels
 }
 
-func GetAllAttrs(ctx context.Context, r *http.Request, t *trace.TraceConfig) (Payload, error) {
-	allVals := Payload{}
+var (
+	regionPrefix      = regexp.MustCompile(`.*/regions/`)
+	machineTypePrefix = regexp.MustCompile(`.*/machineTypes/`)
+	migPrefix         = regexp.MustCompile(`.*/instanceGroupManagers/`)
+)
 
-	span := t.StartTrace(ctx, "file io")
-	vers, err := ioutil.ReadFile("version.txt")
+// metadataStr looks up a string in the metadata tree, only complaining if
+// the value is there but isn't shaped the way we expect
+func metadataStr(path string, metadata map[string]interface{}) (string, bool) {
+	val, err := gcp.GetMetaDataStr(path, metadata)
 	if err != nil {
-		_ = fmt.Errorf("cannot find file, version.txt: %s", err)
+		if !errors.Is(err, gcp.ErrNotFound) {
+			zap.S().Warnf("Unexpected metadata: %v", err)
+		}
+		return "", false
 	}
-	span.End()
 
-	allVals.Version = string(vers)
-
-	allVals.Request = requestAttrs{}
-	allVals.Request.RequestPath = r.URL.Path
-	allVals.Request.RequestHeaders = r.Header
+	return val, true
+}
 
-	span = t.StartTrace(ctx, "gcp metadata server")
-	var metadata map[string]interface{}
-	metadataStr, err := gcp.GetMetaData(ctx)
-	if err != nil {
-		zap.S().Errorf("Enable to retrieve metadata: %s", err)
-		return allVals, err
+// firstMetadataStr looks up the first of the paths that's in the metadata tree
+func firstMetadataStr(metadata map[string]interface{}, paths ...string) (string, bool) {
+	for _, path := range paths {
+		if val, ok := metadataStr(path, metadata); ok {
+			return val, true
+		}
 	}
-	span.End()
-
-	// dump out the metadata to the zap.S().
-	//var outJSON bytes.Buffer
-	//json.Indent(&outJSON, []byte(*metadataStr), "", "  ")
 
-	json.Unmarshal([]byte(*metadataStr), &metadata)
+	return "", false
+}
 
-	nodeName := gcp.GetMetaDataStrVal("instance/hostname", metadata)
-	if nodeName != nil {
-		allVals.NodeName = *nodeName
-	}
+// static attributes only change when the metadata does, so they are built
+// once per metadata generation and copied into each request's Payload
+var (
+	staticMu         sync.Mutex
+	staticGeneration uint64
+	staticVals       *Payload
+)
 
-	zoneStr := gcp.GetMetaDataStrVal("instance/zone", metadata)
-	if zoneStr != nil {
-		zoneArr := strings.Split(*zoneStr, "/")
-		zone := zoneArr[len(zoneArr)-1]
+// getStaticAttrs builds the parts of the Payload that come from the metadata
+// tree, the version file and the guest itself
+func getStaticAttrs(metadata map[string]interface{}) Payload {
+	allVals := Payload{}
 
-		allVals.Zone = zone
+	vers, err := ioutil.ReadFile("version.txt")
+	if err != nil {
+		zap.S().Warnf("cannot find file, version.txt: %s", err)
 	}
 
-	project := gcp.GetMetaDataStrVal("project/projectId", metadata)
-	if project != nil {
-		allVals.Project = *project
-	}
+	allVals.Version = string(vers)
 
-	serviceAccount := gcp.GetMetaDataStrVal("instance/serviceAccounts/default/email", metadata)
-	if serviceAccount != nil {
-		allVals.ServiceAccount = *serviceAccount
+	if nodeName, ok := metadataStr("instance/hostname", metadata); ok {
+		allVals.NodeName = nodeName
 	}
 
-	/* Begin client attributes */
-
-	// get the XFF header
-	xffHdr := r.Header.Get("x-forwarded-for")
-
-	if xffHdr != "" {
-		ips := strings.Split(xffHdr, ",")
-
-		// you can only trust the first two IPs, throw everything else away
-		if len(ips) > 0 {
-			allVals.Client.SourceAddr = ips[0]
-		}
-
-		// if we're in GCE and there's two IPs in this list, the second one is our LB
-		if len(ips) > 1 {
-			allVals.Client.LbAddr = &ips[1]
-		}
-
+	if zoneStr, ok := metadataStr("instance/zone", metadata); ok {
+		zoneArr := strings.Split(zoneStr, "/")
+		zone := zoneArr[len(zoneArr)-1]
 
-	} else {
-		// if xff header is not there, then it must be a direct client
-		clientIpPort := r.RemoteAddr
-		portSepIdx := strings.LastIndex(clientIpPort, ":")
-		clientIp := clientIpPort[0:portSepIdx]
-		allVals.Client.SourceAddr = clientIp
+		allVals.Zone = zone
 	}
 
-	/* if we're in app engine, this header gets set, esp if we're not coming from a load balancer */
-	xaecipHdr := r.Header.Get("x-appengine-user-ip")
-	if xaecipHdr != "" {
-		allVals.Client.SourceAddr = xaecipHdr
+	if project, ok := metadataStr("project/projectId", metadata); ok {
+		allVals.Project = project
 	}
 
-	/* End client attributes */
+	if serviceAccount, ok := metadataStr("instance/serviceAccounts/default/email", metadata); ok {
+		allVals.ServiceAccount = serviceAccount
+	}
 
 	/* Begin guest attributes */
 	host, _ := os.Hostname()
@@ -235,78 +244,71 @@ func GetAllAttrs(ctx context.Context, r *http.Request, t *trace.TraceConfig) (Pa
 	allVals.Guest.GuestIpAddr = localIp
 	/* End guest attributes */
 
-	scopes := gcp.GetMetaDataArrVal("instance/serviceAccounts/default/scopes", metadata)
-	if util.ArrayContains(scopes, "https://www.googleapis.com/auth/appengine.apis") {
-		/* Begin GAE attributes */
-		// if we have appengine APIs in scope, we're probably in app engine
-		if allVals.Gae == nil {
-			allVals.Gae = &gaeAttrs{}
-		}
+	detection := platform.Detect(os.Getenv, platform.FileExists, metadata)
+	allVals.Platform = getPlatformAttrs(detection)
 
-		instanceId := gcp.GetMetaDataStrVal("instance/id", metadata)
-		allVals.Gae.InstanceId = *instanceId
+	switch detection.Best().Platform {
+	case platform.PLATFORM_CF:
+		/* Begin Cloud Functions attributes */
+		// gen2 functions also run on Cloud Run but are reported as functions
+		allVals.Cf = getCfAttrs(metadata)
+		/* End Cloud Functions attributes */
+	case platform.PLATFORM_GAE:
+		/* Begin GAE attributes */
+		allVals.Gae = &GaeAttrs{}
 
-		regionStr := gcp.GetMetaDataStrVal("instance/region", metadata)
-		rexp := regexp.MustCompile(`.*/regions/`)
-		region := rexp.ReplaceAllString(*regionStr, "")
-		allVals.Gae.Region = region
-	/* End GAE attributes */
-	} else if util.ArrayContains(scopes, "https://www.googleapis.com/auth/calendar") {
-		/* Begin Run attributes */
-		/* a guess that these types of scopes mean we're in cloud run, if we're not in app engine */
-		// if we have appengine APIs in scope, we're probably in app engine
-		if allVals.Run == nil {
-			allVals.Run = &runAttrs{}
+		if instanceId := os.Getenv("GAE_INSTANCE"); instanceId != "" {
+			allVals.Gae.InstanceId = instanceId
+		} else if instanceId, ok := metadataStr("instance/id", metadata); ok {
+			allVals.Gae.InstanceId = instanceId
 		}
 
-		instanceId := gcp.GetMetaDataStrVal("instance/id", metadata)
-		allVals.Run.InstanceId = *instanceId
-
-		regionStr := gcp.GetMetaDataStrVal("instance/region", metadata)
-		rexp := regexp.MustCompile(`.*/regions/`)
-		region := rexp.ReplaceAllString(*regionStr, "")
-		allVals.Run.Region = region
+		if regionStr, ok := metadataStr("instance/region", metadata); ok {
+			allVals.Gae.Region = regionPrefix.ReplaceAllString(regionStr, "")
+		}
+		/* End GAE attributes */
+	case platform.PLATFORM_RUN:
+		/* Begin Run attributes */
+		allVals.Run = getRunAttrs(metadata)
 		/* End Run attributes */
 	}
 
 	/* Begin GCE attributes */
-	machineType := gcp.GetMetaDataStrVal("instance/machineType", metadata)
-	if machineType != nil {
+	if machineType, ok := metadataStr("instance/machineType", metadata); ok {
 		// assumption: all GCE machines will have the machine-type property
 		if allVals.Gce == nil {
-			allVals.Gce = &gceAttrs{}
+			allVals.Gce = &GceAttrs{}
 		}
 
-		rexp := regexp.MustCompile(`.*/machineTypes/`)
-		machineTypeStr := rexp.ReplaceAllString(*machineType, "")
-		allVals.Gce.MachineType = machineTypeStr
+		allVals.Gce.MachineType = machineTypePrefix.ReplaceAllString(machineType, "")
 	}
 
-	internalIP := gcp.GetMetaDataStrVal("instance/networkInterfaces[0]/ip", metadata)
-	if internalIP != nil {
+	if internalIP, ok := metadataStr("instance/networkInterfaces[0]/ip", metadata); ok {
 		if allVals.Gce == nil {
-			allVals.Gce = &gceAttrs{}
+			allVals.Gce = &GceAttrs{}
 		}
 
-		allVals.Gce.PrivateIpAddr = *internalIP
+		allVals.Gce.PrivateIpAddr = internalIP
 	}
 
-	createdBy := gcp.GetMetaDataStrVal("instance/attributes/createdBy", metadata)
-	if createdBy != nil {
-		rexp := regexp.MustCompile(`.*/instanceGroupManagers/`)
-		migNameStr := rexp.ReplaceAllString(*createdBy, "")
+	if createdBy, ok := metadataStr("instance/attributes/createdBy", metadata); ok {
+		migNameStr := migPrefix.ReplaceAllString(createdBy, "")
 
 		if allVals.Gce == nil {
-			allVals.Gce = &gceAttrs{}
+			allVals.Gce = &GceAttrs{}
 		}
 
 		allVals.Gce.MigName = &migNameStr
 	}
 
-	preemptible := gcp.GetMetaDataStrVal("instance/scheduling/preemptible", metadata)
-	if preemptible != nil && *preemptible == "TRUE" {
+	preemptible, err := gcp.GetMetaDataBool("instance/scheduling/preemptible|FALSE", metadata)
+	if err != nil {
+		zap.S().Warnf("Unexpected metadata: %v", err)
+	}
+
+	if preemptible {
 		if allVals.Gce == nil {
-			allVals.Gce = &gceAttrs{}
+			allVals.Gce = &GceAttrs{}
 		}
 
 		allVals.Gce.Preemptible = true
@@ -319,30 +321,70 @@ func GetAllAttrs(ctx context.Context, r *http.Request, t *trace.TraceConfig) (Pa
 	/* End GCE attributes */
 
 	/* Begin GKE attributes */
-	clusterName := gcp.GetMetaDataStrVal("instance/attributes/clusterName", metadata)
-	if clusterName != nil {
+	// GKE sets cluster-name and cluster-location, the fake trees use camel case
+	if clusterName, ok := firstMetadataStr(metadata, "instance/attributes/cluster-name", "instance/attributes/clusterName"); ok {
 		if allVals.Gke == nil {
-			allVals.Gke = &gkeAttrs{}
+			allVals.Gke = &GkeAttrs{}
 		}
 
-		allVals.Gke.ClusterName = *clusterName
+		allVals.Gke.ClusterName = clusterName
 	}
 
-	region := gcp.GetMetaDataStrVal("instance/attributes/clusterLocation", metadata)
-	if region != nil {
+	if region, ok := firstMetadataStr(metadata, "instance/attributes/cluster-location", "instance/attributes/clusterLocation"); ok {
 		if allVals.Gke == nil {
-			allVals.Gke = &gkeAttrs{}
+			allVals.Gke = &GkeAttrs{}
 		}
 
-		allVals.Gke.ClusterRegion = *region
+		allVals.Gke.ClusterRegion = region
 	}
 
 	/* End GKE attributes */
 
+	return allVals
+}
+
+// getStaticSnapshot returns the cached static attributes, rebuilding them if
+// the metadata has changed since they were last built
+func getStaticSnapshot(ctx context.Context, t *trace.TraceConfig) (Payload, error) {
+	spanCtx, span := t.StartTrace(ctx, "gcp metadata server")
+	snapshot, err := gcp.GetMetaDataSnapshot(spanCtx)
+	span.End()
+	if err != nil {
+		zap.S().Errorf("Unable to retrieve metadata: %s", err)
+		return Payload{}, err
+	}
+
+	staticMu.Lock()
+	defer staticMu.Unlock()
+
+	if staticVals != nil && snapshot.Generation != 0 && snapshot.Generation == staticGeneration {
+		return *staticVals, nil
+	}
+
+	vals := getStaticAttrs(snapshot.Tree)
+	if snapshot.Generation != 0 {
+		zap.L().Debug("Rebuilt static attributes",
+			zap.Uint64("generation", snapshot.Generation))
+	}
+
+	staticGeneration = snapshot.Generation
+	staticVals = &vals
+
+	return vals, nil
+}
+
+// GetProcessAttrs builds the parts of the Payload that don't depend on a
+// request, for when there isn't one, like a batch job
+func GetProcessAttrs(ctx context.Context, t *trace.TraceConfig) (Payload, error) {
+	allVals, err := getStaticSnapshot(ctx, t)
+	if err != nil {
+		return allVals, err
+	}
+
 	/* Begin K8S Attributes -- should be passed from the Downward API*/
 	if k8sNodeName := os.Getenv("K8S_NODE_NAME"); k8sNodeName != "" {
 		if allVals.K8s == nil {
-			allVals.K8s = &k8sAttrs{}
+			allVals.K8s = &K8sAttrs{}
 		}
 
 		allVals.K8s.NodeName = k8sNodeName
@@ -350,7 +392,7 @@ func GetAllAttrs(ctx context.Context, r *http.Request, t *trace.TraceConfig) (Pa
 
 	if k8sNodeIp := os.Getenv("K8S_NODE_IP"); k8sNodeIp != "" {
 		if allVals.K8s == nil {
-			allVals.K8s = &k8sAttrs{}
+			allVals.K8s = &K8sAttrs{}
 		}
 
 		allVals.K8s.NodeIpAddr = k8sNodeIp
@@ -358,7 +400,7 @@ func GetAllAttrs(ctx context.Context, r *http.Request, t *trace.TraceConfig) (Pa
 
 	if k8sPodName := os.Getenv("K8S_POD_NAME"); k8sPodName != "" {
 		if allVals.K8s == nil {
-			allVals.K8s = &k8sAttrs{}
+			allVals.K8s = &K8sAttrs{}
 		}
 
 		allVals.K8s.PodName = k8sPodName
@@ -366,7 +408,7 @@ func GetAllAttrs(ctx context.Context, r *http.Request, t *trace.TraceConfig) (Pa
 
 	if k8sPodNamespace := os.Getenv("K8S_POD_NAMESPACE"); k8sPodNamespace != "" {
 		if allVals.K8s == nil {
-			allVals.K8s = &k8sAttrs{}
+			allVals.K8s = &K8sAttrs{}
 		}
 
 		allVals.K8s.Namespace = k8sPodNamespace
@@ -374,7 +416,7 @@ func GetAllAttrs(ctx context.Context, r *http.Request, t *trace.TraceConfig) (Pa
 
 	if k8sPodIp := os.Getenv("K8S_POD_IP"); k8sPodIp != "" {
 		if allVals.K8s == nil {
-			allVals.K8s = &k8sAttrs{}
+			allVals.K8s = &K8sAttrs{}
 		}
 
 		allVals.K8s.PodIpAddr = k8sPodIp
@@ -382,7 +424,7 @@ func GetAllAttrs(ctx context.Context, r *http.Request, t *trace.TraceConfig) (Pa
 
 	if k8sServiceAccount := os.Getenv("K8S_POD_SERVICE_ACCOUNT"); k8sServiceAccount != "" {
 		if allVals.K8s == nil {
-			allVals.K8s = &k8sAttrs{}
+			allVals.K8s = &K8sAttrs{}
 		}
 
 		allVals.K8s.ServiceAccount = k8sServiceAccount
@@ -392,7 +434,7 @@ func GetAllAttrs(ctx context.Context, r *http.Request, t *trace.TraceConfig) (Pa
 	labels := getKeyValsFromDisk("/podinfo/labels")
 	if labels != nil {
 		if allVals.K8s == nil {
-			allVals.K8s = &k8sAttrs{}
+			allVals.K8s = &K8sAttrs{}
 		}
 
 		allVals.K8s.Labels = labels
@@ -401,5 +443,53 @@ func GetAllAttrs(ctx context.Context, r *http.Request, t *trace.TraceConfig) (Pa
 
 	/* End K8S Attributes */
 
+	return allVals, nil
+}
+
+func GetAllAttrs(ctx context.Context, r *http.Request, t *trace.TraceConfig) (Payload, error) {
+	allVals, err := GetProcessAttrs(ctx, t)
+	if err != nil {
+		return allVals, err
+	}
+
+	allVals.Request = RequestAttrs{}
+	allVals.Request.RequestPath = r.URL.Path
+	allVals.Request.RequestHeaders = r.Header
+
+	/* Begin client attributes */
+
+	// get the XFF header
+	xffHdr := r.Header.Get("x-forwarded-for")
+
+	if xffHdr != "" {
+		ips := strings.Split(xffHdr, ",")
+
+		// you can only trust the first two IPs, throw everything else away
+		if len(ips) > 0 {
+			allVals.Client.SourceAddr = ips[0]
+		}
+
+		// if we're in GCE and there's two IPs in this list, the second one is our LB
+		if len(ips) > 1 {
+			allVals.Client.LbAddr = &ips[1]
+		}
+
+
+	} else {
+		// if xff header is not there, then it must be a direct client
+		clientIpPort := r.RemoteAddr
+		portSepIdx := strings.LastIndex(clientIpPort, ":")
+		clientIp := clientIpPort[0:portSepIdx]
+		allVals.Client.SourceAddr = clientIp
+	}
+
+	/* if we're in app engine, this header gets set, esp if we're not coming from a load balancer */
+	xaecipHdr := r.Header.Get("x-appengine-user-ip")
+	if xaecipHdr != "" {
+		allVals.Client.SourceAddr = xaecipHdr
+	}
+
+	/* End client attributes */
+
 	return allVals, nil
 }
\ No newline at end of file
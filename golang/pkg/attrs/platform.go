@@ -0,0 +1,25 @@
+package attrs
+
+import (
+	platform "helloworld-http/pkg/platform"
+)
+
+// getPlatformAttrs reports the detected platform, along with the others that
+// matched with less confidence
+func getPlatformAttrs(d platform.Detection) PlatformAttrs {
+	best := d.Best()
+	attrs := PlatformAttrs{
+		Name:       best.Platform,
+		Confidence: best.Confidence.String(),
+		Signals:    best.Signals,
+	}
+
+	for i := 1; i < len(d.Candidates); i++ {
+		attrs.Candidates = append(attrs.Candidates, PlatformCandidate{
+			Name:       d.Candidates[i].Platform,
+			Confidence: d.Candidates[i].Confidence.String(),
+		})
+	}
+
+	return attrs
+}
@@ -0,0 +1,39 @@
+package attrs
+
+import (
+	"os"
+	"strconv"
+
+	"go.uber.org/zap"
+)
+
+// getRunAttrs fills in a Cloud Run service's attributes from the environment
+// Cloud Run sets and the metadata server.
+//
+// Cloud Run doesn't tell the container its concurrency setting, so that's
+// read from CLOUD_RUN_CONCURRENCY when the deployment sets it to match.
+func getRunAttrs(metadata map[string]interface{}) *RunAttrs {
+	run := &RunAttrs{
+		Service:       os.Getenv("K_SERVICE"),
+		Revision:      os.Getenv("K_REVISION"),
+		Configuration: os.Getenv("K_CONFIGURATION"),
+	}
+
+	if instanceId, ok := metadataStr("instance/id", metadata); ok {
+		run.InstanceId = instanceId
+	}
+
+	if regionStr, ok := metadataStr("instance/region", metadata); ok {
+		run.Region = regionPrefix.ReplaceAllString(regionStr, "")
+	}
+
+	if concurrency := os.Getenv("CLOUD_RUN_CONCURRENCY"); concurrency != "" {
+		var err error
+		run.Concurrency, err = strconv.Atoi(concurrency)
+		if err != nil {
+			zap.S().Warnf("Invalid CLOUD_RUN_CONCURRENCY %q: %v", concurrency, err)
+		}
+	}
+
+	return run
+}
@@ -0,0 +1,122 @@
+package attrs
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+const jsonSchemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// Schema is the subset of JSON Schema needed to describe the payloads
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	ID                   string             `json:"$id,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Type                 interface{}        `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// nullable widens a schema's type to also allow null, for pointers, maps and
+// slices that are marshalled even when they're nil
+func nullable(s *Schema) *Schema {
+	if t, ok := s.Type.(string); ok {
+		s.Type = []string{t, "null"}
+	}
+	return s
+}
+
+// schemaFor describes how encoding/json marshals values of type t
+func schemaFor(t reflect.Type) *Schema {
+	if t == timeType {
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaFor(t.Elem())
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: schemaFor(t.Elem())}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: schemaFor(t.Elem())}
+	case reflect.Struct:
+		s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+		addFields(s, t)
+		return s
+	default:
+		// interface{} and friends could be anything
+		return &Schema{}
+	}
+}
+
+// addFields adds a struct's fields to an object schema, following the json
+// struct tags. Fields without omitempty are always present, so required.
+func addFields(s *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(tag, ",")
+		omitEmpty := strings.Contains(","+opts+",", ",omitempty,")
+
+		if field.Anonymous && name == "" {
+			ft := field.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				addFields(s, ft)
+				continue
+			}
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		fs := schemaFor(field.Type)
+		switch field.Type.Kind() {
+		case reflect.Ptr, reflect.Map, reflect.Slice:
+			if !omitEmpty {
+				nullable(fs)
+			}
+		}
+
+		s.Properties[name] = fs
+		if !omitEmpty {
+			s.Required = append(s.Required, name)
+		}
+	}
+}
+
+// GenerateSchema builds the JSON Schema for the JSON encoding of v
+func GenerateSchema(v interface{}, id string, title string) *Schema {
+	s := schemaFor(reflect.TypeOf(v))
+	s.Schema = jsonSchemaDraft
+	s.ID = id
+	s.Title = title
+
+	return s
+}
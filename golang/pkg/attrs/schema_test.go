@@ -0,0 +1,175 @@
+package attrs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "write the schema of payload versions that don't have one in testdata yet")
+
+func goldenFile(version string) string {
+	return filepath.Join("testdata", fmt.Sprintf("payload-%v.json", version))
+}
+
+func marshalSchema(t *testing.T, version string) []byte {
+	t.Helper()
+
+	s, err := PayloadSchema(version)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return append(out, '\n')
+}
+
+// TestPayloadSchemas fails when the JSON shape of a payload version doesn't
+// match its golden file. Files are only ever written for new versions, with
+// -update, since they're the record of what each version looked like.
+func TestPayloadSchemas(t *testing.T) {
+	for _, version := range PayloadVersions() {
+		version := version
+		t.Run(version, func(t *testing.T) {
+			generated := marshalSchema(t, version)
+
+			checkedIn, err := ioutil.ReadFile(goldenFile(version))
+			if errors.Is(err, os.ErrNotExist) && *update {
+				if err := ioutil.WriteFile(goldenFile(version), generated, 0644); err != nil {
+					t.Fatal(err)
+				}
+				t.Logf("wrote %v", goldenFile(version))
+				return
+			}
+			if err != nil {
+				t.Fatalf("no schema checked in, write it with go test ./pkg/attrs -update: %v", err)
+			}
+
+			if !bytes.Equal(generated, checkedIn) {
+				if version == PAYLOAD_VERSION {
+					t.Errorf("payload shape changed, bump PAYLOAD_VERSION and keep the old shape in payloadVersions")
+				} else {
+					t.Errorf("shape of an old payload version changed")
+				}
+			}
+		})
+	}
+}
+
+// lookup follows a dotted path through decoded JSON objects
+func lookup(m map[string]interface{}, path string) (interface{}, bool) {
+	var v interface{} = m
+	for _, key := range strings.Split(path, ".") {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = obj[key]; !ok {
+			return nil, false
+		}
+	}
+
+	return v, true
+}
+
+func TestForVersion(t *testing.T) {
+	machineType := "e2-small"
+	p := Payload{
+		Version:  PAYLOAD_VERSION,
+		Zone:     "us-central1-a",
+		Platform: PlatformAttrs{Name: "run", Confidence: "high"},
+		Gce:      &GceAttrs{PrivateIpAddr: "10.0.0.2", MachineType: machineType},
+		Run:      &RunAttrs{Region: "us-central1", InstanceId: "1234", Service: "hello"},
+		Cf:       &CfAttrs{Name: "fn", Generation: CF_GEN2},
+	}
+
+	tests := []struct {
+		version string
+		present map[string]interface{}
+		absent  []string
+	}{
+		{
+			version: "",
+			present: map[string]interface{}{
+				"platform.name":   "run",
+				"run.service":     "hello",
+				"cf.generation":   CF_GEN2,
+				"gce.machineType": machineType,
+			},
+		},
+		{
+			version: PAYLOAD_VERSION,
+			present: map[string]interface{}{
+				"platform.name": "run",
+				"run.service":   "hello",
+			},
+		},
+		{
+			version: "1.2.0",
+			present: map[string]interface{}{
+				"zone":            "us-central1-a",
+				"run.instanceId":  "1234",
+				"cf.name":         "fn",
+				"gce.machineType": machineType,
+			},
+			absent: []string{"platform", "run.service"},
+		},
+		{
+			version: "1.1.0",
+			present: map[string]interface{}{
+				"run.region":    "us-central1",
+				"gce.privateIp": "10.0.0.2",
+			},
+			absent: []string{"platform", "run.service", "cf"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			v, err := ForVersion(p, tt.version)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			body, err := json.Marshal(v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got map[string]interface{}
+			if err := json.Unmarshal(body, &got); err != nil {
+				t.Fatal(err)
+			}
+
+			for path, want := range tt.present {
+				if v, ok := lookup(got, path); !ok || v != want {
+					t.Errorf("%v = %v, want %v", path, v, want)
+				}
+			}
+			for _, path := range tt.absent {
+				if v, ok := lookup(got, path); ok {
+					t.Errorf("%v = %v, want it left out", path, v)
+				}
+			}
+		})
+	}
+}
+
+func TestForVersionUnknown(t *testing.T) {
+	if _, err := ForVersion(Payload{}, "0.9.0"); !errors.Is(err, ErrUnknownVersion) {
+		t.Errorf("ForVersion(0.9.0) error = %v, want ErrUnknownVersion", err)
+	}
+	if _, err := PayloadSchema("0.9.0"); !errors.Is(err, ErrUnknownVersion) {
+		t.Errorf("PayloadSchema(0.9.0) error = %v, want ErrUnknownVersion", err)
+	}
+}
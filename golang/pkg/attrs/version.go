@@ -0,0 +1,116 @@
+package attrs
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The JSON shape of Payload is versioned by PAYLOAD_VERSION. Any change to
+// it needs a version bump, and the previous shape added to payloadVersions
+// with a conversion from the current Payload, so that clients can keep
+// asking for it with ?version=.
+//
+// The schema of every version is checked in under testdata/, and the tests
+// fail when a version's generated schema no longer matches its file, i.e.
+// the shape changed without a bump.
+
+var ErrUnknownVersion = errors.New("unknown payload version")
+
+type payloadVersion struct {
+	// the Go type that marshals to this version's JSON
+	sample interface{}
+
+	// convert turns the current Payload into this version's shape
+	convert func(Payload) interface{}
+}
+
+var payloadVersions = map[string]payloadVersion{
+	PAYLOAD_VERSION: {
+		sample:  Payload{},
+		convert: func(p Payload) interface{} { return p },
+	},
+	"1.2.0": {
+		sample:  payloadV1_2{},
+		convert: toV1_2,
+	},
+	"1.1.0": {
+		sample:  payloadV1_1{},
+		convert: toV1_1,
+	},
+}
+
+// PayloadVersions lists the payload versions that can be asked for
+func PayloadVersions() []string {
+	versions := make([]string, 0, len(payloadVersions))
+	for v := range payloadVersions {
+		versions = append(versions, v)
+	}
+	sortVersions(versions)
+
+	return versions
+}
+
+// sortVersions sorts dotted version numbers, oldest first
+func sortVersions(versions []string) {
+	parse := func(v string) []int {
+		var parts []int
+		for _, p := range strings.Split(v, ".") {
+			n, _ := strconv.Atoi(p)
+			parts = append(parts, n)
+		}
+		return parts
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		a, b := parse(versions[i]), parse(versions[j])
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return len(a) < len(b)
+	})
+}
+
+func lookupVersion(version string) (payloadVersion, error) {
+	if version == "" {
+		version = PAYLOAD_VERSION
+	}
+
+	pv, found := payloadVersions[version]
+	if !found {
+		return pv, fmt.Errorf("%w %q, available: %v", ErrUnknownVersion, version, PayloadVersions())
+	}
+
+	return pv, nil
+}
+
+// ForVersion reshapes the payload into an older version, or returns it as
+// is for the current one (or an empty version)
+func ForVersion(p Payload, version string) (interface{}, error) {
+	pv, err := lookupVersion(version)
+	if err != nil {
+		return nil, err
+	}
+
+	return pv.convert(p), nil
+}
+
+// PayloadSchema returns the JSON Schema for a payload version
+func PayloadSchema(version string) (*Schema, error) {
+	if version == "" {
+		version = PAYLOAD_VERSION
+	}
+
+	pv, err := lookupVersion(version)
+	if err != nil {
+		return nil, err
+	}
+
+	return GenerateSchema(pv.sample,
+		fmt.Sprintf("urn:helloweb:payload:%v", version),
+		fmt.Sprintf("helloweb payload %v", version)), nil
+}
@@ -0,0 +1,270 @@
+package chain
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+
+	attrs "helloworld-http/pkg/attrs"
+	util "helloworld-http/pkg/util"
+)
+
+const (
+	MODE_PARALLEL = "parallel"
+	MODE_SERIAL   = "serial"
+
+	// how deep into a chain a request is, so that a topology with a loop
+	// in it doesn't call itself forever
+	HEADER_CALL_DEPTH = "X-Call-Depth"
+
+	DEFAULT_MAX_DEPTH = 5
+	DEFAULT_TIMEOUT   = 10 * time.Second
+)
+
+// Call is what one instance did with a /call request: its own payload and
+// every call it made downstream, each with the downstream's Call in turn
+type Call struct {
+	Payload         attrs.Payload `json:"payload"`
+	Depth           int           `json:"depth"`
+	Mode            string        `json:"mode,omitempty"`
+	MaxDepthReached bool          `json:"maxDepthReached,omitempty"`
+	Calls           []Hop         `json:"calls,omitempty"`
+}
+
+// Hop is one call to a downstream URL
+type Hop struct {
+	URL        string  `json:"url"`
+	StatusCode int     `json:"statusCode,omitempty"`
+	LatencyMs  float64 `json:"latencyMs"`
+	Error      string  `json:"error,omitempty"`
+	Response   *Call   `json:"response,omitempty"`
+}
+
+// Failed reports whether the hop didn't get a 2xx back
+func (h Hop) Failed() bool {
+	return h.Error != "" || h.StatusCode < 200 || h.StatusCode > 299
+}
+
+// Failed reports whether any of the calls made downstream failed. Failures
+// further down come back as a failed status, so only the direct calls count.
+func (c Call) Failed() bool {
+	for _, hop := range c.Calls {
+		if hop.Failed() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Caller fans a request out to downstream URLs
+type Caller struct {
+	client *util.Client
+
+	// called when a request doesn't name its own URLs
+	Upstreams []string
+
+	// MODE_PARALLEL or MODE_SERIAL, unless the request asks for one
+	Mode string
+
+	// requests this deep into a chain don't call any further
+	MaxDepth int
+
+	// whether requests can name the URLs to call with ?url=, which lets
+	// anyone who can reach the app make it call anywhere
+	AllowURLs bool
+}
+
+// NewCaller returns a Caller with no upstreams. Its client doesn't retry, so
+// that retries are left to whatever is under test.
+func NewCaller() *Caller {
+	return &Caller{
+		client:   util.NewClient(util.WithRetries(0), util.WithTimeout(DEFAULT_TIMEOUT)),
+		Mode:     MODE_PARALLEL,
+		MaxDepth: DEFAULT_MAX_DEPTH,
+	}
+}
+
+// ParseUpstreams splits a comma or whitespace separated list of URLs
+func ParseUpstreams(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n'
+	})
+}
+
+// ParseMode checks a fan out mode, an empty one means the default
+func ParseMode(mode string, def string) (string, error) {
+	switch mode {
+	case "":
+		return def, nil
+	case MODE_PARALLEL, MODE_SERIAL:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown mode %q, use %v or %v", mode, MODE_PARALLEL, MODE_SERIAL)
+	}
+}
+
+// NewCallerFromEnv configures a Caller from CALL_UPSTREAMS, CALL_MODE,
+// CALL_MAX_DEPTH, CALL_TIMEOUT_SECS and CALL_ALLOW_URLS
+func NewCallerFromEnv() (*Caller, error) {
+	c := NewCaller()
+	c.Upstreams = ParseUpstreams(os.Getenv("CALL_UPSTREAMS"))
+
+	var err error
+	if c.Mode, err = ParseMode(os.Getenv("CALL_MODE"), MODE_PARALLEL); err != nil {
+		return nil, fmt.Errorf("invalid value for CALL_MODE: %v", err)
+	}
+
+	if fromEnv := os.Getenv("CALL_MAX_DEPTH"); fromEnv != "" {
+		if c.MaxDepth, err = strconv.Atoi(fromEnv); err != nil || c.MaxDepth < 0 {
+			return nil, fmt.Errorf("invalid value for CALL_MAX_DEPTH: %v", fromEnv)
+		}
+	}
+
+	if fromEnv := os.Getenv("CALL_TIMEOUT_SECS"); fromEnv != "" {
+		secs, err := strconv.Atoi(fromEnv)
+		if err != nil || secs <= 0 {
+			return nil, fmt.Errorf("invalid value for CALL_TIMEOUT_SECS: %v", fromEnv)
+		}
+		c.client = util.NewClient(util.WithRetries(0), util.WithTimeout(time.Duration(secs)*time.Second))
+	}
+
+	if fromEnv := os.Getenv("CALL_ALLOW_URLS"); fromEnv != "" {
+		if c.AllowURLs, err = strconv.ParseBool(fromEnv); err != nil {
+			return nil, fmt.Errorf("invalid value for CALL_ALLOW_URLS: %v", fromEnv)
+		}
+	}
+
+	return c, nil
+}
+
+// DepthFromRequest reads how deep into a chain a request is, 0 if it's the first
+func DepthFromRequest(r *http.Request) int {
+	depth, err := strconv.Atoi(r.Header.Get(HEADER_CALL_DEPTH))
+	if err != nil || depth < 0 {
+		return 0
+	}
+
+	return depth
+}
+
+// decodeResponse reads a downstream's response, which is a Call if it's
+// another /call, or just a payload at depth if it's a leaf serving /
+func decodeResponse(body []byte, depth int) (*Call, error) {
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(body, &probe); err != nil {
+		return nil, err
+	}
+
+	call := &Call{Depth: depth}
+	if _, isCall := probe["payload"]; isCall {
+		if err := json.Unmarshal(body, call); err != nil {
+			return nil, err
+		}
+		return call, nil
+	}
+
+	if err := json.Unmarshal(body, &call.Payload); err != nil {
+		return nil, err
+	}
+	return call, nil
+}
+
+// hop calls one downstream URL, the trace context goes with it through the
+// client's transport
+func (c *Caller) hop(ctx context.Context, url string, depth int) Hop {
+	hop := Hop{URL: url}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		hop.Error = err.Error()
+		return hop
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set(HEADER_CALL_DEPTH, strconv.Itoa(depth+1))
+
+	started := time.Now()
+	resp, err := c.client.Do(req)
+	hop.LatencyMs = float64(time.Since(started).Microseconds()) / 1000
+
+	var statusErr *util.StatusError
+	if err != nil && !errors.As(err, &statusErr) {
+		hop.Error = err.Error()
+		return hop
+	}
+	hop.StatusCode = resp.StatusCode
+
+	// failed downstream calls still send back the tree below them
+	hop.Response, err = decodeResponse(resp.Body, depth+1)
+	if err != nil {
+		zap.S().Debugf("Unexpected response from %v: %v", url, err)
+		hop.Response = nil
+		if statusErr == nil {
+			hop.Error = fmt.Sprintf("unexpected response: %v", err)
+		}
+	}
+	if statusErr != nil {
+		hop.Error = statusErr.Error()
+	}
+
+	return hop
+}
+
+// Call fans out to the URLs, one after another or all at once, and returns
+// the tree of what came back with this instance's payload at the top
+func (c *Caller) Call(ctx context.Context, payload attrs.Payload, urls []string, mode string, depth int) Call {
+	call := Call{
+		Payload: payload,
+		Depth:   depth,
+	}
+
+	if len(urls) == 0 {
+		return call
+	}
+	if depth >= c.MaxDepth {
+		zap.S().Warnf("Not calling %d downstream URL(s), at the maximum depth of %d", len(urls), c.MaxDepth)
+		call.MaxDepthReached = true
+		return call
+	}
+
+	call.Mode = mode
+	call.Calls = make([]Hop, len(urls))
+
+	ctx, span := otel.Tracer("helloworld-http").Start(ctx, "call downstream")
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("call.mode", mode),
+		attribute.Int("call.depth", depth),
+		attribute.StringSlice("call.urls", urls),
+	)
+
+	if mode == MODE_SERIAL {
+		for i, url := range urls {
+			call.Calls[i] = c.hop(ctx, url, depth)
+		}
+		return call
+	}
+
+	var wg sync.WaitGroup
+	for i, url := range urls {
+		wg.Add(1)
+		go func(i int, url string) {
+			defer wg.Done()
+			call.Calls[i] = c.hop(ctx, url, depth)
+		}(i, url)
+	}
+	wg.Wait()
+
+	return call
+}
@@ -0,0 +1,143 @@
+package chain
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	attrs "helloworld-http/pkg/attrs"
+)
+
+func TestDecodeResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		zone    string
+		depth   int
+		calls   int
+		wantErr bool
+	}{
+		{name: "leaf payload", body: `{"zone": "europe-west1-b", "project": "p"}`, zone: "europe-west1-b", depth: 2},
+		{
+			name:  "another call",
+			body:  `{"payload": {"zone": "us-central1-a"}, "depth": 3, "calls": [{"url": "http://c/", "statusCode": 200}]}`,
+			zone:  "us-central1-a",
+			depth: 3,
+			calls: 1,
+		},
+		{name: "not JSON", body: `<html>`, wantErr: true},
+		{name: "not an object", body: `["a"]`, wantErr: true},
+		{name: "bad payload", body: `{"payload": "a"}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		call, err := decodeResponse([]byte(tt.body), 2)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%v: error = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+
+		if call.Payload.Zone != tt.zone || call.Depth != tt.depth || len(call.Calls) != tt.calls {
+			t.Errorf("%v: got zone %v, depth %v and %v calls, want %v, %v and %v",
+				tt.name, call.Payload.Zone, call.Depth, len(call.Calls), tt.zone, tt.depth, tt.calls)
+		}
+	}
+}
+
+// newChainServer is an instance that calls the URLs it's given, like /call
+func newChainServer(t *testing.T, zone string, c *Caller, urls func() []string) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		call := c.Call(r.Context(), attrs.Payload{Zone: zone}, urls(), MODE_SERIAL, DepthFromRequest(r))
+		if call.Failed() {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+		json.NewEncoder(w).Encode(call)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+// a topology with a loop in it stops at the maximum depth
+func TestCallDepthLimit(t *testing.T) {
+	c := NewCaller()
+	c.MaxDepth = 3
+
+	var loop *httptest.Server
+	loop = newChainServer(t, "loop", c, func() []string { return []string{loop.URL} })
+
+	call := c.Call(context.Background(), attrs.Payload{Zone: "top"}, []string{loop.URL}, MODE_SERIAL, 0)
+	if call.Failed() {
+		t.Fatalf("call failed: %+v", call)
+	}
+
+	depth := 0
+	for next := &call; next != nil; depth++ {
+		if next.Depth != depth {
+			t.Fatalf("depth %v: got a call at depth %v", depth, next.Depth)
+		}
+		if depth == c.MaxDepth {
+			if !next.MaxDepthReached || len(next.Calls) != 0 {
+				t.Errorf("at the maximum depth: %+v, want no calls", next)
+			}
+			break
+		}
+		if len(next.Calls) != 1 {
+			t.Fatalf("depth %v: %v calls, want 1", depth, len(next.Calls))
+		}
+		next = next.Calls[0].Response
+	}
+	if depth != c.MaxDepth {
+		t.Errorf("the chain stopped at depth %v, want %v", depth, c.MaxDepth)
+	}
+
+	// a request that says it's already that deep doesn't call anything
+	if call := c.Call(context.Background(), attrs.Payload{}, []string{loop.URL}, MODE_PARALLEL, 3); !call.MaxDepthReached || len(call.Calls) != 0 {
+		t.Errorf("called downstream past the maximum depth: %+v", call)
+	}
+}
+
+func TestCallFailures(t *testing.T) {
+	c := NewCaller()
+
+	leaf := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(HEADER_CALL_DEPTH) != "2" {
+			t.Errorf("%v = %q, want 2", HEADER_CALL_DEPTH, r.Header.Get(HEADER_CALL_DEPTH))
+		}
+		json.NewEncoder(w).Encode(attrs.Payload{Zone: "leaf"})
+	}))
+	defer leaf.Close()
+
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "broken", http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+
+	// the middle fails because one of its calls did, but still sends back the tree
+	middle := newChainServer(t, "middle", c, func() []string { return []string{leaf.URL, broken.URL} })
+
+	call := c.Call(context.Background(), attrs.Payload{Zone: "top"}, []string{middle.URL, "http://127.0.0.1:0/"}, MODE_PARALLEL, 0)
+	if !call.Failed() || call.Mode != MODE_PARALLEL || len(call.Calls) != 2 {
+		t.Fatalf("call = %+v, want 2 failed calls", call)
+	}
+
+	hop := call.Calls[0]
+	if hop.StatusCode != http.StatusBadGateway || hop.Error == "" || hop.Response == nil {
+		t.Fatalf("middle hop = %+v, want a 502 with the tree below it", hop)
+	}
+	below := hop.Response.Calls
+	if len(below) != 2 || below[0].Failed() || below[0].Response.Payload.Zone != "leaf" || below[1].StatusCode != http.StatusInternalServerError {
+		t.Errorf("below the middle: %+v", below)
+	}
+
+	if unreachable := call.Calls[1]; unreachable.StatusCode != 0 || unreachable.Error == "" || unreachable.Response != nil {
+		t.Errorf("unreachable hop = %+v, want an error", unreachable)
+	}
+}
@@ -0,0 +1,96 @@
+package exporter
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// Registry holds the factories of one kind of exporter, e.g. trace or
+// metrics exporters, so they can be picked by name
+type Registry[F any] struct {
+	// what's being exported, for log messages
+	kind string
+
+	mu        sync.RWMutex
+	factories map[string]F
+}
+
+func NewRegistry[F any](kind string, factories map[string]F) *Registry[F] {
+	r := &Registry[F]{
+		kind:      kind,
+		factories: make(map[string]F, len(factories)),
+	}
+	for name, factory := range factories {
+		r.factories[name] = factory
+	}
+
+	return r
+}
+
+// Register adds, or replaces, an exporter that can be picked by name
+func (r *Registry[F]) Register(name string, factory F) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.factories[name] = factory
+}
+
+// Names lists the exporters that can be picked
+func (r *Registry[F]) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.factories))
+	for name := range r.factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Create calls create with the factory of each named exporter in turn. One
+// that is unknown or fails to start is logged and left out rather than
+// stopping the app.
+func (r *Registry[F]) Create(names []string, create func(name string, factory F) error) {
+	for _, name := range names {
+		r.mu.RLock()
+		factory, found := r.factories[name]
+		r.mu.RUnlock()
+
+		if !found {
+			zap.S().Errorf("Unknown %v exporter %q, available: %v", r.kind, name, r.Names())
+			continue
+		}
+
+		if err := create(name, factory); err != nil {
+			zap.S().Errorf("Failed to create %v %v exporter, not exporting to it: %v", name, r.kind, err)
+		}
+	}
+}
+
+// Parse splits a comma separated list of exporter names
+func Parse(s string) []string {
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// NamesFromEnv reads the exporters to use from a comma separated list in the
+// env var, or def if it isn't set. Set but empty means none.
+func NamesFromEnv(env string, def string) []string {
+	if fromEnv, exists := os.LookupEnv(env); exists {
+		return Parse(fromEnv)
+	}
+
+	return Parse(def)
+}
@@ -0,0 +1,67 @@
+package exporter
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{in: "", want: nil},
+		{in: "otlp-grpc", want: []string{"otlp-grpc"}},
+		{in: " cloudtrace, stdout ,", want: []string{"cloudtrace", "stdout"}},
+		{in: ",,", want: nil},
+	}
+
+	for _, tt := range tests {
+		if got := Parse(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Parse(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNamesFromEnv(t *testing.T) {
+	if got := NamesFromEnv("TEST_EXPORTERS_UNSET", "a,b"); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("unset: got %q, want the default", got)
+	}
+
+	t.Setenv("TEST_EXPORTERS", "")
+	if got := NamesFromEnv("TEST_EXPORTERS", "a,b"); got != nil {
+		t.Errorf("set but empty: got %q, want none", got)
+	}
+
+	t.Setenv("TEST_EXPORTERS", "c")
+	if got := NamesFromEnv("TEST_EXPORTERS", "a,b"); !reflect.DeepEqual(got, []string{"c"}) {
+		t.Errorf("set: got %q", got)
+	}
+}
+
+func TestRegistryCreate(t *testing.T) {
+	r := NewRegistry("test", map[string]func() error{
+		"ok":     func() error { return nil },
+		"broken": func() error { return errors.New("can't connect") },
+	})
+	r.Register("late", func() error { return nil })
+
+	if got, want := r.Names(), []string{"broken", "late", "ok"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Names() = %q, want %q", got, want)
+	}
+
+	// unknown and failing exporters are left out, the rest still created
+	var created []string
+	r.Create([]string{"ok", "unknown", "broken", "late"}, func(name string, factory func() error) error {
+		if err := factory(); err != nil {
+			return err
+		}
+		created = append(created, name)
+		return nil
+	})
+
+	if want := []string{"ok", "late"}; !reflect.DeepEqual(created, want) {
+		t.Errorf("created %q, want %q", created, want)
+	}
+}
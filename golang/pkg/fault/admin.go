@@ -0,0 +1,90 @@
+package fault
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	chi "github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+// AdminRouter serves the fault injection control plane:
+//
+//	GET    /       list the rules in effect
+//	POST   /       add a rule, e.g. {"type":"latency","path":"/slow/*","percent":50,"latency":"200ms"}
+//	DELETE /       remove every rule
+//	GET    /{id}   show a rule
+//	DELETE /{id}   remove a rule
+func AdminRouter() http.Handler {
+	r := chi.NewRouter()
+
+	r.Get("/", listRules)
+	r.Post("/", addRule)
+	r.Delete("/", clearRules)
+	r.Get("/{id}", getRule)
+	r.Delete("/{id}", deleteRule)
+
+	return r
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func listRules(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, DefaultStore.List())
+}
+
+func addRule(w http.ResponseWriter, r *http.Request) {
+	var rule Rule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rule, err := DefaultStore.Add(rule)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	zap.L().Info("Added fault rule",
+		zap.Any("rule", rule))
+
+	w.Header().Set("Location", fmt.Sprintf("%v/%v", strings.TrimSuffix(r.URL.Path, "/"), rule.ID))
+	writeJSON(w, http.StatusCreated, rule)
+}
+
+func clearRules(w http.ResponseWriter, r *http.Request) {
+	DefaultStore.Clear()
+	zap.L().Info("Cleared fault rules")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func getRule(w http.ResponseWriter, r *http.Request) {
+	rule, found := DefaultStore.Get(chi.URLParam(r, "id"))
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, rule)
+}
+
+func deleteRule(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if !DefaultStore.Delete(id) {
+		http.NotFound(w, r)
+		return
+	}
+
+	zap.L().Info("Deleted fault rule",
+		zap.String("id", id))
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,250 @@
+package fault
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Fault types that can be injected into a response
+const (
+	TYPE_LATENCY  = "latency"
+	TYPE_ERROR    = "error"
+	TYPE_RESET    = "reset"
+	TYPE_TRUNCATE = "truncate"
+	TYPE_DRIP     = "drip"
+)
+
+// Duration is a time.Duration that reads and writes JSON as "250ms"
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(parsed)
+	return nil
+}
+
+// Rule injects one type of fault into a percentage of the requests whose
+// path matches Path (path.Match syntax, e.g. "/slow/*"; empty matches all)
+type Rule struct {
+	ID      string  `json:"id"`
+	Type    string  `json:"type"`
+	Path    string  `json:"path,omitempty"`
+	Percent float64 `json:"percent"`
+
+	// latency: sleep for Latency, or a random time between Latency and LatencyMax
+	Latency    Duration `json:"latency,omitempty"`
+	LatencyMax Duration `json:"latencyMax,omitempty"`
+
+	// error: respond with one of StatusCodes instead of calling the handler
+	StatusCodes []int `json:"statusCodes,omitempty"`
+
+	// truncate: send only the first Bytes of the body and cut the connection
+	// drip: send the body Bytes at a time, every Interval
+	Bytes    int      `json:"bytes,omitempty"`
+	Interval Duration `json:"interval,omitempty"`
+}
+
+// Validate fills in defaults and rejects rules that can't be applied
+func (r *Rule) Validate() error {
+	// a rule posted without a percent would never fire
+	if r.Percent <= 0 || r.Percent > 100 {
+		return fmt.Errorf("percent must be above 0 and at most 100, got %v", r.Percent)
+	}
+
+	if r.Path != "" {
+		if _, err := path.Match(r.Path, "/"); err != nil {
+			return fmt.Errorf("invalid path pattern %q: %v", r.Path, err)
+		}
+	}
+
+	switch r.Type {
+	case TYPE_LATENCY:
+		if r.Latency <= 0 {
+			return fmt.Errorf("latency fault needs a latency")
+		}
+		if r.LatencyMax != 0 && r.LatencyMax < r.Latency {
+			return fmt.Errorf("latencyMax must not be less than latency")
+		}
+	case TYPE_ERROR:
+		if len(r.StatusCodes) == 0 {
+			r.StatusCodes = []int{500}
+		}
+		for _, code := range r.StatusCodes {
+			if code < 100 || code > 599 {
+				return fmt.Errorf("invalid status code %v", code)
+			}
+		}
+	case TYPE_RESET:
+	case TYPE_TRUNCATE:
+		if r.Bytes < 0 {
+			return fmt.Errorf("bytes must not be negative")
+		}
+	case TYPE_DRIP:
+		if r.Bytes <= 0 {
+			r.Bytes = 16
+		}
+		if r.Interval <= 0 {
+			r.Interval = Duration(100 * time.Millisecond)
+		}
+	default:
+		return fmt.Errorf("unknown fault type %q", r.Type)
+	}
+
+	return nil
+}
+
+func (r *Rule) matches(reqPath string) bool {
+	if r.Path == "" {
+		return true
+	}
+
+	matched, _ := path.Match(r.Path, reqPath)
+	return matched
+}
+
+var (
+	randMu sync.Mutex
+	rnd    = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+func randFloat() float64 {
+	randMu.Lock()
+	defer randMu.Unlock()
+
+	return rnd.Float64()
+}
+
+func randInt63n(n int64) int64 {
+	randMu.Lock()
+	defer randMu.Unlock()
+
+	return rnd.Int63n(n)
+}
+
+// roll decides whether the rule fires for this request
+func (r *Rule) roll() bool {
+	return r.Percent >= 100 || randFloat()*100 < r.Percent
+}
+
+func (r *Rule) latency() time.Duration {
+	if r.LatencyMax <= r.Latency {
+		return time.Duration(r.Latency)
+	}
+
+	spread := int64(r.LatencyMax - r.Latency)
+	return time.Duration(r.Latency) + time.Duration(randInt63n(spread+1))
+}
+
+func (r *Rule) statusCode() int {
+	return r.StatusCodes[randInt63n(int64(len(r.StatusCodes)))]
+}
+
+// Store holds the rules currently in effect
+type Store struct {
+	mu     sync.RWMutex
+	rules  []Rule
+	nextID int
+}
+
+func NewStore() *Store {
+	return &Store{}
+}
+
+// Add validates the rule, assigns it an ID and puts it into effect
+func (s *Store) Add(rule Rule) (Rule, error) {
+	if err := rule.Validate(); err != nil {
+		return rule, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	rule.ID = strconv.Itoa(s.nextID)
+	s.rules = append(s.rules, rule)
+
+	return rule, nil
+}
+
+// Delete removes a rule, returning false if there was no such rule
+func (s *Store) Delete(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, r := range s.rules {
+		if r.ID == id {
+			s.rules = append(s.rules[:i], s.rules[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *Store) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rules = nil
+}
+
+func (s *Store) List() []Rule {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rules := make([]Rule, len(s.rules))
+	copy(rules, s.rules)
+
+	return rules
+}
+
+// Get returns a single rule by ID
+func (s *Store) Get(id string) (Rule, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, r := range s.rules {
+		if r.ID == id {
+			return r, true
+		}
+	}
+
+	return Rule{}, false
+}
+
+// LoadJSON adds every rule in a JSON array, e.g. from the FAULT_RULES env var
+func (s *Store) LoadJSON(data []byte) error {
+	var rules []Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return err
+	}
+
+	for _, r := range rules {
+		if _, err := s.Add(r); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DefaultStore holds the rules the middleware and admin API use
+var DefaultStore = NewStore()
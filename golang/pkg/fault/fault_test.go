@@ -0,0 +1,191 @@
+package fault
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRuleMatches(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{pattern: "", path: "/", want: true},
+		{pattern: "", path: "/slow/a", want: true},
+		{pattern: "/", path: "/", want: true},
+		{pattern: "/", path: "/healthz", want: false},
+		{pattern: "/healthz", path: "/healthz", want: true},
+		{pattern: "/healthz", path: "/healthz/", want: false},
+		{pattern: "/slow/*", path: "/slow/a", want: true},
+		{pattern: "/slow/*", path: "/slow/", want: true},
+		{pattern: "/slow/*", path: "/slow", want: false},
+		{pattern: "/slow/*", path: "/slow/a/b", want: false},
+		{pattern: "/*/b", path: "/a/b", want: true},
+		{pattern: "/item-?", path: "/item-1", want: true},
+		{pattern: "/item-[0-4]", path: "/item-7", want: false},
+	}
+
+	for _, tt := range tests {
+		r := Rule{Path: tt.pattern}
+		if got := r.matches(tt.path); got != tt.want {
+			t.Errorf("Rule{Path: %q}.matches(%q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestRuleValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    Rule
+		wantErr bool
+		// the rule after defaults are filled in
+		want *Rule
+	}{
+		{
+			name:    "no percent",
+			rule:    Rule{Type: TYPE_RESET},
+			wantErr: true,
+		},
+		{
+			name:    "percent over 100",
+			rule:    Rule{Type: TYPE_RESET, Percent: 101},
+			wantErr: true,
+		},
+		{
+			name:    "negative percent",
+			rule:    Rule{Type: TYPE_RESET, Percent: -5},
+			wantErr: true,
+		},
+		{
+			name: "fraction of a percent",
+			rule: Rule{Type: TYPE_RESET, Percent: 0.5},
+		},
+		{
+			name:    "bad path pattern",
+			rule:    Rule{Type: TYPE_RESET, Percent: 100, Path: "/[a"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown type",
+			rule:    Rule{Type: "explode", Percent: 100},
+			wantErr: true,
+		},
+		{
+			name:    "latency without a latency",
+			rule:    Rule{Type: TYPE_LATENCY, Percent: 100},
+			wantErr: true,
+		},
+		{
+			name:    "latency range backwards",
+			rule:    Rule{Type: TYPE_LATENCY, Percent: 100, Latency: Duration(time.Second), LatencyMax: Duration(time.Millisecond)},
+			wantErr: true,
+		},
+		{
+			name: "latency range",
+			rule: Rule{Type: TYPE_LATENCY, Percent: 100, Latency: Duration(time.Millisecond), LatencyMax: Duration(time.Second)},
+		},
+		{
+			name: "error defaults to 500",
+			rule: Rule{Type: TYPE_ERROR, Percent: 100},
+			want: &Rule{Type: TYPE_ERROR, Percent: 100, StatusCodes: []int{500}},
+		},
+		{
+			name:    "error with an invalid code",
+			rule:    Rule{Type: TYPE_ERROR, Percent: 100, StatusCodes: []int{503, 600}},
+			wantErr: true,
+		},
+		{
+			name:    "truncate negative bytes",
+			rule:    Rule{Type: TYPE_TRUNCATE, Percent: 100, Bytes: -1},
+			wantErr: true,
+		},
+		{
+			name: "truncate everything",
+			rule: Rule{Type: TYPE_TRUNCATE, Percent: 100},
+		},
+		{
+			name: "drip defaults",
+			rule: Rule{Type: TYPE_DRIP, Percent: 100},
+			want: &Rule{Type: TYPE_DRIP, Percent: 100, Bytes: 16, Interval: Duration(100 * time.Millisecond)},
+		},
+	}
+
+	for _, tt := range tests {
+		rule := tt.rule
+		err := rule.Validate()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%v: Validate() error = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+
+		if tt.want != nil {
+			if rule.Bytes != tt.want.Bytes || rule.Interval != tt.want.Interval || len(rule.StatusCodes) != len(tt.want.StatusCodes) {
+				t.Errorf("%v: Validate() left %+v, want %+v", tt.name, rule, *tt.want)
+			}
+			for i := range tt.want.StatusCodes {
+				if rule.StatusCodes[i] != tt.want.StatusCodes[i] {
+					t.Errorf("%v: StatusCodes = %v, want %v", tt.name, rule.StatusCodes, tt.want.StatusCodes)
+				}
+			}
+		}
+	}
+}
+
+func TestRuleLatency(t *testing.T) {
+	fixed := Rule{Latency: Duration(50 * time.Millisecond)}
+	if got := fixed.latency(); got != 50*time.Millisecond {
+		t.Errorf("latency() = %v, want 50ms", got)
+	}
+
+	ranged := Rule{Latency: Duration(50 * time.Millisecond), LatencyMax: Duration(60 * time.Millisecond)}
+	for i := 0; i < 100; i++ {
+		if got := ranged.latency(); got < 50*time.Millisecond || got > 60*time.Millisecond {
+			t.Fatalf("latency() = %v, want between 50ms and 60ms", got)
+		}
+	}
+}
+
+func TestStore(t *testing.T) {
+	s := NewStore()
+
+	if err := s.LoadJSON([]byte(`[
+		{"type": "latency", "percent": 100, "path": "/slow/*", "latency": "250ms"},
+		{"type": "error", "percent": 10}
+	]`)); err != nil {
+		t.Fatal(err)
+	}
+
+	rules := s.List()
+	if len(rules) != 2 || rules[0].ID != "1" || rules[1].ID != "2" {
+		t.Fatalf("List() = %+v, want rules 1 and 2", rules)
+	}
+	if rules[0].Latency != Duration(250*time.Millisecond) {
+		t.Errorf("latency = %v, want 250ms", time.Duration(rules[0].Latency))
+	}
+
+	if _, err := s.Add(Rule{Type: TYPE_RESET}); err == nil {
+		t.Error("Add() took a rule with no percent")
+	}
+
+	if !s.Delete("1") || s.Delete("1") {
+		t.Error("Delete(1) should succeed once")
+	}
+	if _, found := s.Get("2"); !found {
+		t.Error("Get(2) didn't find the remaining rule")
+	}
+
+	// IDs aren't reused
+	added, err := s.Add(Rule{Type: TYPE_RESET, Percent: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added.ID != "3" {
+		t.Errorf("ID = %v, want 3", added.ID)
+	}
+
+	s.Clear()
+	if rules := s.List(); len(rules) != 0 {
+		t.Errorf("List() after Clear() = %+v", rules)
+	}
+}
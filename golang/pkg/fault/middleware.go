@@ -0,0 +1,326 @@
+package fault
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+
+	metrics "helloworld-http/pkg/metrics"
+)
+
+// Request headers that inject a fault into just that request:
+//
+//	X-Fault-Delay: 250ms          or a random delay in a range, 100ms-1s
+//	X-Fault-Abort: 503            or a random choice of codes, 500,503
+//	X-Fault-Reset: true
+//	X-Fault-Truncate: 64          bytes of body to send before cutting the connection
+//	X-Fault-Drip: 16/100ms        bytes to send per interval
+const (
+	HEADER_DELAY    = "X-Fault-Delay"
+	HEADER_ABORT    = "X-Fault-Abort"
+	HEADER_RESET    = "X-Fault-Reset"
+	HEADER_TRUNCATE = "X-Fault-Truncate"
+	HEADER_DRIP     = "X-Fault-Drip"
+
+	SOURCE_HEADER = "header"
+)
+
+// HeaderOverrides controls whether the X-Fault-* request headers are honoured
+var HeaderOverrides = false
+
+// injection is a fault that's going to be applied to this request, and
+// where it came from (a rule ID or "header")
+type injection struct {
+	rule   Rule
+	source string
+}
+
+func headerFaults(r *http.Request) ([]Rule, error) {
+	var rules []Rule
+
+	if v := r.Header.Get(HEADER_DELAY); v != "" {
+		rule := Rule{Type: TYPE_LATENCY, Percent: 100}
+		min, max := v, ""
+		if i := strings.Index(v, "-"); i > 0 {
+			min, max = v[:i], v[i+1:]
+		}
+
+		d, err := time.ParseDuration(min)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", HEADER_DELAY, err)
+		}
+		rule.Latency = Duration(d)
+
+		if max != "" {
+			d, err := time.ParseDuration(max)
+			if err != nil {
+				return nil, fmt.Errorf("%v: %v", HEADER_DELAY, err)
+			}
+			rule.LatencyMax = Duration(d)
+		}
+		rules = append(rules, rule)
+	}
+
+	if v := r.Header.Get(HEADER_ABORT); v != "" {
+		rule := Rule{Type: TYPE_ERROR, Percent: 100}
+		for _, codeStr := range strings.Split(v, ",") {
+			code, err := strconv.Atoi(strings.TrimSpace(codeStr))
+			if err != nil {
+				return nil, fmt.Errorf("%v: %v", HEADER_ABORT, err)
+			}
+			rule.StatusCodes = append(rule.StatusCodes, code)
+		}
+		rules = append(rules, rule)
+	}
+
+	if v := r.Header.Get(HEADER_RESET); v != "" {
+		reset, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", HEADER_RESET, err)
+		}
+		if reset {
+			rules = append(rules, Rule{Type: TYPE_RESET, Percent: 100})
+		}
+	}
+
+	if v := r.Header.Get(HEADER_TRUNCATE); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", HEADER_TRUNCATE, err)
+		}
+		rules = append(rules, Rule{Type: TYPE_TRUNCATE, Percent: 100, Bytes: n})
+	}
+
+	if v := r.Header.Get(HEADER_DRIP); v != "" {
+		rule := Rule{Type: TYPE_DRIP, Percent: 100}
+		interval := v
+		if i := strings.Index(v, "/"); i >= 0 {
+			n, err := strconv.Atoi(v[:i])
+			if err != nil {
+				return nil, fmt.Errorf("%v: %v", HEADER_DRIP, err)
+			}
+			rule.Bytes = n
+			interval = v[i+1:]
+		}
+
+		d, err := time.ParseDuration(interval)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", HEADER_DRIP, err)
+		}
+		rule.Interval = Duration(d)
+		rules = append(rules, rule)
+	}
+
+	for i := range rules {
+		if err := rules[i].Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return rules, nil
+}
+
+// selectFaults rolls the dice on every matching rule, header faults always apply
+func selectFaults(r *http.Request, store *Store) ([]injection, error) {
+	var selected []injection
+
+	for _, rule := range store.List() {
+		if rule.matches(r.URL.Path) && rule.roll() {
+			selected = append(selected, injection{rule: rule, source: rule.ID})
+		}
+	}
+
+	if HeaderOverrides {
+		rules, err := headerFaults(r)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, rule := range rules {
+			selected = append(selected, injection{rule: rule, source: SOURCE_HEADER})
+		}
+	}
+
+	return selected, nil
+}
+
+// record adds a span event and bumps the counter for an injected fault
+func record(ctx context.Context, inj injection, detail string) {
+	zap.L().Debug("Injecting fault",
+		zap.String("type", inj.rule.Type),
+		zap.String("source", inj.source),
+		zap.String("detail", detail))
+
+	trace.SpanFromContext(ctx).AddEvent("fault.injected", trace.WithAttributes(
+		attribute.String("fault.type", inj.rule.Type),
+		attribute.String("fault.source", inj.source),
+		attribute.String("fault.detail", detail),
+	))
+
+	metrics.FaultInjected(inj.rule.Type, inj.source)
+}
+
+// resetConnection drops the connection without a response, with an RST
+// where we can get at the TCP connection
+func resetConnection(w http.ResponseWriter) {
+	if hj, ok := w.(http.Hijacker); ok {
+		conn, _, err := hj.Hijack()
+		if err == nil {
+			if tcp, ok := conn.(*net.TCPConn); ok {
+				tcp.SetLinger(0)
+			}
+			conn.Close()
+			return
+		}
+	}
+
+	// HTTP/2 can't be hijacked, abort the stream instead
+	panic(http.ErrAbortHandler)
+}
+
+// bufferingWriter holds on to the response body so it can be sent back
+// truncated or slowly
+type bufferingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferingWriter) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferingWriter) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+func flush(w http.ResponseWriter) {
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Middleware injects faults from DefaultStore, and from request headers if
+// HeaderOverrides is set. Latency is applied first, then an error or reset
+// replaces the response, otherwise truncate or drip mangle the body.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		faults, err := selectFaults(r, DefaultStore)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid fault header: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		if len(faults) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var bodyFault *injection
+		for i, inj := range faults {
+			switch inj.rule.Type {
+			case TYPE_LATENCY:
+				d := inj.rule.latency()
+				record(ctx, inj, d.String())
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(d):
+				}
+
+			case TYPE_ERROR:
+				code := inj.rule.statusCode()
+				record(ctx, inj, strconv.Itoa(code))
+
+				http.Error(w, fmt.Sprintf("fault injected: %d %v", code, http.StatusText(code)), code)
+				return
+
+			case TYPE_RESET:
+				record(ctx, inj, "")
+				resetConnection(w)
+				return
+
+			case TYPE_TRUNCATE, TYPE_DRIP:
+				if bodyFault == nil {
+					bodyFault = &faults[i]
+				}
+			}
+		}
+
+		if bodyFault == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		bw := &bufferingWriter{ResponseWriter: w}
+		next.ServeHTTP(bw, r)
+		if bw.status == 0 {
+			bw.status = http.StatusOK
+		}
+
+		body := bw.body.Bytes()
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+
+		switch bodyFault.rule.Type {
+		case TYPE_TRUNCATE:
+			n := bodyFault.rule.Bytes
+			if n > len(body) {
+				n = len(body)
+			}
+			record(ctx, *bodyFault, fmt.Sprintf("%d of %d bytes", n, len(body)))
+
+			w.WriteHeader(bw.status)
+			w.Write(body[:n])
+			flush(w)
+
+			// cut the connection short of the Content-Length we promised
+			panic(http.ErrAbortHandler)
+
+		case TYPE_DRIP:
+			chunk := bodyFault.rule.Bytes
+			interval := time.Duration(bodyFault.rule.Interval)
+			record(ctx, *bodyFault, fmt.Sprintf("%d bytes every %v", chunk, interval))
+
+			w.WriteHeader(bw.status)
+			for len(body) > 0 {
+				n := chunk
+				if n > len(body) {
+					n = len(body)
+				}
+
+				if _, err := w.Write(body[:n]); err != nil {
+					return
+				}
+				flush(w)
+				body = body[n:]
+
+				if len(body) == 0 {
+					break
+				}
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(interval):
+				}
+			}
+		}
+	})
+}
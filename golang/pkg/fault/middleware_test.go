@@ -0,0 +1,173 @@
+package fault
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHeaderFaults(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		value   string
+		want    Rule
+		wantErr bool
+	}{
+		{
+			name:   "delay",
+			header: HEADER_DELAY,
+			value:  "250ms",
+			want:   Rule{Type: TYPE_LATENCY, Latency: Duration(250 * time.Millisecond)},
+		},
+		{
+			name:   "delay range",
+			header: HEADER_DELAY,
+			value:  "100ms-1s",
+			want:   Rule{Type: TYPE_LATENCY, Latency: Duration(100 * time.Millisecond), LatencyMax: Duration(time.Second)},
+		},
+		{
+			name:    "delay range backwards",
+			header:  HEADER_DELAY,
+			value:   "1s-100ms",
+			wantErr: true,
+		},
+		{
+			name:    "delay unparseable",
+			header:  HEADER_DELAY,
+			value:   "soon",
+			wantErr: true,
+		},
+		{
+			name:   "abort",
+			header: HEADER_ABORT,
+			value:  "500, 503",
+			want:   Rule{Type: TYPE_ERROR, StatusCodes: []int{500, 503}},
+		},
+		{
+			name:    "abort with an invalid code",
+			header:  HEADER_ABORT,
+			value:   "99",
+			wantErr: true,
+		},
+		{
+			name:   "reset",
+			header: HEADER_RESET,
+			value:  "true",
+			want:   Rule{Type: TYPE_RESET},
+		},
+		{
+			name:   "truncate",
+			header: HEADER_TRUNCATE,
+			value:  "64",
+			want:   Rule{Type: TYPE_TRUNCATE, Bytes: 64},
+		},
+		{
+			name:   "drip",
+			header: HEADER_DRIP,
+			value:  "8/50ms",
+			want:   Rule{Type: TYPE_DRIP, Bytes: 8, Interval: Duration(50 * time.Millisecond)},
+		},
+		{
+			name:   "drip default bytes",
+			header: HEADER_DRIP,
+			value:  "50ms",
+			want:   Rule{Type: TYPE_DRIP, Bytes: 16, Interval: Duration(50 * time.Millisecond)},
+		},
+		{
+			name:    "drip unparseable",
+			header:  HEADER_DRIP,
+			value:   "x/50ms",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set(tt.header, tt.value)
+
+		rules, err := headerFaults(r)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%v: expected an error, got %+v", tt.name, rules)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: unexpected error %v", tt.name, err)
+			continue
+		}
+
+		if len(rules) != 1 {
+			t.Errorf("%v: got %+v, want one rule", tt.name, rules)
+			continue
+		}
+		got := rules[0]
+		if got.Type != tt.want.Type || got.Percent != 100 || got.Latency != tt.want.Latency || got.LatencyMax != tt.want.LatencyMax ||
+			got.Bytes != tt.want.Bytes || got.Interval != tt.want.Interval || len(got.StatusCodes) != len(tt.want.StatusCodes) {
+			t.Errorf("%v: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSelectFaults(t *testing.T) {
+	defer func(v bool) { HeaderOverrides = v }(HeaderOverrides)
+
+	store := NewStore()
+	for _, rule := range []Rule{
+		{Type: TYPE_LATENCY, Percent: 100, Path: "/slow/*", Latency: Duration(time.Millisecond)},
+		{Type: TYPE_ERROR, Percent: 100, Path: "/fail"},
+		// small enough that it never fires in practice
+		{Type: TYPE_RESET, Percent: 1e-9},
+	} {
+		if _, err := store.Add(rule); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		headers bool
+		abort   string
+		want    []string
+		wantErr bool
+	}{
+		{name: "no match", path: "/", want: nil},
+		{name: "path match", path: "/slow/a", want: []string{"1"}},
+		{name: "other rule", path: "/fail", want: []string{"2"}},
+		{name: "headers ignored", path: "/", abort: "503", want: nil},
+		{name: "headers honoured", path: "/slow/a", headers: true, abort: "503", want: []string{"1", SOURCE_HEADER}},
+		{name: "bad header", path: "/", headers: true, abort: "nope", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		HeaderOverrides = tt.headers
+
+		r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.abort != "" {
+			r.Header.Set(HEADER_ABORT, tt.abort)
+		}
+
+		selected, err := selectFaults(r, store)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%v: error = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+
+		var got []string
+		for _, inj := range selected {
+			got = append(got, inj.source)
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%v: sources = %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for i := range tt.want {
+			if got[i] != tt.want[i] {
+				t.Errorf("%v: sources = %v, want %v", tt.name, got, tt.want)
+			}
+		}
+	}
+}
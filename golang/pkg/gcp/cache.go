@@ -0,0 +1,333 @@
+package gcp
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// WatchableSource is a MetadataSource that can block until the metadata
+// changes, i.e. the metadata server's wait_for_change
+type WatchableSource interface {
+	MetadataSource
+
+	WatchMetaData(ctx context.Context, lastETag string, timeout time.Duration) (*string, string, error)
+}
+
+// Snapshot is a parsed copy of the metadata tree. Generation goes up every
+// time the tree changes so callers can tell whether anything they derived
+// from it is stale. A Generation of 0 means the tree wasn't cached and
+// should not be reused.
+type Snapshot struct {
+	Tree       map[string]interface{}
+	Raw        string
+	ETag       string
+	Generation uint64
+	Fetched    time.Time
+}
+
+// MetadataCache keeps the recursive metadata tree in memory instead of
+// fetching it from the source on every request. It is refreshed in the
+// background, either by long-polling a WatchableSource or by re-reading the
+// source every TTL.
+type MetadataCache struct {
+	src   MetadataSource
+	ttl   time.Duration
+	watch bool
+
+	mu       sync.RWMutex
+	snapshot *Snapshot
+
+	fetchMu sync.Mutex
+}
+
+const (
+	DEFAULT_CACHE_TTL = 60 * time.Second
+	maxWatchTimeout   = 60 * time.Second
+	watchRetryMin     = 1 * time.Second
+	watchRetryMax     = 30 * time.Second
+)
+
+func NewMetadataCache(src MetadataSource, ttl time.Duration, watch bool) *MetadataCache {
+	if ttl <= 0 {
+		ttl = DEFAULT_CACHE_TTL
+	}
+
+	return &MetadataCache{
+		src:   src,
+		ttl:   ttl,
+		watch: watch,
+	}
+}
+
+func (c *MetadataCache) current() *Snapshot {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.snapshot
+}
+
+// update swaps in a new tree, bumping the generation only if it changed
+func (c *MetadataCache) update(raw string, etag string) (*Snapshot, error) {
+	tree, err := decodeTree([]byte(raw))
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse metadata: %v", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var generation uint64 = 1
+	if c.snapshot != nil {
+		generation = c.snapshot.Generation
+		if c.snapshot.Raw != raw {
+			generation++
+		}
+	}
+
+	c.snapshot = &Snapshot{
+		Tree:       tree,
+		Raw:        raw,
+		ETag:       etag,
+		Generation: generation,
+		Fetched:    time.Now(),
+	}
+
+	return c.snapshot, nil
+}
+
+// refresh re-reads the source, at most one caller at a time
+func (c *MetadataCache) refresh(ctx context.Context) (*Snapshot, error) {
+	c.fetchMu.Lock()
+	defer c.fetchMu.Unlock()
+
+	// someone else may have refreshed while we were waiting
+	if s := c.current(); s != nil && time.Since(s.Fetched) < c.ttl {
+		return s, nil
+	}
+
+	var raw *string
+	var etag string
+	var err error
+	if ws, ok := c.src.(WatchableSource); ok {
+		raw, etag, err = ws.WatchMetaData(ctx, "", 0)
+	} else {
+		raw, err = c.src.GetMetaData(ctx)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return c.update(*raw, etag)
+}
+
+// Snapshot returns the cached tree, going to the source only if the cache is
+// empty or older than the TTL. If the source fails, the stale tree is
+// returned instead.
+func (c *MetadataCache) Snapshot(ctx context.Context) (*Snapshot, error) {
+	s := c.current()
+	if s != nil && time.Since(s.Fetched) < c.ttl {
+		return s, nil
+	}
+
+	fresh, err := c.refresh(ctx)
+	if err != nil {
+		if s != nil {
+			zap.S().Warnf("Unable to refresh metadata, serving cached copy: %v", err)
+			return s, nil
+		}
+
+		return nil, err
+	}
+
+	return fresh, nil
+}
+
+func (c *MetadataCache) GetMetaData(ctx context.Context) (*string, error) {
+	s, err := c.Snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &s.Raw, nil
+}
+
+func (c *MetadataCache) GetProjectID(ctx context.Context) (*string, error) {
+	s, err := c.Snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	project, err := GetMetaDataStr("project/projectId", s.Tree)
+	if err != nil {
+		return c.src.GetProjectID(ctx)
+	}
+
+	return &project, nil
+}
+
+// Start refreshes the cache in the background until ctx is cancelled
+func (c *MetadataCache) Start(ctx context.Context) {
+	if ws, ok := c.src.(WatchableSource); ok && c.watch {
+		go c.watchLoop(ctx, ws)
+		return
+	}
+
+	go c.pollLoop(ctx)
+}
+
+func (c *MetadataCache) pollLoop(ctx context.Context) {
+	ticker := time.NewTicker(c.ttl)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		raw, err := c.src.GetMetaData(ctx)
+		if err != nil {
+			zap.S().Warnf("Unable to refresh metadata: %v", err)
+			continue
+		}
+
+		if _, err := c.update(*raw, ""); err != nil {
+			zap.S().Warnf("Unable to refresh metadata: %v", err)
+		}
+	}
+}
+
+func (c *MetadataCache) watchLoop(ctx context.Context, ws WatchableSource) {
+	retry := watchRetryMin
+
+	// come back before the TTL runs out even if nothing changed, so requests
+	// never see the cache as stale while the watcher is healthy
+	timeout := c.ttl / 2
+	if timeout > maxWatchTimeout {
+		timeout = maxWatchTimeout
+	}
+	if timeout < time.Second {
+		timeout = time.Second
+	}
+
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		etag := ""
+		if s := c.current(); s != nil {
+			etag = s.ETag
+		}
+
+		raw, newETag, err := ws.WatchMetaData(ctx, etag, timeout)
+		if err == nil {
+			_, err = c.update(*raw, newETag)
+		}
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			zap.S().Warnf("Unable to watch metadata, retrying in %v: %v", retry, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(retry):
+			}
+
+			retry = retry * 2
+			if retry > watchRetryMax {
+				retry = watchRetryMax
+			}
+			continue
+		}
+
+		retry = watchRetryMin
+		zap.L().Debug("Metadata refreshed",
+			zap.String("etag", newETag))
+
+		// without an ETag there's nothing to wait on, fall back to polling
+		if newETag == "" {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(timeout):
+			}
+		}
+	}
+}
+
+// GetMetaDataSnapshot returns a parsed copy of the metadata tree. If the
+// configured source is a MetadataCache the shared cached copy is returned,
+// otherwise the tree is fetched and parsed on every call.
+func GetMetaDataSnapshot(ctx context.Context) (*Snapshot, error) {
+	src := getMetadataSource()
+	if c, ok := src.(*MetadataCache); ok {
+		return c.Snapshot(ctx)
+	}
+
+	raw, err := src.GetMetaData(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tree, err := decodeTree([]byte(*raw))
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse metadata: %v", err)
+	}
+
+	return &Snapshot{
+		Tree:    tree,
+		Raw:     *raw,
+		Fetched: time.Now(),
+	}, nil
+}
+
+// NewMetadataCacheFromEnv wraps src in a MetadataCache configured from
+// METADATA_CACHE_TTL (a duration, default 60s) and METADATA_WAIT_FOR_CHANGE
+// (default true). Setting METADATA_CACHE=false turns caching off and hands
+// back src as-is.
+func NewMetadataCacheFromEnv(src MetadataSource) (MetadataSource, error) {
+	if enabled := os.Getenv("METADATA_CACHE"); enabled != "" {
+		b, err := strconv.ParseBool(enabled)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for METADATA_CACHE: %v", enabled)
+		}
+
+		if !b {
+			return src, nil
+		}
+	}
+
+	ttl := DEFAULT_CACHE_TTL
+	if ttlStr := os.Getenv("METADATA_CACHE_TTL"); ttlStr != "" {
+		d, err := time.ParseDuration(ttlStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for METADATA_CACHE_TTL: %v", ttlStr)
+		}
+
+		ttl = d
+	}
+
+	watch := true
+	if watchStr := os.Getenv("METADATA_WAIT_FOR_CHANGE"); watchStr != "" {
+		b, err := strconv.ParseBool(watchStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for METADATA_WAIT_FOR_CHANGE: %v", watchStr)
+		}
+
+		watch = b
+	}
+
+	return NewMetadataCache(src, ttl, watch), nil
+}
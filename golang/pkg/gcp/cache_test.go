@@ -0,0 +1,53 @@
+package gcp
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMetadataCacheSnapshot(t *testing.T) {
+	src := NewFakeSource(testMetadata(t))
+	// expired by the next call, so every Snapshot goes to the source
+	cache := NewMetadataCache(src, time.Nanosecond, false)
+
+	first, err := cache.Snapshot(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// past 2^53, so only exact if the cached tree kept it as a json.Number
+	id, err := GetMetaDataInt("instance/id", first.Tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 4573958230981234567 {
+		t.Errorf("instance/id = %v, want 4573958230981234567", id)
+	}
+
+	tests := []struct {
+		name       string
+		zone       string
+		generation uint64
+	}{
+		{name: "unchanged", zone: "projects/123/zones/us-central1-a", generation: first.Generation},
+		{name: "changed", zone: "projects/123/zones/europe-west1-b", generation: first.Generation + 1},
+	}
+
+	for _, tt := range tests {
+		tree := testMetadata(t)
+		tree["instance"].(map[string]interface{})["zone"] = tt.zone
+		src.SetTree(tree)
+
+		snapshot, err := cache.Snapshot(context.Background())
+		if err != nil {
+			t.Fatalf("%v: %v", tt.name, err)
+		}
+		if snapshot.Generation != tt.generation {
+			t.Errorf("%v: generation = %v, want %v", tt.name, snapshot.Generation, tt.generation)
+		}
+		if zone, _ := GetMetaDataStr("instance/zone", snapshot.Tree); zone != tt.zone {
+			t.Errorf("%v: zone = %q, want %q", tt.name, zone, tt.zone)
+		}
+	}
+}
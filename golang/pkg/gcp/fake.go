@@ -0,0 +1,98 @@
+package gcp
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+const (
+	FAKE_PLATFORM_GCE = "gce"
+	FAKE_PLATFORM_GKE = "gke"
+	FAKE_PLATFORM_RUN = "run"
+	FAKE_PLATFORM_GAE = "gae"
+	FAKE_PLATFORM_GCF = "gcf"
+)
+
+// canned metadata trees, roughly what the real metadata server returns on each platform
+//
+//go:embed fake/*.json
+var fakeTrees embed.FS
+
+// FakeMetadataTree returns the canned metadata tree for a platform
+func FakeMetadataTree(platform string) (map[string]interface{}, error) {
+	body, err := fakeTrees.ReadFile(fmt.Sprintf("fake/%v.json", platform))
+	if err != nil {
+		return nil, fmt.Errorf("no fake metadata for platform %v", platform)
+	}
+
+	return decodeTree(body)
+}
+
+// decodeTree parses a metadata tree, keeping numbers as json.Number so that
+// large instance IDs survive a round trip
+func decodeTree(body []byte) (map[string]interface{}, error) {
+	var tree map[string]interface{}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&tree); err != nil {
+		return nil, err
+	}
+
+	return tree, nil
+}
+
+// FakeSource is an in-memory metadata tree that can be swapped out at runtime
+type FakeSource struct {
+	mu   sync.RWMutex
+	tree map[string]interface{}
+}
+
+func NewFakeSource(tree map[string]interface{}) *FakeSource {
+	return &FakeSource{
+		tree: tree,
+	}
+}
+
+func NewFakeSourceForPlatform(platform string) (*FakeSource, error) {
+	tree, err := FakeMetadataTree(platform)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewFakeSource(tree), nil
+}
+
+// SetTree replaces the metadata tree served by the fake
+func (f *FakeSource) SetTree(tree map[string]interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.tree = tree
+}
+
+func (f *FakeSource) GetMetaData(ctx context.Context) (*string, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	body, err := json.Marshal(f.tree)
+	if err != nil {
+		return nil, err
+	}
+
+	bodyStr := string(body)
+	return &bodyStr, nil
+}
+
+func (f *FakeSource) GetProjectID(ctx context.Context) (*string, error) {
+	metadataStr, err := f.GetMetaData(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return projectIDFromTree(*metadataStr)
+}
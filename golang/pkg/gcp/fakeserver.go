@@ -0,0 +1,242 @@
+package gcp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	metadataPathPrefix = "/computeMetadata/v1/"
+	fakePollInterval   = 500 * time.Millisecond
+	fakeMaxWait        = 300 * time.Second
+)
+
+// FakeServer mimics enough of the GCE metadata server HTTP API to run the app
+// on a laptop: point GCE_METADATA_HOST at it and every metadata lookup is
+// served out of the wrapped MetadataSource.
+type FakeServer struct {
+	src MetadataSource
+}
+
+func NewFakeServer(src MetadataSource) *FakeServer {
+	return &FakeServer{
+		src: src,
+	}
+}
+
+// kebabToCamel turns a URL path segment like "service-accounts" into the
+// key used in the recursive JSON tree, "serviceAccounts"
+func kebabToCamel(s string) string {
+	parts := strings.Split(s, "-")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+
+	return strings.Join(parts, "")
+}
+
+// camelToKebab is the reverse of kebabToCamel, used for directory listings
+func camelToKebab(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		if c >= 'A' && c <= 'Z' {
+			b.WriteRune('-')
+			b.WriteRune(c - 'A' + 'a')
+			continue
+		}
+		b.WriteRune(c)
+	}
+
+	return b.String()
+}
+
+// lookup walks the tree one URL path segment at a time. Instance and project
+// attributes keep their own names, everything else is camelCased in the tree
+func lookup(tree interface{}, segments []string) (interface{}, bool) {
+	node := tree
+	for _, seg := range segments {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			if v, found := n[seg]; found {
+				node = v
+				continue
+			}
+			v, found := n[kebabToCamel(seg)]
+			if !found {
+				return nil, false
+			}
+			node = v
+		case []interface{}:
+			idx, err := strconv.Atoi(seg)
+			if err != nil || idx < 0 || idx >= len(n) {
+				return nil, false
+			}
+			node = n[idx]
+		default:
+			return nil, false
+		}
+	}
+
+	return node, true
+}
+
+func scalarString(v interface{}) string {
+	switch val := v.(type) {
+	case string:
+		return val
+	case nil:
+		return ""
+	default:
+		out, _ := json.Marshal(val)
+		return string(out)
+	}
+}
+
+// writeListing renders a directory the way the metadata server does when
+// recursive=true is not set
+func writeListing(w io.Writer, node interface{}) {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(n))
+		for k, v := range n {
+			name := camelToKebab(k)
+			switch v.(type) {
+			case map[string]interface{}, []interface{}:
+				name = name + "/"
+			}
+			keys = append(keys, name)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			fmt.Fprintf(w, "%s\n", k)
+		}
+	case []interface{}:
+		for i, v := range n {
+			switch v.(type) {
+			case map[string]interface{}, []interface{}:
+				fmt.Fprintf(w, "%d/\n", i)
+			default:
+				fmt.Fprintf(w, "%s\n", scalarString(v))
+			}
+		}
+	default:
+		fmt.Fprintf(w, "%s", scalarString(n))
+	}
+}
+
+// render looks up the requested path and renders it the way the metadata
+// server would, returning the body, its content type and an ETag
+func (f *FakeServer) render(r *http.Request) ([]byte, string, string, int, error) {
+	metadataStr, err := f.src.GetMetaData(r.Context())
+	if err != nil {
+		return nil, "", "", http.StatusInternalServerError, err
+	}
+
+	tree, err := decodeTree([]byte(*metadataStr))
+	if err != nil {
+		return nil, "", "", http.StatusInternalServerError, err
+	}
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, metadataPathPrefix), "/")
+	var segments []string
+	if path != "" {
+		segments = strings.Split(path, "/")
+	}
+
+	node, found := lookup(tree, segments)
+	if !found {
+		return nil, "", "", http.StatusNotFound, fmt.Errorf("%v not found", r.URL.Path)
+	}
+
+	var body bytes.Buffer
+	contentType := "application/text"
+
+	query := r.URL.Query()
+	if query.Get("recursive") == "true" || query.Get("alt") == "json" {
+		contentType = "application/json"
+		json.NewEncoder(&body).Encode(node)
+	} else {
+		writeListing(&body, node)
+	}
+
+	h := fnv.New64a()
+	h.Write(body.Bytes())
+	etag := fmt.Sprintf("%016x", h.Sum64())
+
+	return body.Bytes(), contentType, etag, http.StatusOK, nil
+}
+
+func (f *FakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Metadata-Flavor") != "Google" {
+		http.Error(w, "Missing Metadata-Flavor:Google header.", http.StatusForbidden)
+		return
+	}
+
+	if !strings.HasPrefix(r.URL.Path, metadataPathPrefix) {
+		http.NotFound(w, r)
+		return
+	}
+
+	zap.L().Debug("Serving fake metadata",
+		zap.String("path", r.URL.Path),
+		zap.String("query", r.URL.RawQuery))
+
+	body, contentType, etag, code, err := f.render(r)
+
+	// wait_for_change blocks until the ETag moves on from last_etag
+	query := r.URL.Query()
+	if err == nil && query.Get("wait_for_change") == "true" {
+		lastETag := query.Get("last_etag")
+
+		timeout := fakeMaxWait
+		if secs, convErr := strconv.Atoi(query.Get("timeout_sec")); convErr == nil && secs > 0 && time.Duration(secs)*time.Second < timeout {
+			timeout = time.Duration(secs) * time.Second
+		}
+		deadline := time.After(timeout)
+
+	wait:
+		for err == nil && (lastETag == "" || etag == lastETag) {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-deadline:
+				// like the real server, answer with what is there now
+				break wait
+			case <-time.After(fakePollInterval):
+			}
+
+			if lastETag == "" {
+				// no etag to compare with, wait for the next change from now
+				lastETag = etag
+			}
+
+			body, contentType, etag, code, err = f.render(r)
+		}
+	}
+
+	if err != nil {
+		if code != http.StatusNotFound {
+			zap.S().Errorf("Unable to serve metadata: %s", err)
+		}
+		http.Error(w, err.Error(), code)
+		return
+	}
+
+	w.Header().Set("Metadata-Flavor", "Google")
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", etag)
+	w.Write(body)
+}
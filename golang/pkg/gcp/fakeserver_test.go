@@ -0,0 +1,205 @@
+package gcp
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// a wait_for_change that ignores its timeout fails the test rather than
+// hanging it
+var testClient = &http.Client{Timeout: 5 * time.Second}
+
+func newTestFakeServer(t *testing.T) (*FakeSource, *httptest.Server) {
+	t.Helper()
+
+	src := NewFakeSource(testMetadata(t))
+	srv := httptest.NewServer(NewFakeServer(src))
+	t.Cleanup(srv.Close)
+
+	return src, srv
+}
+
+func getMetadata(t *testing.T, srv *httptest.Server, path string, flavor bool) (*http.Response, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flavor {
+		req.Header.Set("Metadata-Flavor", "Google")
+	}
+
+	resp, err := testClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp, string(body)
+}
+
+func TestFakeServer(t *testing.T) {
+	tests := []struct {
+		name        string
+		path        string
+		noFlavor    bool
+		status      int
+		contentType string
+		body        string
+	}{
+		{
+			name:        "value",
+			path:        "/computeMetadata/v1/instance/zone",
+			status:      http.StatusOK,
+			contentType: "application/text",
+			body:        "projects/123/zones/us-central1-a",
+		},
+		{
+			name:   "kebab case path",
+			path:   "/computeMetadata/v1/instance/cpu-platform",
+			status: http.StatusOK,
+			body:   "Intel Broadwell",
+		},
+		{
+			name:   "array element",
+			path:   "/computeMetadata/v1/instance/network-interfaces/1/ip",
+			status: http.StatusOK,
+			body:   "10.0.1.2",
+		},
+		{
+			name:   "large number",
+			path:   "/computeMetadata/v1/instance/id",
+			status: http.StatusOK,
+			body:   "4573958230981234567",
+		},
+		{
+			name:   "directory listing",
+			path:   "/computeMetadata/v1/instance/service-accounts/",
+			status: http.StatusOK,
+			body:   "default/\nother/\n",
+		},
+		{
+			name:   "array listing",
+			path:   "/computeMetadata/v1/instance/tags",
+			status: http.StatusOK,
+			body:   "http-server\nhttps-server\n",
+		},
+		{
+			name:        "recursive",
+			path:        "/computeMetadata/v1/instance/scheduling?recursive=true",
+			status:      http.StatusOK,
+			contentType: "application/json",
+			body:        `{"automaticRestart":"FALSE","preemptible":"TRUE"}` + "\n",
+		},
+		{
+			name:   "missing",
+			path:   "/computeMetadata/v1/instance/hostname",
+			status: http.StatusNotFound,
+		},
+		{
+			name:   "outside the API",
+			path:   "/other",
+			status: http.StatusNotFound,
+		},
+		{
+			name:     "without Metadata-Flavor",
+			path:     "/computeMetadata/v1/instance/zone",
+			noFlavor: true,
+			status:   http.StatusForbidden,
+		},
+	}
+
+	_, srv := newTestFakeServer(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := getMetadata(t, srv, tt.path, !tt.noFlavor)
+
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status = %v, want %v", resp.StatusCode, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			if resp.Header.Get("ETag") == "" {
+				t.Error("no ETag")
+			}
+			if tt.contentType != "" && resp.Header.Get("Content-Type") != tt.contentType {
+				t.Errorf("Content-Type = %q, want %q", resp.Header.Get("Content-Type"), tt.contentType)
+			}
+			if body != tt.body {
+				t.Errorf("body = %q, want %q", body, tt.body)
+			}
+		})
+	}
+}
+
+func TestFakeServerWaitForChangeTimeout(t *testing.T) {
+	_, srv := newTestFakeServer(t)
+
+	resp, body := getMetadata(t, srv, "/computeMetadata/v1/instance/zone", true)
+	etag := resp.Header.Get("ETag")
+
+	started := time.Now()
+	resp, waited := getMetadata(t, srv, "/computeMetadata/v1/instance/zone?wait_for_change=true&timeout_sec=1&last_etag="+etag, true)
+
+	// the timeout is only checked between polls
+	if elapsed := time.Since(started); elapsed > time.Second+2*fakePollInterval {
+		t.Errorf("took %v to time out, want about 1s", elapsed)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %v, want %v", resp.StatusCode, http.StatusOK)
+	}
+	if resp.Header.Get("ETag") != etag || waited != body {
+		t.Errorf("got %q with ETag %v, want the unchanged %q with ETag %v", waited, resp.Header.Get("ETag"), body, etag)
+	}
+}
+
+func TestFakeServerWaitForChange(t *testing.T) {
+	src, srv := newTestFakeServer(t)
+
+	resp, _ := getMetadata(t, srv, "/computeMetadata/v1/instance/zone", true)
+	etag := resp.Header.Get("ETag")
+
+	tree := testMetadata(t)
+	tree["instance"].(map[string]interface{})["zone"] = "projects/123/zones/europe-west1-b"
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		src.SetTree(tree)
+	}()
+
+	resp, body := getMetadata(t, srv, "/computeMetadata/v1/instance/zone?wait_for_change=true&timeout_sec=10&last_etag="+etag, true)
+	if body != "projects/123/zones/europe-west1-b" {
+		t.Errorf("body = %q, want the new zone", body)
+	}
+	if resp.Header.Get("ETag") == etag {
+		t.Error("ETag didn't change")
+	}
+}
+
+func TestFakeMetadataTrees(t *testing.T) {
+	for _, platform := range []string{"gce", "gke", "run", "gae", "gcf"} {
+		tree, err := FakeMetadataTree(platform)
+		if err != nil {
+			t.Errorf("%v: %v", platform, err)
+			continue
+		}
+
+		if _, err := GetMetaDataStr("project/projectId", tree); err != nil {
+			t.Errorf("%v: no project ID: %v", platform, err)
+		}
+	}
+
+	if _, err := FakeMetadataTree("nowhere"); err == nil {
+		t.Error("expected an error for an unknown platform")
+	}
+}
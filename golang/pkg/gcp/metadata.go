@@ -2,150 +2,72 @@ package gcp
 
 import (
 	"context"
-	"encoding/json"
-	"net/http"
-	"regexp"
-	"strconv"
-	"strings"
-
-	"go.uber.org/zap"
-
-	httpreq "helloworld-http/pkg/util"
 )
 
-func getMetaDataVal(path string, metadata map[string]interface{}) (interface{}) {
-	//zap.S().Info("looking for path ", path, " in ", metadata)
-
-	// string is a slash-delimited path to traverse down the json tree
-	s := strings.SplitN(path, "/", 2)
-
-	if len(s) == 0 {
-		// empty string?
-		return nil
-	}
-
-	p0 := s[0]
-	br := metadata[p0]
-
-	// check if the path fragment has a [] which indicates we expect an array
-	rexp := regexp.MustCompile(`(?P<path>[a-zA-z]+)\[(?P<index>\d+)\]`)
-	matched := rexp.MatchString(s[0])
-
-	if matched {
-		pathStr := "${path}"
-		indexStr := "${index}"
-		p0 = rexp.ReplaceAllString(s[0], pathStr)
-		idx, _ := strconv.Atoi(rexp.ReplaceAllString(s[0], indexStr))
-
-		if metadata[p0] == nil {
-			return nil
-		}
-
-		brArray := metadata[p0].([]interface{})
-		br = brArray[idx]
-
-		// TODO: here we assume that it's an array of maps, what if it's just an array of strings or something?
-	}
-
-
-	if br == nil {
-		//zap.S().Info("not found path: ", path)
-		return nil
-	}
-
-	if len(s) == 1 {
-		//zap.S().Info("found path ", path, " ", br)
-		return br
+// GetMetaDataStr looks up a single value in the metadata tree, see Query
+// for the path syntax
+func GetMetaDataStr(path string, metadata map[string]interface{}) (string, error) {
+	q, err := compileQuery(path)
+	if err != nil {
+		return "", err
 	}
 
-	// call myself on the remainder of the path
-	return getMetaDataVal(s[1], br.(map[string]interface{}))
+	return q.Str(metadata)
 }
 
-func GetMetaDataStrVal(path string, metadata map[string]interface{}) (*string) {
-	val := getMetaDataVal(path, metadata)
-	if val == nil {
-		return nil
+// GetMetaDataStrs looks up every value a (wildcard) path matches, or the
+// elements of an array of scalars
+func GetMetaDataStrs(path string, metadata map[string]interface{}) ([]string, error) {
+	q, err := compileQuery(path)
+	if err != nil {
+		return nil, err
 	}
 
-	valStr := val.(string)
-	return &valStr
+	return q.Strings(metadata)
 }
 
-func GetMetaDataArrVal(path string, metadata map[string]interface{}) ([]interface{}) {
-	val := getMetaDataVal(path, metadata)
-	if val == nil {
-		return nil
+func GetMetaDataBool(path string, metadata map[string]interface{}) (bool, error) {
+	q, err := compileQuery(path)
+	if err != nil {
+		return false, err
 	}
 
-	valArr := val.([]interface{})
-	return valArr
+	return q.Bool(metadata)
 }
 
-func GetMetaDataBoolVal(path string, metadata map[string]interface{}) (*bool) {
-	val := getMetaDataVal(path, metadata)
-	if val == nil {
-		return nil
+func GetMetaDataInt(path string, metadata map[string]interface{}) (int64, error) {
+	q, err := compileQuery(path)
+	if err != nil {
+		return 0, err
 	}
 
-	valBool := val.(bool)
-	return &valBool
+	return q.Int(metadata)
 }
 
-func GetProjectID(ctx context.Context) (*string, error) {
-	metaDataURL := "http://metadata/computeMetadata/v1/project/project-id"
-	req, err := http.NewRequest(
-		"GET",
-		metaDataURL,
-		nil,
-	)
-	if (err != nil) {
+func GetMetaDataArr(path string, metadata map[string]interface{}) ([]interface{}, error) {
+	q, err := compileQuery(path)
+	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Add("Metadata-Flavor", "Google")
-	req = req.WithContext(ctx)
-	code, body := httpreq.MakeRequest(req)
-
-	if code == 200 {
-		bodyStr := string(body)
-		var md map[string]interface{}
-		json.Unmarshal([]byte(bodyStr), &md)
-		zap.L().Info("Called metadata server", 
-			zap.Any("metadata", md))
-
-
-		return &bodyStr, nil
-	}
-
-	return nil, nil
+	return q.Array(metadata)
 }
 
-func GetMetaData(ctx context.Context) (*string, error) {
-	metaDataURL := "http://metadata/computeMetadata/v1/?recursive=true"
-	req, err := http.NewRequest(
-		"GET",
-		metaDataURL,
-		nil,
-	)
-	if (err != nil) {
+func GetMetaDataMap(path string, metadata map[string]interface{}) (map[string]interface{}, error) {
+	q, err := compileQuery(path)
+	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Add("Metadata-Flavor", "Google")
-	req = req.WithContext(ctx)
-	code, body := httpreq.MakeRequest(req)
-
-	if code == 200 {
-		bodyStr := string(body)
-		var md map[string]interface{}
-		json.Unmarshal([]byte(bodyStr), &md)
-		zap.L().Debug("Called metadata server", 
-			zap.Any("metadata", md))
-
+	return q.Map(metadata)
+}
 
-		return &bodyStr, nil
-	}
+// GetProjectID returns the project ID from the configured MetadataSource
+func GetProjectID(ctx context.Context) (*string, error) {
+	return getMetadataSource().GetProjectID(ctx)
+}
 
-	return nil, nil
+// GetMetaData returns the recursive metadata tree from the configured MetadataSource
+func GetMetaData(ctx context.Context) (*string, error) {
+	return getMetadataSource().GetMetaData(ctx)
 }
@@ -0,0 +1,402 @@
+package gcp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Queries walk the recursive metadata tree with a slash-delimited path:
+//
+//	instance/zone                           a single value
+//	instance/networkInterfaces[0]/ip        an element of an array
+//	instance/networkInterfaces[0]/ipAliases[1]
+//	instance/networkInterfaces[*]/ip        every element of an array
+//	instance/serviceAccounts/*/email        every value of a map
+//	matrix[0][1]                            nested arrays
+//	instance/scheduling/preemptible|FALSE   a default if the path isn't there
+//
+// A query with a wildcard can match many values, anything else matches one.
+
+var (
+	ErrNotFound      = errors.New("not found")
+	ErrIndexRange    = errors.New("index out of range")
+	ErrTypeMismatch  = errors.New("unexpected type")
+	ErrInvalidSyntax = errors.New("invalid query")
+)
+
+// QueryError says which query failed and where in the path it went wrong
+type QueryError struct {
+	Query   string
+	Segment string
+	Err     error
+}
+
+func (e *QueryError) Error() string {
+	if e.Segment == "" {
+		return fmt.Sprintf("metadata query %q: %v", e.Query, e.Err)
+	}
+
+	return fmt.Sprintf("metadata query %q at %q: %v", e.Query, e.Segment, e.Err)
+}
+
+func (e *QueryError) Unwrap() error {
+	return e.Err
+}
+
+// step is a single move down the tree: a map key, an array index, or a
+// wildcard over either
+type step struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+	segment  string
+}
+
+type Query struct {
+	expr       string
+	steps      []step
+	def        *string
+	isWildcard bool
+}
+
+func (q *Query) String() string {
+	return q.expr
+}
+
+// ParseQuery compiles a query expression
+func ParseQuery(expr string) (*Query, error) {
+	q := &Query{expr: expr}
+
+	path := expr
+	if i := strings.Index(expr, "|"); i >= 0 {
+		def := expr[i+1:]
+		q.def = &def
+		path = expr[:i]
+	}
+
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return q, nil
+	}
+
+	for _, seg := range strings.Split(path, "/") {
+		if seg == "" {
+			return nil, &QueryError{Query: expr, Err: fmt.Errorf("%w: empty path segment", ErrInvalidSyntax)}
+		}
+
+		name := seg
+		rest := ""
+		if i := strings.Index(seg, "["); i >= 0 {
+			name = seg[:i]
+			rest = seg[i:]
+		}
+
+		if name == "*" {
+			q.steps = append(q.steps, step{wildcard: true, segment: seg})
+			q.isWildcard = true
+		} else if name != "" {
+			q.steps = append(q.steps, step{key: name, segment: seg})
+		}
+
+		// any number of [n] or [*] after the name
+		for rest != "" {
+			end := strings.Index(rest, "]")
+			if rest[0] != '[' || end < 0 {
+				return nil, &QueryError{Query: expr, Segment: seg, Err: fmt.Errorf("%w: unbalanced brackets", ErrInvalidSyntax)}
+			}
+
+			idxStr := rest[1:end]
+			rest = rest[end+1:]
+
+			if idxStr == "*" {
+				q.steps = append(q.steps, step{isIndex: true, wildcard: true, segment: seg})
+				q.isWildcard = true
+				continue
+			}
+
+			idx, err := strconv.Atoi(idxStr)
+			if err != nil || idx < 0 {
+				return nil, &QueryError{Query: expr, Segment: seg, Err: fmt.Errorf("%w: bad index %q", ErrInvalidSyntax, idxStr)}
+			}
+			q.steps = append(q.steps, step{isIndex: true, index: idx, segment: seg})
+		}
+	}
+
+	return q, nil
+}
+
+// MustParseQuery is ParseQuery for queries known at compile time
+func MustParseQuery(expr string) *Query {
+	q, err := ParseQuery(expr)
+	if err != nil {
+		panic(err)
+	}
+
+	return q
+}
+
+// compiled queries, keyed by expression, so the string getters only parse once
+var queryCache sync.Map
+
+func compileQuery(expr string) (*Query, error) {
+	if q, found := queryCache.Load(expr); found {
+		return q.(*Query), nil
+	}
+
+	q, err := ParseQuery(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	queryCache.Store(expr, q)
+	return q, nil
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case float64, json.Number:
+		return "number"
+	case nil:
+		return "null"
+	}
+
+	return fmt.Sprintf("%T", v)
+}
+
+func (q *Query) eval(node interface{}, steps []step, out []interface{}) ([]interface{}, error) {
+	if len(steps) == 0 {
+		return append(out, node), nil
+	}
+
+	s := steps[0]
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if s.isIndex {
+			return out, &QueryError{Query: q.expr, Segment: s.segment, Err: fmt.Errorf("%w: want array, got object", ErrTypeMismatch)}
+		}
+
+		if s.wildcard {
+			keys := make([]string, 0, len(n))
+			for k := range n {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+
+			for _, k := range keys {
+				var err error
+				out, err = q.eval(n[k], steps[1:], out)
+				if err != nil && !errors.Is(err, ErrNotFound) && !errors.Is(err, ErrIndexRange) {
+					return out, err
+				}
+			}
+			return out, nil
+		}
+
+		child, found := n[s.key]
+		if !found || child == nil {
+			return out, &QueryError{Query: q.expr, Segment: s.segment, Err: ErrNotFound}
+		}
+		return q.eval(child, steps[1:], out)
+
+	case []interface{}:
+		if !s.isIndex {
+			return out, &QueryError{Query: q.expr, Segment: s.segment, Err: fmt.Errorf("%w: want object, got array", ErrTypeMismatch)}
+		}
+
+		if s.wildcard {
+			for _, child := range n {
+				var err error
+				out, err = q.eval(child, steps[1:], out)
+				if err != nil && !errors.Is(err, ErrNotFound) && !errors.Is(err, ErrIndexRange) {
+					return out, err
+				}
+			}
+			return out, nil
+		}
+
+		if s.index >= len(n) {
+			return out, &QueryError{Query: q.expr, Segment: s.segment, Err: fmt.Errorf("%w: %d of %d", ErrIndexRange, s.index, len(n))}
+		}
+		return q.eval(n[s.index], steps[1:], out)
+	}
+
+	want := "object"
+	if s.isIndex {
+		want = "array"
+	}
+	return out, &QueryError{Query: q.expr, Segment: s.segment, Err: fmt.Errorf("%w: want %v, got %v", ErrTypeMismatch, want, typeName(node))}
+}
+
+// Eval returns every value the query matches. A query without wildcards
+// matches at most one. If nothing matches and the query has a default, the
+// default is returned instead of ErrNotFound.
+func (q *Query) Eval(metadata map[string]interface{}) ([]interface{}, error) {
+	results, err := q.eval(metadata, q.steps, nil)
+	if err == nil && len(results) == 0 {
+		err = &QueryError{Query: q.expr, Err: ErrNotFound}
+	}
+
+	if err != nil && q.def != nil && (errors.Is(err, ErrNotFound) || errors.Is(err, ErrIndexRange)) {
+		return []interface{}{*q.def}, nil
+	}
+
+	return results, err
+}
+
+func (q *Query) one(metadata map[string]interface{}) (interface{}, error) {
+	results, err := q.Eval(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(results) > 1 {
+		return nil, &QueryError{Query: q.expr, Err: fmt.Errorf("%w: matched %d values, want 1", ErrTypeMismatch, len(results))}
+	}
+
+	return results[0], nil
+}
+
+func (q *Query) scalarString(v interface{}) (string, error) {
+	switch val := v.(type) {
+	case string:
+		return val, nil
+	case json.Number:
+		return val.String(), nil
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(val), nil
+	}
+
+	return "", &QueryError{Query: q.expr, Err: fmt.Errorf("%w: want string, got %v", ErrTypeMismatch, typeName(v))}
+}
+
+// Str returns the single value the query matches as a string. Numbers and
+// bools are formatted, since the metadata server serves them as text anyway.
+func (q *Query) Str(metadata map[string]interface{}) (string, error) {
+	v, err := q.one(metadata)
+	if err != nil {
+		return "", err
+	}
+
+	return q.scalarString(v)
+}
+
+// Strings returns every value the query matches as strings. A single match
+// that is an array of scalars is flattened.
+func (q *Query) Strings(metadata map[string]interface{}) ([]string, error) {
+	results, err := q.Eval(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(results) == 1 && !q.isWildcard {
+		if arr, ok := results[0].([]interface{}); ok {
+			results = arr
+		}
+	}
+
+	out := make([]string, 0, len(results))
+	for _, r := range results {
+		s, err := q.scalarString(r)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+
+	return out, nil
+}
+
+// Bool returns the single value the query matches as a bool. The metadata
+// server uses "TRUE" and "FALSE" strings for most flags, so those count too.
+func (q *Query) Bool(metadata map[string]interface{}) (bool, error) {
+	v, err := q.one(metadata)
+	if err != nil {
+		return false, err
+	}
+
+	switch val := v.(type) {
+	case bool:
+		return val, nil
+	case string:
+		b, err := strconv.ParseBool(strings.ToLower(val))
+		if err != nil {
+			return false, &QueryError{Query: q.expr, Err: fmt.Errorf("%w: %q is not a bool", ErrTypeMismatch, val)}
+		}
+		return b, nil
+	}
+
+	return false, &QueryError{Query: q.expr, Err: fmt.Errorf("%w: want bool, got %v", ErrTypeMismatch, typeName(v))}
+}
+
+// Int returns the single value the query matches as an integer
+func (q *Query) Int(metadata map[string]interface{}) (int64, error) {
+	v, err := q.one(metadata)
+	if err != nil {
+		return 0, err
+	}
+
+	var str string
+	switch val := v.(type) {
+	case float64:
+		return int64(val), nil
+	case json.Number:
+		str = val.String()
+	case string:
+		str = val
+	default:
+		return 0, &QueryError{Query: q.expr, Err: fmt.Errorf("%w: want number, got %v", ErrTypeMismatch, typeName(v))}
+	}
+
+	i, err := strconv.ParseInt(str, 10, 64)
+	if err != nil {
+		return 0, &QueryError{Query: q.expr, Err: fmt.Errorf("%w: %q is not an integer", ErrTypeMismatch, str)}
+	}
+
+	return i, nil
+}
+
+// Array returns the single value the query matches as an array
+func (q *Query) Array(metadata map[string]interface{}) ([]interface{}, error) {
+	v, err := q.one(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	arr, ok := v.([]interface{})
+	if !ok {
+		return nil, &QueryError{Query: q.expr, Err: fmt.Errorf("%w: want array, got %v", ErrTypeMismatch, typeName(v))}
+	}
+
+	return arr, nil
+}
+
+// Map returns the single value the query matches as an object
+func (q *Query) Map(metadata map[string]interface{}) (map[string]interface{}, error) {
+	v, err := q.one(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, &QueryError{Query: q.expr, Err: fmt.Errorf("%w: want object, got %v", ErrTypeMismatch, typeName(v))}
+	}
+
+	return m, nil
+}
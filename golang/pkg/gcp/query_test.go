@@ -0,0 +1,201 @@
+package gcp
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+const testTree = `{
+	"instance": {
+		"id": 4573958230981234567,
+		"zone": "projects/123/zones/us-central1-a",
+		"cpuPlatform": "Intel Broadwell",
+		"scheduling": {"preemptible": "TRUE", "automaticRestart": "FALSE"},
+		"networkInterfaces": [
+			{"ip": "10.0.0.2", "ipAliases": ["10.1.0.0/24", "10.2.0.0/24"]},
+			{"ip": "10.0.1.2", "ipAliases": []}
+		],
+		"serviceAccounts": {
+			"default": {"email": "sa@example.iam.gserviceaccount.com", "scopes": ["cloud-platform"]},
+			"other": {"email": "other@example.iam.gserviceaccount.com"}
+		},
+		"tags": ["http-server", "https-server"]
+	},
+	"matrix": [[1, 2], [3, 4]],
+	"flag": true
+}`
+
+func testMetadata(t *testing.T) map[string]interface{} {
+	t.Helper()
+
+	tree, err := decodeTree([]byte(testTree))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tree
+}
+
+func TestParseQueryInvalid(t *testing.T) {
+	tests := []string{
+		"instance//zone",
+		"instance/networkInterfaces[0",
+		"instance/networkInterfaces[x]",
+		"instance/networkInterfaces[-1]",
+		"instance/networkInterfaces[0]x",
+	}
+
+	for _, expr := range tests {
+		if _, err := ParseQuery(expr); !errors.Is(err, ErrInvalidSyntax) {
+			t.Errorf("ParseQuery(%q) error = %v, want ErrInvalidSyntax", expr, err)
+		}
+	}
+}
+
+func TestQueryStr(t *testing.T) {
+	tests := []struct {
+		expr    string
+		want    string
+		wantErr error
+	}{
+		{expr: "instance/zone", want: "projects/123/zones/us-central1-a"},
+		{expr: "/instance/zone/", want: "projects/123/zones/us-central1-a"},
+		{expr: "instance/networkInterfaces[1]/ip", want: "10.0.1.2"},
+		{expr: "instance/networkInterfaces[0]/ipAliases[1]", want: "10.2.0.0/24"},
+		{expr: "matrix[1][0]", want: "3"},
+		{expr: "flag", want: "true"},
+		{expr: "instance/id", want: "4573958230981234567"},
+		{expr: "instance/hostname|none", want: "none"},
+		{expr: "instance/networkInterfaces[5]/ip|none", want: "none"},
+		{expr: "instance/zone|none", want: "projects/123/zones/us-central1-a"},
+		{expr: "instance/hostname", wantErr: ErrNotFound},
+		{expr: "instance/networkInterfaces[2]/ip", wantErr: ErrIndexRange},
+		{expr: "instance/zone/name", wantErr: ErrTypeMismatch},
+		{expr: "instance/networkInterfaces/ip", wantErr: ErrTypeMismatch},
+		{expr: "instance[0]", wantErr: ErrTypeMismatch},
+		{expr: "instance/scheduling", wantErr: ErrTypeMismatch},
+		{expr: "instance/networkInterfaces[*]/ip", wantErr: ErrTypeMismatch},
+	}
+
+	metadata := testMetadata(t)
+	for _, tt := range tests {
+		got, err := GetMetaDataStr(tt.expr, metadata)
+		if tt.wantErr != nil {
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("%q: error = %v, want %v", tt.expr, err, tt.wantErr)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", tt.expr, err)
+		} else if got != tt.want {
+			t.Errorf("%q = %q, want %q", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestQueryStrings(t *testing.T) {
+	tests := []struct {
+		expr string
+		want []string
+	}{
+		{expr: "instance/tags", want: []string{"http-server", "https-server"}},
+		{expr: "instance/networkInterfaces[*]/ip", want: []string{"10.0.0.2", "10.0.1.2"}},
+		{expr: "instance/networkInterfaces[*]/ipAliases[1]", want: []string{"10.2.0.0/24"}},
+		{expr: "instance/serviceAccounts/*/email", want: []string{"sa@example.iam.gserviceaccount.com", "other@example.iam.gserviceaccount.com"}},
+		{expr: "instance/serviceAccounts/*/scopes[0]", want: []string{"cloud-platform"}},
+		{expr: "matrix[*][1]", want: []string{"2", "4"}},
+	}
+
+	metadata := testMetadata(t)
+	for _, tt := range tests {
+		q, err := ParseQuery(tt.expr)
+		if err != nil {
+			t.Fatalf("ParseQuery(%q): %v", tt.expr, err)
+		}
+
+		got, err := q.Strings(metadata)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", tt.expr, err)
+		} else if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q = %q, want %q", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestQueryBool(t *testing.T) {
+	tests := []struct {
+		expr    string
+		want    bool
+		wantErr error
+	}{
+		{expr: "instance/scheduling/preemptible", want: true},
+		{expr: "instance/scheduling/automaticRestart", want: false},
+		{expr: "flag", want: true},
+		{expr: "instance/scheduling/onHostMaintenance|FALSE", want: false},
+		{expr: "instance/zone", wantErr: ErrTypeMismatch},
+		{expr: "instance/scheduling/onHostMaintenance", wantErr: ErrNotFound},
+	}
+
+	metadata := testMetadata(t)
+	for _, tt := range tests {
+		got, err := MustParseQuery(tt.expr).Bool(metadata)
+		if tt.wantErr != nil {
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("%q: error = %v, want %v", tt.expr, err, tt.wantErr)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", tt.expr, err)
+		} else if got != tt.want {
+			t.Errorf("%q = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestQueryInt(t *testing.T) {
+	tests := []struct {
+		expr    string
+		want    int64
+		wantErr error
+	}{
+		// past 2^53, so only exact if the tree kept it as a json.Number
+		{expr: "instance/id", want: 4573958230981234567},
+		{expr: "matrix[0][1]", want: 2},
+		{expr: "instance/cpuPlatform", wantErr: ErrTypeMismatch},
+		{expr: "instance/tags", wantErr: ErrTypeMismatch},
+	}
+
+	metadata := testMetadata(t)
+	for _, tt := range tests {
+		got, err := MustParseQuery(tt.expr).Int(metadata)
+		if tt.wantErr != nil {
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("%q: error = %v, want %v", tt.expr, err, tt.wantErr)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", tt.expr, err)
+		} else if got != tt.want {
+			t.Errorf("%q = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestQueryErrorNamesSegment(t *testing.T) {
+	_, err := GetMetaDataStr("instance/networkInterfaces[3]/ip", testMetadata(t))
+
+	var qe *QueryError
+	if !errors.As(err, &qe) {
+		t.Fatalf("error = %v, want a *QueryError", err)
+	}
+	if qe.Segment != "networkInterfaces[3]" {
+		t.Errorf("Segment = %q, want %q", qe.Segment, "networkInterfaces[3]")
+	}
+}
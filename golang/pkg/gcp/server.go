@@ -0,0 +1,141 @@
+package gcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+
+	httpreq "helloworld-http/pkg/util"
+)
+
+const (
+	DEFAULT_METADATA_HOST = "metadata"
+
+	// the metadata server is local, if it takes longer than this it's not coming back
+	metadataTimeout = 5 * time.Second
+)
+
+// ServerSource reads metadata from a GCE metadata server (or anything that
+// looks like one, e.g. the fake server in this package)
+type ServerSource struct {
+	host   string
+	client *httpreq.Client
+}
+
+func NewServerSource(host string) *ServerSource {
+	if host == "" {
+		host = DEFAULT_METADATA_HOST
+	}
+
+	return &ServerSource{
+		host:   host,
+		client: httpreq.NewClient(httpreq.WithTimeout(metadataTimeout)),
+	}
+}
+
+func (s *ServerSource) get(ctx context.Context, path string) (*string, error) {
+	body, _, err := s.getWithETag(ctx, path)
+	return body, err
+}
+
+// startSpan traces a metadata request when it's made on behalf of something
+// that's traced, like a request, and not for the background refresh
+func startSpan(ctx context.Context, path string) (context.Context, trace.Span) {
+	if !trace.SpanFromContext(ctx).SpanContext().IsValid() {
+		return ctx, trace.SpanFromContext(ctx)
+	}
+
+	metadataPath, query, _ := strings.Cut(path, "?")
+	params, _ := url.ParseQuery(query)
+
+	return otel.Tracer("helloworld-http").Start(ctx, "gcp metadata request",
+		trace.WithAttributes(
+			attribute.String("gcp.metadata.path", "/"+metadataPath),
+			attribute.Bool("gcp.metadata.recursive", params.Get("recursive") == "true"),
+			attribute.Bool("gcp.metadata.wait_for_change", params.Get("wait_for_change") == "true"),
+		))
+}
+
+func (s *ServerSource) getWithETag(ctx context.Context, path string) (*string, string, error) {
+	ctx, span := startSpan(ctx, path)
+	defer span.End()
+
+	metaDataURL := fmt.Sprintf("http://%v/computeMetadata/v1/%v", s.host, path)
+	req, err := http.NewRequest(
+		"GET",
+		metaDataURL,
+		nil,
+	)
+	if err != nil {
+		return nil, "", err
+	}
+
+	req.Header.Add("Metadata-Flavor", "Google")
+	req = req.WithContext(ctx)
+	resp, err := s.client.Do(req)
+	if resp != nil {
+		span.SetAttributes(semconv.HTTPStatusCodeKey.Int(resp.StatusCode))
+	}
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, "", err
+	}
+
+	str := string(resp.Body)
+	return &str, resp.Header.Get("ETag"), nil
+}
+
+func (s *ServerSource) GetProjectID(ctx context.Context) (*string, error) {
+	project, err := s.get(ctx, "project/project-id")
+	if err != nil {
+		return nil, err
+	}
+
+	zap.L().Info("Called metadata server",
+		zap.String("projectId", *project))
+
+	return project, nil
+}
+
+func (s *ServerSource) GetMetaData(ctx context.Context) (*string, error) {
+	bodyStr, err := s.get(ctx, "?recursive=true")
+	if err != nil {
+		return nil, err
+	}
+
+	var md map[string]interface{}
+	json.Unmarshal([]byte(*bodyStr), &md)
+	zap.L().Debug("Called metadata server",
+		zap.Any("metadata", md))
+
+	return bodyStr, nil
+}
+
+// WatchMetaData long-polls the metadata server until the recursive tree no
+// longer matches lastETag, or until timeout passes. An empty lastETag
+// returns the current tree straight away.
+func (s *ServerSource) WatchMetaData(ctx context.Context, lastETag string, timeout time.Duration) (*string, string, error) {
+	path := "?recursive=true"
+	if lastETag != "" {
+		path = fmt.Sprintf("%v&wait_for_change=true&last_etag=%v&timeout_sec=%d",
+			path, url.QueryEscape(lastETag), int(timeout.Seconds()))
+
+		// give the server a chance to answer before we give up on it
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout+metadataTimeout)
+		defer cancel()
+	}
+
+	return s.getWithETag(ctx, path)
+}
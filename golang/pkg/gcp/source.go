@@ -0,0 +1,83 @@
+package gcp
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// MetadataSource is anything that can hand back the GCE metadata tree, so
+// that the rest of the app can run (and be poked at) off of GCP
+type MetadataSource interface {
+	// GetProjectID returns the project ID the app is running in
+	GetProjectID(ctx context.Context) (*string, error)
+
+	// GetMetaData returns the full metadata tree as a JSON string, in the
+	// same shape as computeMetadata/v1/?recursive=true
+	GetMetaData(ctx context.Context) (*string, error)
+}
+
+const (
+	SOURCE_SERVER = "server"
+	SOURCE_FILE   = "file"
+	SOURCE_ENV    = "env"
+	SOURCE_FAKE   = "fake"
+)
+
+var (
+	sourceMu      sync.RWMutex
+	defaultSource MetadataSource = NewServerSource("")
+)
+
+// SetMetadataSource replaces the source used by GetProjectID and GetMetaData
+func SetMetadataSource(src MetadataSource) {
+	sourceMu.Lock()
+	defer sourceMu.Unlock()
+
+	defaultSource = src
+}
+
+func getMetadataSource() MetadataSource {
+	sourceMu.RLock()
+	defer sourceMu.RUnlock()
+
+	return defaultSource
+}
+
+// NewMetadataSourceFromEnv picks a MetadataSource based on METADATA_SOURCE:
+//
+//	server (default) - the GCE metadata server, GCE_METADATA_HOST overrides the host
+//	file             - a static JSON tree read from METADATA_FILE
+//	env              - a static JSON tree read from METADATA_JSON
+//	fake             - a canned in-memory tree for METADATA_FAKE_PLATFORM (gce, gke, run, gae)
+func NewMetadataSourceFromEnv() (MetadataSource, error) {
+	source := os.Getenv("METADATA_SOURCE")
+
+	zap.S().Debugf("Using metadata source: %v", source)
+
+	switch source {
+	case "", SOURCE_SERVER:
+		return NewServerSource(os.Getenv("GCE_METADATA_HOST")), nil
+	case SOURCE_FILE:
+		filename := os.Getenv("METADATA_FILE")
+		if filename == "" {
+			return nil, fmt.Errorf("METADATA_FILE must be set when METADATA_SOURCE=%v", SOURCE_FILE)
+		}
+
+		return NewFileSource(filename), nil
+	case SOURCE_ENV:
+		return NewEnvSource("METADATA_JSON"), nil
+	case SOURCE_FAKE:
+		platform := os.Getenv("METADATA_FAKE_PLATFORM")
+		if platform == "" {
+			platform = FAKE_PLATFORM_GCE
+		}
+
+		return NewFakeSourceForPlatform(platform)
+	}
+
+	return nil, fmt.Errorf("unknown METADATA_SOURCE: %v", source)
+}
@@ -0,0 +1,111 @@
+package gcp
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewMetadataSourceFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    MetadataSource
+		wantErr bool
+	}{
+		{name: "default", want: &ServerSource{}},
+		{name: "server", env: map[string]string{"METADATA_SOURCE": SOURCE_SERVER}, want: &ServerSource{}},
+		{name: "file", env: map[string]string{"METADATA_SOURCE": SOURCE_FILE, "METADATA_FILE": "md.json"}, want: &FileSource{}},
+		{name: "file without a file", env: map[string]string{"METADATA_SOURCE": SOURCE_FILE}, wantErr: true},
+		{name: "env", env: map[string]string{"METADATA_SOURCE": SOURCE_ENV}, want: &EnvSource{}},
+		{name: "fake", env: map[string]string{"METADATA_SOURCE": SOURCE_FAKE}, want: &FakeSource{}},
+		{name: "fake platform", env: map[string]string{"METADATA_SOURCE": SOURCE_FAKE, "METADATA_FAKE_PLATFORM": FAKE_PLATFORM_RUN}, want: &FakeSource{}},
+		{name: "unknown fake platform", env: map[string]string{"METADATA_SOURCE": SOURCE_FAKE, "METADATA_FAKE_PLATFORM": "ec2"}, wantErr: true},
+		{name: "unknown", env: map[string]string{"METADATA_SOURCE": "s3"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, k := range []string{"METADATA_SOURCE", "METADATA_FILE", "METADATA_FAKE_PLATFORM", "GCE_METADATA_HOST"} {
+				t.Setenv(k, tt.env[k])
+			}
+
+			got, err := NewMetadataSourceFromEnv()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if gotType, wantType := fmt.Sprintf("%T", got), fmt.Sprintf("%T", tt.want); gotType != wantType {
+				t.Errorf("got a %v, want a %v", gotType, wantType)
+			}
+		})
+	}
+}
+
+// every source has to agree on what the project is for the same tree
+func TestSourcesProjectID(t *testing.T) {
+	fake, err := NewFakeSourceForPlatform(FAKE_PLATFORM_GCE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := fake.GetMetaData(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filename := filepath.Join(t.TempDir(), "metadata.json")
+	if err := ioutil.WriteFile(filename, []byte(*tree), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_METADATA_JSON", *tree)
+
+	srv := httptest.NewServer(NewFakeServer(fake))
+	defer srv.Close()
+
+	sources := map[string]MetadataSource{
+		"fake":   fake,
+		"file":   NewFileSource(filename),
+		"env":    NewEnvSource("TEST_METADATA_JSON"),
+		"server": NewServerSource(strings.TrimPrefix(srv.URL, "http://")),
+	}
+
+	for name, src := range sources {
+		project, err := src.GetProjectID(context.Background())
+		if err != nil {
+			t.Errorf("%v: %v", name, err)
+			continue
+		}
+		if *project != "helloweb-fake" {
+			t.Errorf("%v: project = %v, want helloweb-fake", name, *project)
+		}
+
+		if _, err := src.GetMetaData(context.Background()); err != nil {
+			t.Errorf("%v: GetMetaData() error = %v", name, err)
+		}
+	}
+}
+
+func TestStaticSourceErrors(t *testing.T) {
+	sources := map[string]MetadataSource{
+		"missing file": NewFileSource(filepath.Join(t.TempDir(), "missing.json")),
+		"unset env":    NewEnvSource("TEST_METADATA_UNSET"),
+	}
+
+	for name, src := range sources {
+		if _, err := src.GetProjectID(context.Background()); err == nil {
+			t.Errorf("%v: GetProjectID() didn't fail", name)
+		}
+	}
+
+	t.Setenv("TEST_METADATA_JSON", `{"instance": {}}`)
+	if _, err := NewEnvSource("TEST_METADATA_JSON").GetProjectID(context.Background()); err == nil {
+		t.Errorf("tree without a project: GetProjectID() didn't fail")
+	}
+}
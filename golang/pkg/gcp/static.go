@@ -0,0 +1,86 @@
+package gcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// projectIDFromTree pulls project/projectId out of a recursive metadata tree
+func projectIDFromTree(metadataStr string) (*string, error) {
+	var metadata map[string]interface{}
+	if err := json.Unmarshal([]byte(metadataStr), &metadata); err != nil {
+		return nil, fmt.Errorf("cannot parse metadata: %v", err)
+	}
+
+	project, err := GetMetaDataStr("project/projectId", metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	return &project, nil
+}
+
+// FileSource serves a metadata tree from a JSON file on disk, e.g. one
+// captured from a real instance with
+// `curl -H Metadata-Flavor:Google metadata/computeMetadata/v1/?recursive=true`.
+// The file is re-read on every call so it can be edited while the app is running.
+type FileSource struct {
+	filename string
+}
+
+func NewFileSource(filename string) *FileSource {
+	return &FileSource{
+		filename: filename,
+	}
+}
+
+func (f *FileSource) GetMetaData(ctx context.Context) (*string, error) {
+	body, err := ioutil.ReadFile(f.filename)
+	if err != nil {
+		return nil, err
+	}
+
+	bodyStr := string(body)
+	return &bodyStr, nil
+}
+
+func (f *FileSource) GetProjectID(ctx context.Context) (*string, error) {
+	metadataStr, err := f.GetMetaData(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return projectIDFromTree(*metadataStr)
+}
+
+// EnvSource serves a metadata tree from the JSON in an environment variable
+type EnvSource struct {
+	envVar string
+}
+
+func NewEnvSource(envVar string) *EnvSource {
+	return &EnvSource{
+		envVar: envVar,
+	}
+}
+
+func (e *EnvSource) GetMetaData(ctx context.Context) (*string, error) {
+	bodyStr, found := os.LookupEnv(e.envVar)
+	if !found {
+		return nil, fmt.Errorf("%v is not set", e.envVar)
+	}
+
+	return &bodyStr, nil
+}
+
+func (e *EnvSource) GetProjectID(ctx context.Context) (*string, error) {
+	metadataStr, err := e.GetMetaData(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return projectIDFromTree(*metadataStr)
+}
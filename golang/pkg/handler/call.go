@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	attrs "helloworld-http/pkg/attrs"
+	chain "helloworld-http/pkg/chain"
+	logging "helloworld-http/pkg/logging"
+)
+
+// Call responds with this instance's payload and those of every instance
+// downstream of it, calling the URLs in ?url= or the configured upstreams.
+// It's a 502 if any of the downstream calls failed.
+func (h *Handler) Call(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	log := logging.FromContext(ctx)
+
+	urls := h.Caller.Upstreams
+	if fromQuery := r.URL.Query()["url"]; len(fromQuery) > 0 {
+		if !h.Caller.AllowURLs {
+			http.Error(w, "Calling URLs from the request isn't allowed, set CALL_ALLOW_URLS=true to allow it", http.StatusForbidden)
+			return
+		}
+		urls = fromQuery
+	}
+
+	mode, err := chain.ParseMode(r.URL.Query().Get("mode"), h.Caller.Mode)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	format, ok := negotiate(w, r, FORMAT_JSON, FORMAT_YAML, FORMAT_TEXT)
+	if !ok {
+		return
+	}
+
+	payload, err := attrs.GetAllAttrs(ctx, r, h.tracer)
+	if err != nil {
+		log.Sugar().Errorf("error getting attributes: %s", err)
+		http.Error(w, "Error getting attributes", http.StatusInternalServerError)
+		return
+	}
+
+	call := h.Caller.Call(ctx, payload, urls, mode, chain.DepthFromRequest(r))
+
+	w.Header().Set(HEADER_PAYLOAD_VERSION, attrs.PAYLOAD_VERSION)
+	if call.Failed() {
+		w.WriteHeader(http.StatusBadGateway)
+	}
+
+	switch format {
+	case FORMAT_YAML:
+		h.helloYAML(w, call)
+	case FORMAT_TEXT:
+		callText(w, call, "")
+	default:
+		h.helloJSON(w, call)
+	}
+}
+
+// callText draws the call tree, one instance per line with its hops below it
+func callText(w io.Writer, call chain.Call, indent string) {
+	fmt.Fprintf(w, "%s (%s, %s) depth %d\n", call.Payload.Guest.Hostname, call.Payload.Platform.Name, call.Payload.Zone, call.Depth)
+	if call.MaxDepthReached {
+		fmt.Fprintf(w, "%s  maximum depth reached, not calling further\n", indent)
+	}
+
+	for _, hop := range call.Calls {
+		status := fmt.Sprintf("%d", hop.StatusCode)
+		if hop.Error != "" {
+			status = "error: " + hop.Error
+		}
+		fmt.Fprintf(w, "%s  -> %s %s %.1fms\n", indent, hop.URL, status, hop.LatencyMs)
+
+		if hop.Response != nil {
+			fmt.Fprintf(w, "%s     ", indent)
+			callText(w, *hop.Response, indent+strings.Repeat(" ", 5))
+		}
+	}
+}
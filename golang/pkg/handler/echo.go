@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+
+	attrs "helloworld-http/pkg/attrs"
+	logging "helloworld-http/pkg/logging"
+)
+
+// Echo reflects the request back, for seeing what proxies and load balancers
+// have done to it on the way in
+func (h *Handler) Echo(w http.ResponseWriter, r *http.Request) {
+	received := time.Now()
+
+	log := logging.FromContext(r.Context())
+
+	echo, err := attrs.GetEchoAttrs(r, received, h.EchoMaxBodyBytes, h.EchoBodyLimit)
+	if errors.Is(err, attrs.ErrBodyTooLarge) {
+		http.Error(w, fmt.Sprintf("%v, the limit is %d bytes", err, h.EchoBodyLimit), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		log.Sugar().Warnf("error echoing request: %s", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	echo.Timing.Elapsed = time.Since(received).String()
+
+	format, ok := negotiate(w, r, FORMAT_TEXT, FORMAT_JSON, FORMAT_HTML)
+	if !ok {
+		return
+	}
+
+	switch format {
+	case FORMAT_JSON:
+		h.echoJSON(w, echo)
+	case FORMAT_HTML:
+		h.echoHTML(w, echo)
+	default:
+		h.echoText(w, echo)
+	}
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func (h *Handler) echoText(w http.ResponseWriter, echo attrs.EchoPayload) {
+	fmt.Fprintf(w, "%s %s %s\n", echo.Method, echo.URL, echo.Proto)
+	fmt.Fprintf(w, "Host: %s\n", echo.Host)
+	fmt.Fprintf(w, "Path: %s\n", echo.Path)
+
+	if len(echo.Query) > 0 {
+		fmt.Fprintf(w, "Query:\n")
+		for _, k := range sortedKeys(echo.Query) {
+			fmt.Fprintf(w, "  %s: %s\n", k, echo.Query[k])
+		}
+	}
+
+	fmt.Fprintf(w, "Headers:\n")
+	for _, k := range sortedKeys(echo.Headers) {
+		fmt.Fprintf(w, "  %s: %s\n", k, echo.Headers[k])
+	}
+
+	fmt.Fprintf(w, "Remote Addr: %s\n", echo.RemoteAddr)
+	fmt.Fprintf(w, "Local Addr: %s\n", echo.LocalAddr)
+
+	if echo.TLS != nil {
+		fmt.Fprintf(w, "TLS Version: %s\n", echo.TLS.Version)
+		fmt.Fprintf(w, "TLS Cipher Suite: %s\n", echo.TLS.CipherSuite)
+		fmt.Fprintf(w, "TLS Server Name: %s\n", echo.TLS.ServerName)
+		fmt.Fprintf(w, "TLS Negotiated Protocol: %s\n", echo.TLS.NegotiatedProtocol)
+		if echo.TLS.ClientCertSubject != "" {
+			fmt.Fprintf(w, "TLS Client Cert Subject: %s\n", echo.TLS.ClientCertSubject)
+			fmt.Fprintf(w, "TLS Client Cert Issuer: %s\n", echo.TLS.ClientCertIssuer)
+		}
+	}
+
+	fmt.Fprintf(w, "Content Length: %d\n", echo.ContentLength)
+	fmt.Fprintf(w, "Body Size: %d\n", echo.Body.Size)
+	if echo.Body.Content != "" {
+		fmt.Fprintf(w, "Body")
+		if echo.Body.Encoding != "" {
+			fmt.Fprintf(w, " (%s)", echo.Body.Encoding)
+		}
+		if echo.Body.Truncated {
+			fmt.Fprintf(w, " (truncated)")
+		}
+		fmt.Fprintf(w, ":\n%s\n", echo.Body.Content)
+	}
+
+	fmt.Fprintf(w, "Received: %s\n", echo.Timing.Received.Format(time.RFC3339Nano))
+	fmt.Fprintf(w, "Body Read: %s\n", echo.Timing.BodyRead)
+	fmt.Fprintf(w, "Elapsed: %s\n", echo.Timing.Elapsed)
+}
+
+func (h *Handler) echoJSON(w http.ResponseWriter, echo attrs.EchoPayload) {
+	jsonObj, err := json.Marshal(echo)
+	if err != nil {
+		zap.S().Errorf("error marshalling to json: %s", err)
+		http.Error(w, "Error marshalling to json", http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Fprintf(w, "%s", string(jsonObj))
+}
+
+func (h *Handler) echoHTML(w http.ResponseWriter, echo attrs.EchoPayload) {
+	h.templates.render(w, "echo.html", echo)
+}
@@ -0,0 +1,34 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEchoBodyLimit(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{name: "kept", body: "hello", status: http.StatusOK},
+		{name: "truncated", body: strings.Repeat("a", 100), status: http.StatusOK},
+		{name: "over the limit", body: strings.Repeat("a", 1001), status: http.StatusRequestEntityTooLarge},
+	}
+
+	h := &Handler{EchoMaxBodyBytes: 10, EchoBodyLimit: 1000}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/echo", strings.NewReader(tt.body))
+		r.Header.Set("Accept", "application/json")
+		// chunked, so the limit is only found by reading
+		r.ContentLength = -1
+		w := httptest.NewRecorder()
+
+		h.Echo(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%v: status = %v, want %v: %v", tt.name, w.Code, tt.status, w.Body)
+		}
+	}
+}
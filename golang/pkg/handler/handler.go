@@ -1,106 +1,235 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
-	"mime"
+	"errors"
+	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	attrs "helloworld-http/pkg/attrs"
+	chain "helloworld-http/pkg/chain"
+	load "helloworld-http/pkg/load"
+	logging "helloworld-http/pkg/logging"
 	trace "helloworld-http/pkg/trace"
-	"helloworld-http/pkg/util"
 
+	chi "github.com/go-chi/chi/v5"
 	"go.uber.org/zap"
 )
 
 type Handler struct {
 	logger zap.Logger
 	tracer *trace.TraceConfig
+
+	// how much of the request body /echo reflects back, and how much it will
+	// read at all before giving up with a 413
+	EchoMaxBodyBytes int64
+	EchoBodyLimit    int64
+
+	// where /call fans out to
+	Caller *chain.Caller
+
+	templates *Templates
 }
 
 func InitHandler(logger zap.Logger, tracer *trace.TraceConfig) (*Handler, error) {
+	templates, err := NewTemplates("")
+	if err != nil {
+		return nil, err
+	}
+
 	return &Handler{
-		logger: logger,
-		tracer: tracer,
+		logger:           logger,
+		tracer:           tracer,
+		EchoMaxBodyBytes: 64 << 10,
+		EchoBodyLimit:    10 << 20,
+		Caller:           chain.NewCaller(),
+		templates:        templates,
 	}, nil
 }
 
+// LoadTemplates replaces the HTML templates with any found in dir, and keeps
+// reloading them when they change until the context is done
+func (h *Handler) LoadTemplates(ctx context.Context, dir string, interval time.Duration) error {
+	templates, err := NewTemplates(dir)
+	if err != nil {
+		return err
+	}
+
+	templates.Watch(ctx, interval)
+	h.templates = templates
+
+	return nil
+}
+
+// BusyLoopReq keeps Cores cores (default 1) Percent busy (default 100) for
+// Duration seconds. Async jobs run in the background and return an ID.
 type BusyLoopReq struct {
-	Duration int `json:"duration"`
+	Duration int     `json:"duration"`
+	Cores    int     `json:"cores"`
+	Percent  float64 `json:"percent"`
+	Async    bool    `json:"async"`
 }
 
 func (h *Handler) BusyLoop(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	zap.L().Info("Serving request", 
-		zap.String("method", r.Method), 
-		zap.String("path", r.URL.Path))
-	zap.L().Debug("Request Headers", 
+	log := logging.FromContext(ctx)
+	log.Debug("Request Headers", 
 		zap.Any("headers", r.Header))
 
 	 // Try to decode the request body into the struct. If there is an error,
     // respond to the client with the error message and a 400 status code.
-	var p BusyLoopReq
+	p := BusyLoopReq{
+		Cores:   1,
+		Percent: 100,
+	}
     err := json.NewDecoder(r.Body).Decode(&p)
     if err != nil {
         http.Error(w, err.Error(), http.StatusBadRequest)
         return
     }
 
-	if err != nil {
-		zap.L().Warn("error reading request body: %s", 
-		zap.Error(err))
+	log.Debug("Request Body", 
+		zap.Any("body", p))
+
+	duration := time.Duration(p.Duration) * time.Second
+
+	if p.Async || r.URL.Query().Get("async") == "true" {
+		job, err := load.DefaultCPULoads.Start(ctx, p.Cores, p.Percent, duration)
+		if err != nil {
+			http.Error(w, err.Error(), cpuLoadStatus(err))
+			return
+		}
+
+		w.Header().Set("Location", fmt.Sprintf("%v/%v", strings.TrimSuffix(r.URL.Path, "/"), job.ID))
+		writeJSON(w, http.StatusAccepted, job)
+		return
 	}
 
-	zap.L().Debug("Request Body", 
-		zap.Any("body", p))
+	// stops early if the client goes away
+	err = load.DefaultCPULoads.Run(ctx, p.Cores, p.Percent, duration)
+	if err != nil && ctx.Err() == nil {
+		http.Error(w, err.Error(), cpuLoadStatus(err))
+	}
+}
+
+// cpuLoadStatus is 429 for a load that could run once others finish, 400
+// for one that never could
+func cpuLoadStatus(err error) int {
+	if errors.Is(err, load.ErrTooBusy) {
+		return http.StatusTooManyRequests
+	}
+
+	return http.StatusBadRequest
+}
 
-	util.BusyLoop(ctx, p.Duration)
+func (h *Handler) BusyLoopList(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, load.DefaultCPULoads.List())
+}
 
+func (h *Handler) BusyLoopGet(w http.ResponseWriter, r *http.Request) {
+	job, found := load.DefaultCPULoads.Get(chi.URLParam(r, "id"))
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, job)
+}
+
+// BusyLoopCancel stops a background job before its duration is up
+func (h *Handler) BusyLoopCancel(w http.ResponseWriter, r *http.Request) {
+	if !load.DefaultCPULoads.Cancel(chi.URLParam(r, "id")) {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
 }
 
 // hello responds to the request with a plain-text "Hello, world" message.
 func (h *Handler) Hello(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
-	//zap.S().Infof("Serving request: %v %s", r.Method, r.URL.Path)
-	zap.L().Info("Serving request", 
-		zap.String("method", r.Method), 
-		zap.String("path", r.URL.Path))
-	zap.L().Debug("Request Headers", 
+	log := logging.FromContext(ctx)
+	log.Debug("Request Headers", 
 		zap.Any("headers", r.Header))
 
-	attrs, err := attrs.GetAllAttrs(ctx, r, h.tracer)
+	payload, err := attrs.GetAllAttrs(ctx, r, h.tracer)
+	if err != nil {
+		log.Sugar().Errorf("error getting attributes: %s", err)
+		http.Error(w, "Error getting attributes", http.StatusInternalServerError)
+		return
+	}
+
+	// older payload shapes for clients that ask for them, only for the
+	// machine-readable formats
+	version := r.URL.Query().Get("version")
+	if version == "" {
+		version = attrs.PAYLOAD_VERSION
+	}
+	shaped, err := attrs.ForVersion(payload, version)
 	if err != nil {
-		zap.S().Fatalf("error marshalling to html: %s", err)
-		http.Error(w, "Error marshalling to html: %s", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	contentType := r.Header.Get("accept")
-	for _, v := range strings.Split(contentType, ",") {
-		if v != "" {
-			t, _, err := mime.ParseMediaType(v)
-			if err != nil {
-				zap.S().Warnf("error parsing accept header: %s", v)
-				continue
-			}
+	format, ok := negotiate(w, r, helloFormats...)
+	if !ok {
+		return
+	}
 
-			if t == "application/json" {
-				h.helloJSON(w, attrs)
-				return
-			}
+	switch format {
+	case FORMAT_TEXT, FORMAT_HTML:
+		w.Header().Set(HEADER_PAYLOAD_VERSION, attrs.PAYLOAD_VERSION)
+	default:
+		w.Header().Set(HEADER_PAYLOAD_VERSION, version)
+	}
 
-			if t == "text/html" {
-				h.helloHTML(w, attrs)
-				return
-			}
+	switch format {
+	case FORMAT_JSON:
+		h.helloJSON(w, shaped)
+	case FORMAT_HTML:
+		h.helloHTML(w, payload)
+	case FORMAT_YAML:
+		h.helloYAML(w, shaped)
+	case FORMAT_PROMETHEUS:
+		h.helloPrometheus(w, shaped)
+	case FORMAT_NDJSON:
+		h.helloNDJSON(w, shaped)
+	default:
+		h.helloText(w, payload)
+	}
 
-		
-			//zap.S().Printf("mimetype: %s", t)
+}
 
-		}
+// HEADER_PAYLOAD_VERSION tells clients which payload shape they got
+const HEADER_PAYLOAD_VERSION = "X-Payload-Version"
+
+// Schema serves the JSON Schema of the payload, for ?version= or the
+// current version
+func (h *Handler) Schema(w http.ResponseWriter, r *http.Request) {
+	schema, err := attrs.PayloadSchema(r.URL.Query().Get("version"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
 	}
 
-	h.helloText(w, attrs)
+	w.Header().Set("Content-Type", "application/schema+json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(schema)
+}
 
-}
\ No newline at end of file
+// helloFormats are what Hello can respond with, in order of preference when
+// the client doesn't mind
+var helloFormats = []string{
+	FORMAT_TEXT,
+	FORMAT_JSON,
+	FORMAT_HTML,
+	FORMAT_YAML,
+	FORMAT_PROMETHEUS,
+	FORMAT_NDJSON,
+}
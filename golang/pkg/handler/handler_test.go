@@ -0,0 +1,44 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	load "helloworld-http/pkg/load"
+)
+
+func TestBusyLoopStatus(t *testing.T) {
+	defer func(max int) { load.MaxCores = max }(load.MaxCores)
+	load.MaxCores = 1
+	defer func() {
+		for _, job := range load.DefaultCPULoads.List() {
+			load.DefaultCPULoads.Cancel(job.ID)
+		}
+	}()
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{name: "started", body: `{"duration": 60, "percent": 60, "async": true}`, status: http.StatusAccepted},
+		{name: "too busy", body: `{"duration": 60, "percent": 60, "async": true}`, status: http.StatusTooManyRequests},
+		{name: "too busy to run now", body: `{"duration": 1, "percent": 60}`, status: http.StatusTooManyRequests},
+		{name: "fits next to it", body: `{"duration": 60, "percent": 40, "async": true}`, status: http.StatusAccepted},
+		{name: "more cores than allowed", body: `{"duration": 60, "cores": 2, "async": true}`, status: http.StatusBadRequest},
+		{name: "no duration", body: `{"async": true}`, status: http.StatusBadRequest},
+	}
+
+	h := &Handler{}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/busyloop", strings.NewReader(tt.body))
+		w := httptest.NewRecorder()
+
+		h.BusyLoop(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%v: status = %v, want %v: %v", tt.name, w.Code, tt.status, w.Body)
+		}
+	}
+}
@@ -6,19 +6,30 @@ import (
 	"net/http"
 
 	"go.uber.org/zap"
-
-	attrs "helloworld-http/pkg/attrs"
 )
 
 // helloJSON responds with json response
-func (h *Handler) helloJSON(w http.ResponseWriter, attrs attrs.Payload) {
-	jsonObj, err := json.Marshal(attrs)
+func (h *Handler) helloJSON(w http.ResponseWriter, payload interface{}) {
+	jsonObj, err := json.Marshal(payload)
 
 	if err != nil {
-		zap.S().Fatalf("error marshalling to json: %s", err)
-		http.Error(w, "Error marshalling to json: %s", http.StatusInternalServerError)
+		zap.S().Errorf("error marshalling to json: %s", err)
+		http.Error(w, "Error marshalling to json", http.StatusInternalServerError)
 		return
 	}
 
 	fmt.Fprintf(w, "%s", string(jsonObj))
-}
\ No newline at end of file
+}
+
+// helloNDJSON responds with the payload as a single line of JSON, so
+// responses can be appended to a file and read back as newline-delimited JSON
+func (h *Handler) helloNDJSON(w http.ResponseWriter, payload interface{}) {
+	jsonObj, err := json.Marshal(payload)
+	if err != nil {
+		zap.S().Errorf("error marshalling to json: %s", err)
+		http.Error(w, "Error marshalling to json", http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Fprintf(w, "%s\n", string(jsonObj))
+}
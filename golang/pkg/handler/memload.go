@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	chi "github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+
+	load "helloworld-http/pkg/load"
+	logging "helloworld-http/pkg/logging"
+)
+
+// MemLoadReq asks for Megabytes of memory to be held for Duration seconds,
+// allocated gradually over Ramp seconds if set
+type MemLoadReq struct {
+	Megabytes int64 `json:"megabytes"`
+	Duration  int   `json:"duration"`
+	Ramp      int   `json:"ramp"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// MemLoad starts holding memory in the background and returns straight away
+// with an ID that can be used to release it early
+func (h *Handler) MemLoad(w http.ResponseWriter, r *http.Request) {
+	log := logging.FromContext(r.Context())
+
+	var p MemLoadReq
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	log.Debug("Request Body",
+		zap.Any("body", p))
+
+	// checked before shifting, a big enough size would overflow into a small one
+	if max := load.DefaultMemoryLoads.MaxMegabytes(); p.Megabytes < 1 || p.Megabytes > max {
+		http.Error(w, fmt.Sprintf("megabytes must be between 1 and %d, got %d", max, p.Megabytes), http.StatusBadRequest)
+		return
+	}
+
+	m, err := load.DefaultMemoryLoads.Start(
+		p.Megabytes<<20,
+		time.Duration(p.Duration)*time.Second,
+		time.Duration(p.Ramp)*time.Second)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("%v/%v", strings.TrimSuffix(r.URL.Path, "/"), m.ID))
+	writeJSON(w, http.StatusAccepted, m)
+}
+
+func (h *Handler) MemLoadList(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, load.DefaultMemoryLoads.List())
+}
+
+func (h *Handler) MemLoadGet(w http.ResponseWriter, r *http.Request) {
+	m, found := load.DefaultMemoryLoads.Get(chi.URLParam(r, "id"))
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, m)
+}
+
+// MemLoadRelease frees one allocation before its duration is up
+func (h *Handler) MemLoadRelease(w http.ResponseWriter, r *http.Request) {
+	if !load.DefaultMemoryLoads.Release(chi.URLParam(r, "id")) {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// MemLoadReleaseAll frees every outstanding allocation
+func (h *Handler) MemLoadReleaseAll(w http.ResponseWriter, r *http.Request) {
+	load.DefaultMemoryLoads.ReleaseAll()
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	load "helloworld-http/pkg/load"
+)
+
+func TestMemLoadSize(t *testing.T) {
+	defer func(max int64) { load.DefaultMemoryLoads.MaxBytes = max }(load.DefaultMemoryLoads.MaxBytes)
+	load.DefaultMemoryLoads.MaxBytes = 2 << 20
+	defer load.DefaultMemoryLoads.ReleaseAll()
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{name: "at the limit", body: `{"megabytes": 2, "duration": 60}`, status: http.StatusAccepted},
+		{name: "over the limit", body: `{"megabytes": 3, "duration": 60}`, status: http.StatusBadRequest},
+		// shifted into bytes this wraps around to a size under the limit
+		{name: "overflowing", body: `{"megabytes": 8796093022207, "duration": 60}`, status: http.StatusBadRequest},
+		{name: "nothing", body: `{"megabytes": 0, "duration": 60}`, status: http.StatusBadRequest},
+		{name: "negative", body: `{"megabytes": -1, "duration": 60}`, status: http.StatusBadRequest},
+		{name: "not JSON", body: `megabytes=1`, status: http.StatusBadRequest},
+	}
+
+	h := &Handler{}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/memload", strings.NewReader(tt.body))
+		w := httptest.NewRecorder()
+
+		h.MemLoad(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%v: status = %v, want %v: %v", tt.name, w.Code, tt.status, w.Body)
+		}
+
+		load.DefaultMemoryLoads.ReleaseAll()
+	}
+}
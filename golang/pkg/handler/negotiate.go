@@ -0,0 +1,244 @@
+package handler
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// Response formats a handler can offer, also usable as ?format=
+const (
+	FORMAT_TEXT       = "text"
+	FORMAT_JSON       = "json"
+	FORMAT_HTML       = "html"
+	FORMAT_YAML       = "yaml"
+	FORMAT_PROMETHEUS = "prometheus"
+	FORMAT_NDJSON     = "ndjson"
+)
+
+type mediaType struct {
+	typ     string
+	subtype string
+	params  map[string]string
+}
+
+type format struct {
+	// the media types that select this format, the first is the canonical one
+	mediaTypes []mediaType
+
+	// what we send back as the Content-Type
+	contentType string
+}
+
+func mustParseMediaType(s string) mediaType {
+	mt, ok := parseMediaType(s)
+	if !ok {
+		panic(fmt.Sprintf("invalid media type %q", s))
+	}
+
+	return mt
+}
+
+var formats = map[string]format{
+	FORMAT_TEXT: {
+		mediaTypes:  []mediaType{mustParseMediaType("text/plain")},
+		contentType: "text/plain; charset=utf-8",
+	},
+	FORMAT_JSON: {
+		mediaTypes:  []mediaType{mustParseMediaType("application/json")},
+		contentType: "application/json",
+	},
+	FORMAT_HTML: {
+		mediaTypes:  []mediaType{mustParseMediaType("text/html")},
+		contentType: "text/html; charset=utf-8",
+	},
+	FORMAT_YAML: {
+		mediaTypes: []mediaType{
+			mustParseMediaType("application/yaml"),
+			mustParseMediaType("application/x-yaml"),
+			mustParseMediaType("text/yaml"),
+			mustParseMediaType("text/x-yaml"),
+		},
+		contentType: "application/yaml",
+	},
+	FORMAT_PROMETHEUS: {
+		// the Prometheus text exposition format is text/plain with a version
+		mediaTypes:  []mediaType{mustParseMediaType("text/plain; version=0.0.4")},
+		contentType: "text/plain; version=0.0.4; charset=utf-8",
+	},
+	FORMAT_NDJSON: {
+		mediaTypes: []mediaType{
+			mustParseMediaType("application/x-ndjson"),
+			mustParseMediaType("application/ndjson"),
+		},
+		contentType: "application/x-ndjson",
+	},
+}
+
+// parseMediaType splits a media type or media range into its parts. charset
+// is dropped since we always send UTF-8.
+func parseMediaType(s string) (mediaType, bool) {
+	full, params, err := mime.ParseMediaType(s)
+	if err != nil {
+		return mediaType{}, false
+	}
+
+	parts := strings.SplitN(full, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return mediaType{}, false
+	}
+
+	delete(params, "charset")
+
+	return mediaType{
+		typ:     parts[0],
+		subtype: parts[1],
+		params:  params,
+	}, true
+}
+
+// acceptRange is one media range from an Accept header, with its quality
+type acceptRange struct {
+	mediaType
+	q float64
+}
+
+// specificity orders ranges that match the same type, so that text/plain;
+// version=0.0.4 beats text/plain beats text/* beats */*
+func (a acceptRange) specificity() int {
+	switch {
+	case a.typ == "*":
+		return 0
+	case a.subtype == "*":
+		return 1
+	default:
+		return 2 + len(a.params)
+	}
+}
+
+func (a acceptRange) matches(mt mediaType) bool {
+	if a.typ != "*" && a.typ != mt.typ {
+		return false
+	}
+	if a.subtype != "*" && a.subtype != mt.subtype {
+		return false
+	}
+
+	for k, v := range a.params {
+		if !strings.EqualFold(mt.params[k], v) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// parseAccept reads the media ranges in an Accept header, skipping any we
+// can't make sense of. A missing header accepts anything.
+func parseAccept(header string) []acceptRange {
+	if strings.TrimSpace(header) == "" {
+		return []acceptRange{{mediaType: mediaType{typ: "*", subtype: "*"}, q: 1}}
+	}
+
+	var ranges []acceptRange
+	for _, v := range strings.Split(header, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+
+		mt, ok := parseMediaType(v)
+		if !ok {
+			zap.S().Debugf("error parsing accept header: %s", v)
+			continue
+		}
+
+		q := 1.0
+		if qStr, found := mt.params["q"]; found {
+			delete(mt.params, "q")
+
+			var err error
+			q, err = strconv.ParseFloat(qStr, 64)
+			if err != nil || q < 0 || q > 1 {
+				zap.S().Debugf("error parsing accept header quality: %s", v)
+				continue
+			}
+		}
+
+		ranges = append(ranges, acceptRange{mediaType: mt, q: q})
+	}
+
+	return ranges
+}
+
+// quality is how much the client wants mt, from the most specific range
+// that matches it (RFC 7231 section 5.3.2)
+func quality(ranges []acceptRange, mt mediaType) float64 {
+	q := 0.0
+	best := -1
+	for _, a := range ranges {
+		if a.matches(mt) && a.specificity() > best {
+			best = a.specificity()
+			q = a.q
+		}
+	}
+
+	return q
+}
+
+// selectFormat picks the offer the client rates highest, preferring earlier
+// offers on a tie. It returns false if the client accepts none of them.
+func selectFormat(accept string, offers []string) (string, bool) {
+	ranges := parseAccept(accept)
+
+	selected := ""
+	selectedQ := 0.0
+	for _, offer := range offers {
+		for _, mt := range formats[offer].mediaTypes {
+			if q := quality(ranges, mt); q > selectedQ {
+				selected = offer
+				selectedQ = q
+			}
+		}
+	}
+
+	return selected, selected != ""
+}
+
+// negotiate picks which of the offered formats to respond with, from
+// ?format= or the Accept header, and sets the Content-Type to match. If
+// nothing offered is acceptable it responds with a 406 and returns false.
+func negotiate(w http.ResponseWriter, r *http.Request, offers ...string) (string, bool) {
+	w.Header().Add("Vary", "Accept")
+
+	selected, ok := "", false
+	if f := r.URL.Query().Get("format"); f != "" {
+		for _, offer := range offers {
+			if f == offer {
+				selected, ok = offer, true
+			}
+		}
+	} else {
+		selected, ok = selectFormat(r.Header.Get("Accept"), offers)
+	}
+
+	if !ok {
+		available := make([]string, 0, len(offers))
+		for _, offer := range offers {
+			mt := formats[offer].mediaTypes[0]
+			available = append(available, fmt.Sprintf("%v (?format=%v)", mime.FormatMediaType(mt.typ+"/"+mt.subtype, mt.params), offer))
+		}
+		sort.Strings(available)
+
+		http.Error(w, fmt.Sprintf("Not Acceptable, available formats:\n%v", strings.Join(available, "\n")), http.StatusNotAcceptable)
+		return "", false
+	}
+
+	w.Header().Set("Content-Type", formats[selected].contentType)
+	return selected, true
+}
@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSelectFormat(t *testing.T) {
+	all := []string{FORMAT_TEXT, FORMAT_JSON, FORMAT_HTML, FORMAT_YAML, FORMAT_PROMETHEUS, FORMAT_NDJSON}
+
+	tests := []struct {
+		name   string
+		accept string
+		offers []string
+		want   string
+	}{
+		{name: "no header", accept: "", offers: all, want: FORMAT_TEXT},
+		{name: "anything", accept: "*/*", offers: all, want: FORMAT_TEXT},
+		{name: "exact", accept: "application/json", offers: all, want: FORMAT_JSON},
+		{name: "charset ignored", accept: "application/json; charset=utf-8", offers: all, want: FORMAT_JSON},
+		{name: "case insensitive", accept: "Application/JSON", offers: all, want: FORMAT_JSON},
+		{name: "alias", accept: "text/x-yaml", offers: all, want: FORMAT_YAML},
+		{name: "subtype wildcard", accept: "application/*", offers: []string{FORMAT_TEXT, FORMAT_YAML}, want: FORMAT_YAML},
+		{name: "highest quality", accept: "text/html;q=0.5, application/json;q=0.9", offers: all, want: FORMAT_JSON},
+		{name: "tie goes to the first offer", accept: "text/html, application/json", offers: all, want: FORMAT_JSON},
+		{name: "browser", accept: "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", offers: all, want: FORMAT_HTML},
+		{name: "specific range beats wildcard", accept: "*/*;q=0.1, text/plain;q=0.8", offers: []string{FORMAT_JSON, FORMAT_TEXT}, want: FORMAT_TEXT},
+		{name: "q=0 refuses", accept: "application/json;q=0, */*", offers: []string{FORMAT_JSON, FORMAT_YAML}, want: FORMAT_YAML},
+		{name: "prometheus by version", accept: "text/plain;version=0.0.4", offers: all, want: FORMAT_PROMETHEUS},
+		{name: "plain text over prometheus", accept: "text/plain", offers: all, want: FORMAT_TEXT},
+		{name: "unparseable range skipped", accept: "garbage, application/x-ndjson", offers: all, want: FORMAT_NDJSON},
+		{name: "invalid quality skipped", accept: "text/html;q=2, application/json", offers: all, want: FORMAT_JSON},
+		{name: "nothing acceptable", accept: "image/png", offers: all, want: ""},
+		{name: "all refused", accept: "*/*;q=0", offers: all, want: ""},
+	}
+
+	for _, tt := range tests {
+		got, ok := selectFormat(tt.accept, tt.offers)
+		if got != tt.want || ok != (tt.want != "") {
+			t.Errorf("%v: selectFormat(%q) = %q, %v, want %q", tt.name, tt.accept, got, ok, tt.want)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name        string
+		url         string
+		accept      string
+		want        string
+		status      int
+		contentType string
+	}{
+		{
+			name:        "accept header",
+			url:         "/",
+			accept:      "application/json",
+			want:        FORMAT_JSON,
+			status:      http.StatusOK,
+			contentType: "application/json",
+		},
+		{
+			name:        "format param wins over accept",
+			url:         "/?format=yaml",
+			accept:      "application/json",
+			want:        FORMAT_YAML,
+			status:      http.StatusOK,
+			contentType: "application/yaml",
+		},
+		{
+			name:   "format param not offered",
+			url:    "/?format=html",
+			status: http.StatusNotAcceptable,
+		},
+		{
+			name:   "accept nothing offered",
+			url:    "/",
+			accept: "image/png",
+			status: http.StatusNotAcceptable,
+		},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		w := httptest.NewRecorder()
+
+		got, ok := negotiate(w, r, FORMAT_JSON, FORMAT_YAML)
+		if got != tt.want || ok != (tt.status == http.StatusOK) {
+			t.Errorf("%v: negotiate = %q, %v, want %q", tt.name, got, ok, tt.want)
+		}
+		if w.Code != tt.status {
+			t.Errorf("%v: status = %v, want %v", tt.name, w.Code, tt.status)
+		}
+		if tt.contentType != "" && w.Header().Get("Content-Type") != tt.contentType {
+			t.Errorf("%v: Content-Type = %q, want %q", tt.name, w.Header().Get("Content-Type"), tt.contentType)
+		}
+		if w.Header().Get("Vary") != "Accept" {
+			t.Errorf("%v: Vary = %q, want Accept", tt.name, w.Header().Get("Vary"))
+		}
+	}
+}
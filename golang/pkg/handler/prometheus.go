@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// Prometheus text rendering turns the payload into info-style gauges:
+//
+//	helloweb_info{project="my-project",version="1.1.0",zone="us-central1-a"} 1
+//	helloweb_gce_info{machine_type="e2-small",preemptible="false",...} 1
+//	helloweb_request_request_headers{key="Accept",value="*/*"} 1
+//
+// Scalars at the top level go on helloweb_info, scalars in a section go on
+// helloweb_<section>_info and maps or lists get a series per entry.
+
+const promPrefix = "helloweb"
+
+var (
+	promCamel   = regexp.MustCompile(`([a-z0-9])([A-Z])`)
+	promInvalid = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+)
+
+// promName turns a JSON field name like lbAddr into lb_addr
+func promName(s string) string {
+	s = promCamel.ReplaceAllString(s, "${1}_${2}")
+	return strings.ToLower(promInvalid.ReplaceAllString(s, "_"))
+}
+
+var promEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func promLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, k, promEscaper.Replace(labels[k])))
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func promScalar(v interface{}) (string, bool) {
+	switch val := v.(type) {
+	case nil:
+		return "", true
+	case string:
+		return val, true
+	case bool, float64:
+		return fmt.Sprint(val), true
+	default:
+		return "", false
+	}
+}
+
+type promWriter struct {
+	w    http.ResponseWriter
+	seen map[string]bool
+}
+
+func (p *promWriter) series(name string, help string, labels map[string]string) {
+	if !p.seen[name] {
+		p.seen[name] = true
+		fmt.Fprintf(p.w, "# HELP %s %s\n", name, help)
+		fmt.Fprintf(p.w, "# TYPE %s gauge\n", name)
+	}
+
+	fmt.Fprintf(p.w, "%s%s 1\n", name, promLabels(labels))
+}
+
+// entries writes one series per entry of a map or list, flattening lists of
+// values so headers come out as one series per value
+func (p *promWriter) entries(name string, help string, v interface{}) {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			switch entry := val[k].(type) {
+			case []interface{}:
+				for _, item := range entry {
+					if s, ok := promScalar(item); ok {
+						p.series(name, help, map[string]string{"key": k, "value": s})
+					}
+				}
+			default:
+				if s, ok := promScalar(entry); ok {
+					p.series(name, help, map[string]string{"key": k, "value": s})
+				}
+			}
+		}
+	case []interface{}:
+		for _, item := range val {
+			if s, ok := promScalar(item); ok {
+				p.series(name, help, map[string]string{"value": s})
+			}
+		}
+	}
+}
+
+// section writes the scalars in obj as one info series, then the nested
+// maps and lists as series of their own
+func (p *promWriter) section(name string, help string, obj map[string]interface{}) {
+	labels := make(map[string]string)
+	var nested []string
+	for k, v := range obj {
+		if s, ok := promScalar(v); ok {
+			labels[promName(k)] = s
+		} else {
+			nested = append(nested, k)
+		}
+	}
+	sort.Strings(nested)
+
+	if len(labels) > 0 {
+		p.series(name+"_info", help, labels)
+	}
+
+	for _, k := range nested {
+		p.entries(name+"_"+promName(k), fmt.Sprintf("%s, %s", help, k), obj[k])
+	}
+}
+
+func (h *Handler) helloPrometheus(w http.ResponseWriter, payload interface{}) {
+	generic, err := toGeneric(payload)
+	if err != nil {
+		zap.S().Errorf("error marshalling to prometheus text: %s", err)
+		http.Error(w, "Error marshalling to prometheus text", http.StatusInternalServerError)
+		return
+	}
+
+	obj, _ := generic.(map[string]interface{})
+
+	p := &promWriter{w: w, seen: make(map[string]bool)}
+
+	top := make(map[string]interface{})
+	var sections []string
+	for k, v := range obj {
+		if _, ok := v.(map[string]interface{}); ok {
+			sections = append(sections, k)
+		} else {
+			top[k] = v
+		}
+	}
+	sort.Strings(sections)
+
+	p.section(promPrefix, "Attributes of the instance and request, from the hello payload", top)
+	for _, k := range sections {
+		p.section(promPrefix+"_"+promName(k), fmt.Sprintf("The %s attributes from the hello payload", k), obj[k].(map[string]interface{}))
+	}
+}
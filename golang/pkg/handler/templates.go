@@ -0,0 +1,193 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// The HTML pages are built from the templates under templates/, each named
+// by its file name. hello.html pulls in style.html, header.html and one
+// partial per platform from templates/platforms/, so any of those can be
+// replaced on their own by a file with the same name in the override
+// directory.
+//
+//go:embed templates
+var embeddedTemplates embed.FS
+
+var templateFuncs = template.FuncMap{
+	// The name "inc" is what the function will be called in the template text.
+	"inc": func(i int) int {
+		return i + 1
+	},
+	"toRGB": func(s string) string {
+		return stringToRGB(s)
+	},
+}
+
+// templateFiles lists the .html files under dir, along with a fingerprint
+// of their names, sizes and modification times to notice when they change
+func templateFiles(dir string) ([]string, string, error) {
+	var files []string
+	var fingerprint strings.Builder
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || filepath.Ext(path) != ".html" {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		files = append(files, path)
+		fmt.Fprintf(&fingerprint, "%v:%v:%v\n", path, info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	sort.Strings(files)
+	return files, fingerprint.String(), nil
+}
+
+// parseTemplates compiles the embedded templates, then any in dir on top
+func parseTemplates(dir string) (*template.Template, string, error) {
+	t, err := template.New("").Funcs(templateFuncs).ParseFS(embeddedTemplates,
+		"templates/*.html",
+		"templates/platforms/*.html")
+	if err != nil {
+		return nil, "", err
+	}
+
+	if dir == "" {
+		return t, "", nil
+	}
+
+	files, fingerprint, err := templateFiles(dir)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(files) > 0 {
+		t, err = t.ParseFiles(files...)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	return t, fingerprint, nil
+}
+
+// Templates holds the compiled HTML templates, which can be swapped out at
+// runtime when the override directory changes
+type Templates struct {
+	mu          sync.RWMutex
+	set         *template.Template
+	dir         string
+	fingerprint string
+}
+
+// NewTemplates compiles the embedded templates, overridden by any in dir
+func NewTemplates(dir string) (*Templates, error) {
+	set, fingerprint, err := parseTemplates(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Templates{
+		set:         set,
+		dir:         dir,
+		fingerprint: fingerprint,
+	}, nil
+}
+
+// reload recompiles the templates if anything in the override directory has
+// changed. A broken template is logged and the old ones kept.
+func (t *Templates) reload() {
+	_, fingerprint, err := templateFiles(t.dir)
+	if err != nil {
+		zap.S().Warnf("error reading template directory %v: %v", t.dir, err)
+		return
+	}
+
+	t.mu.RLock()
+	unchanged := fingerprint == t.fingerprint
+	t.mu.RUnlock()
+	if unchanged {
+		return
+	}
+
+	set, _, err := parseTemplates(t.dir)
+	if err != nil {
+		zap.S().Errorf("error reloading templates, keeping the old ones: %v", err)
+
+		// don't try again until the files change
+		t.mu.Lock()
+		t.fingerprint = fingerprint
+		t.mu.Unlock()
+		return
+	}
+
+	t.mu.Lock()
+	t.set = set
+	t.fingerprint = fingerprint
+	t.mu.Unlock()
+
+	zap.S().Infof("Reloaded templates from %v", t.dir)
+}
+
+// Watch checks the override directory for changes every interval until the
+// context is done. An interval of 0 or less turns reloading off.
+func (t *Templates) Watch(ctx context.Context, interval time.Duration) {
+	if t.dir == "" || interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				t.reload()
+			}
+		}
+	}()
+}
+
+// render executes a template into a buffer first, so a template error turns
+// into a 500 instead of half a page
+func (t *Templates) render(w http.ResponseWriter, name string, data interface{}) {
+	t.mu.RLock()
+	set := t.set
+	t.mu.RUnlock()
+
+	var buf bytes.Buffer
+	if err := set.ExecuteTemplate(&buf, name, data); err != nil {
+		zap.S().Errorf("error rendering %v: %s", name, err)
+		http.Error(w, "Error rendering html", http.StatusInternalServerError)
+		return
+	}
+
+	buf.WriteTo(w)
+}
@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func renderString(t *testing.T, tmpl *Templates, name string) (int, string) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	tmpl.render(w, name, nil)
+
+	return w.Code, w.Body.String()
+}
+
+// writeTemplate writes a template with a modification time of its own, so
+// a rewrite is noticed even on filesystems with coarse timestamps
+func writeTemplate(t *testing.T, path string, body string, mtime time.Time) {
+	t.Helper()
+
+	if err := ioutil.WriteFile(path, []byte(body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTemplatesEmbedded(t *testing.T) {
+	tmpl, err := NewTemplates("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"hello.html", "echo.html", "header.html", "style.html", "run.html"} {
+		if tmpl.set.Lookup(name) == nil {
+			t.Errorf("%v wasn't embedded", name)
+		}
+	}
+}
+
+func TestTemplatesOverrideReload(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Now().Add(-time.Hour)
+	writeTemplate(t, filepath.Join(dir, "header.html"), "first", start)
+
+	tmpl, err := NewTemplates(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, got := renderString(t, tmpl, "header.html"); got != "first" {
+		t.Fatalf("override = %q, want first", got)
+	}
+
+	// nothing changed, nothing reloaded
+	set := tmpl.set
+	tmpl.reload()
+	if tmpl.set != set {
+		t.Errorf("templates reloaded without a change")
+	}
+
+	writeTemplate(t, filepath.Join(dir, "header.html"), "second", start.Add(time.Minute))
+	tmpl.reload()
+	if _, got := renderString(t, tmpl, "header.html"); got != "second" {
+		t.Errorf("after a change, override = %q, want second", got)
+	}
+
+	// a broken template keeps the last good ones serving
+	writeTemplate(t, filepath.Join(dir, "header.html"), "{{ .Broken", start.Add(2*time.Minute))
+	tmpl.reload()
+	if code, got := renderString(t, tmpl, "header.html"); code != http.StatusOK || got != "second" {
+		t.Errorf("after a broken change, override = %v %q, want second", code, got)
+	}
+
+	// the rest still come from the embedded set
+	if tmpl.set.Lookup("echo.html") == nil {
+		t.Errorf("echo.html went missing with an override in place")
+	}
+}
+
+func TestTemplatesWatch(t *testing.T) {
+	dir := t.TempDir()
+	writeTemplate(t, filepath.Join(dir, "header.html"), "first", time.Now().Add(-time.Hour))
+
+	tmpl, err := NewTemplates(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tmpl.Watch(ctx, 10*time.Millisecond)
+
+	writeTemplate(t, filepath.Join(dir, "header.html"), "watched", time.Now())
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, got := renderString(t, tmpl, "header.html")
+		if got == "watched" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("override = %q, never reloaded", got)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTemplatesBrokenOverride(t *testing.T) {
+	dir := t.TempDir()
+	writeTemplate(t, filepath.Join(dir, "header.html"), "{{ end }}", time.Now())
+
+	if _, err := NewTemplates(dir); err == nil {
+		t.Errorf("NewTemplates() with a broken override didn't fail")
+	}
+
+	// rendering errors are a 500, not half a page
+	writeTemplate(t, filepath.Join(dir, "header.html"), `{{ inc "one" }}`, time.Now())
+	tmpl, err := NewTemplates(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, body := renderString(t, tmpl, "header.html"); code != http.StatusInternalServerError || !strings.HasPrefix(body, "Error rendering html") {
+		t.Errorf("render = %v %q, want a 500", code, body)
+	}
+}
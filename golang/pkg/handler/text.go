@@ -18,6 +18,7 @@ func (h *Handler) helloText(w http.ResponseWriter, attrs attrs.Payload) {
 	fmt.Fprintf(w, "Hostname: %s\n", attrs.Guest.Hostname)
 	fmt.Fprintf(w, "Local IP Address: %s\n", attrs.Guest.GuestIpAddr)
 
+	fmt.Fprintf(w, "Platform: %s (%s confidence)\n", attrs.Platform.Name, attrs.Platform.Confidence)
 	fmt.Fprintf(w, "Zone: %s\n", attrs.Zone)
 	fmt.Fprintf(w, "Project: %s\n", attrs.Project)
 	fmt.Fprintf(w, "Node FQDN: %s\n", attrs.NodeName)
@@ -39,6 +40,33 @@ func (h *Handler) helloText(w http.ResponseWriter, attrs attrs.Payload) {
 		}
 	}
 
+	// if we're in Cloud Run
+	if attrs.Run != nil {
+		fmt.Fprintf(w, "Cloud Run Service: %s\n", attrs.Run.Service)
+		fmt.Fprintf(w, "Cloud Run Revision: %s\n", attrs.Run.Revision)
+		fmt.Fprintf(w, "Cloud Run Configuration: %s\n", attrs.Run.Configuration)
+		if attrs.Run.Concurrency > 0 {
+			fmt.Fprintf(w, "Cloud Run Concurrency: %d\n", attrs.Run.Concurrency)
+		}
+		fmt.Fprintf(w, "Cloud Run Region: %s\n", attrs.Run.Region)
+		fmt.Fprintf(w, "Cloud Run Instance ID: %s\n", attrs.Run.InstanceId)
+	}
+
+	// if we're in Cloud Functions
+	if attrs.Cf != nil {
+		fmt.Fprintf(w, "Cloud Function Name: %s\n", attrs.Cf.Name)
+		fmt.Fprintf(w, "Cloud Function Generation: %s\n", attrs.Cf.Generation)
+		fmt.Fprintf(w, "Cloud Function Revision: %s\n", attrs.Cf.Revision)
+		fmt.Fprintf(w, "Cloud Function Region: %s\n", attrs.Cf.Region)
+		fmt.Fprintf(w, "Cloud Function Runtime: %s\n", attrs.Cf.Runtime)
+		if attrs.Cf.MemoryMB > 0 {
+			fmt.Fprintf(w, "Cloud Function Memory: %d MB\n", attrs.Cf.MemoryMB)
+		}
+		if attrs.Cf.Target != "" {
+			fmt.Fprintf(w, "Cloud Function Target: %s (%s)\n", attrs.Cf.Target, attrs.Cf.SignatureType)
+		}
+	}
+
 	// if we're in GKE
 	if attrs.Gke != nil {
 		// get the cluster name
@@ -0,0 +1,44 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// toGeneric round-trips v through JSON, so other encoders see the same field
+// names as the JSON response does
+func toGeneric(v interface{}) (interface{}, error) {
+	jsonObj, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var generic interface{}
+	if err := json.Unmarshal(jsonObj, &generic); err != nil {
+		return nil, err
+	}
+
+	return generic, nil
+}
+
+// helloYAML responds with yaml response
+func (h *Handler) helloYAML(w http.ResponseWriter, payload interface{}) {
+	generic, err := toGeneric(payload)
+	if err != nil {
+		zap.S().Errorf("error marshalling to yaml: %s", err)
+		http.Error(w, "Error marshalling to yaml", http.StatusInternalServerError)
+		return
+	}
+
+	yamlObj, err := yaml.Marshal(generic)
+	if err != nil {
+		zap.S().Errorf("error marshalling to yaml: %s", err)
+		http.Error(w, "Error marshalling to yaml", http.StatusInternalServerError)
+		return
+	}
+
+	w.Write(yamlObj)
+}
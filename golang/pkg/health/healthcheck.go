@@ -1,50 +1,286 @@
 package health
 
 import (
-	"fmt"
+	"encoding/json"
+	"errors"
 	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/heptiolabs/healthcheck"
 )
 
-func NullHealthCheck() healthcheck.Check {
+// The app exposes three probe endpoints, each with its own set of checks:
+//
+//	/livez    - liveness checks only. These should only fail if restarting
+//	            the process would help, never because of an upstream.
+//	/readyz   - liveness, startup and readiness checks.
+//	/startupz - startup checks, e.g. the startup busy loop has finished.
+//
+// Informational checks are reported by every endpoint with ?verbose=1 but
+// never change the status.
+
+type Check = healthcheck.Check
+
+const (
+	kindLiveness      = "liveness"
+	kindReadiness     = "readiness"
+	kindStartup       = "startup"
+	kindInformational = "informational"
+)
+
+type namedCheck struct {
+	name  string
+	kind  string
+	check Check
+}
+
+// Registry holds the checks for each probe endpoint
+type Registry struct {
+	mu     sync.RWMutex
+	checks []namedCheck
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (reg *Registry) add(kind string, name string, check Check) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	reg.checks = append(reg.checks, namedCheck{name: name, kind: kind, check: check})
+}
+
+func (reg *Registry) AddLivenessCheck(name string, check Check) {
+	reg.add(kindLiveness, name, check)
+}
+
+func (reg *Registry) AddReadinessCheck(name string, check Check) {
+	reg.add(kindReadiness, name, check)
+}
+
+func (reg *Registry) AddStartupCheck(name string, check Check) {
+	reg.add(kindStartup, name, check)
+}
+
+func (reg *Registry) AddInformationalCheck(name string, check Check) {
+	reg.add(kindInformational, name, check)
+}
+
+type checkResult struct {
+	Kind     string `json:"kind"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Critical bool   `json:"critical"`
+}
+
+type probeResult struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks,omitempty"`
+}
+
+// run evaluates every check of the given kinds, plus the informational ones
+func (reg *Registry) run(kinds ...string) (bool, map[string]checkResult) {
+	reg.mu.RLock()
+	checks := make([]namedCheck, len(reg.checks))
+	copy(checks, reg.checks)
+	reg.mu.RUnlock()
+
+	healthy := true
+	results := make(map[string]checkResult)
+	for _, c := range checks {
+		critical := false
+		for _, k := range kinds {
+			if c.kind == k {
+				critical = true
+			}
+		}
+
+		if !critical && c.kind != kindInformational {
+			continue
+		}
+
+		result := checkResult{
+			Kind:     c.kind,
+			Status:   "ok",
+			Critical: critical,
+		}
+
+		if err := c.check(); err != nil {
+			result.Status = "failed"
+			result.Error = err.Error()
+			if critical {
+				healthy = false
+			}
+		}
+
+		results[c.name] = result
+	}
+
+	return healthy, results
+}
+
+func (reg *Registry) handle(w http.ResponseWriter, r *http.Request, kinds ...string) {
+	healthy, results := reg.run(kinds...)
+
+	status := http.StatusOK
+	out := probeResult{Status: "ok"}
+	if !healthy {
+		status = http.StatusServiceUnavailable
+		out.Status = "failed"
+	}
+
+	verbose := r.URL.Query().Get("verbose")
+	if verbose != "" && verbose != "0" && verbose != "false" {
+		out.Checks = results
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "    ")
+	encoder.Encode(out)
+}
+
+func (reg *Registry) LiveEndpoint(w http.ResponseWriter, r *http.Request) {
+	reg.handle(w, r, kindLiveness)
+}
+
+func (reg *Registry) ReadyEndpoint(w http.ResponseWriter, r *http.Request) {
+	reg.handle(w, r, kindLiveness, kindStartup, kindReadiness)
+}
+
+func (reg *Registry) StartupEndpoint(w http.ResponseWriter, r *http.Request) {
+	reg.handle(w, r, kindStartup)
+}
+
+// Names lists the registered checks, mostly for logging at startup
+func (reg *Registry) Names() []string {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	names := make([]string, 0, len(reg.checks))
+	for _, c := range reg.checks {
+		names = append(names, c.kind+"/"+c.name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// DefaultRegistry is where the package-level functions register checks
+var DefaultRegistry = NewRegistry()
+
+func AddLivenessCheck(name string, check Check) {
+	DefaultRegistry.AddLivenessCheck(name, check)
+}
+
+func AddReadinessCheck(name string, check Check) {
+	DefaultRegistry.AddReadinessCheck(name, check)
+}
+
+func AddStartupCheck(name string, check Check) {
+	DefaultRegistry.AddStartupCheck(name, check)
+}
+
+func AddInformationalCheck(name string, check Check) {
+	DefaultRegistry.AddInformationalCheck(name, check)
+}
+
+func NullHealthCheck() Check {
 	return func() error {
 		return nil
 	}
 }
 
-func HealthCheckHandler() (http.HandlerFunc) {
-	// add health check
-	health := healthcheck.NewHandler()
+// Cached wraps a check so that it runs at most once per ttl, no matter how
+// often the probes come in. Probes in between get the last result.
+func Cached(check Check, ttl time.Duration) Check {
+	var mu sync.Mutex
+	var last time.Time
+	var lastErr error
+
+	return func() error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if !last.IsZero() && time.Since(last) < ttl {
+			return lastErr
+		}
 
-	//metaDataURL := "http://metadata/computeMetadata/v1"
-	metadataHostname := "metadata.google.internal"
-	health.AddReadinessCheck(
-		"upstream-dep-dns",
-		healthcheck.DNSResolveCheck(metadataHostname, 500*time.Millisecond))
-	health.AddReadinessCheck(
-		"upstream-dep-tcp",
-		healthcheck.TCPDialCheck(fmt.Sprintf("%v:80", metadataHostname), 500*time.Millisecond))
+		lastErr = check()
+		last = time.Now()
 
-	health.AddLivenessCheck(
-		"upstream-dep-dns",
-		healthcheck.DNSResolveCheck(metadataHostname, 500*time.Millisecond))
-	health.AddLivenessCheck(
-		"upstream-dep-tcp",
-		healthcheck.TCPDialCheck(fmt.Sprintf("%v:80", metadataHostname), 500*time.Millisecond))
+		return lastErr
+	}
+}
 
-	return http.HandlerFunc(health.ReadyEndpoint)
+// Flag is a check that fails with its reason until Set is called, e.g. for
+// "the startup busy loop has finished"
+type Flag struct {
+	done   int32
+	reason string
+}
 
-	/*
-		// Trace exporter: Zipkin
-		localEndpoint, err := openzipkin.NewEndpoint("ochttp_tutorial", "localhost:5454")
-		if err != nil {
-			log.Fatalf("Failed to create the local zipkinEndpoint: %v", err)
+func NewFlag(reason string) *Flag {
+	return &Flag{
+		reason: reason,
+	}
+}
+
+func (f *Flag) Set() {
+	atomic.StoreInt32(&f.done, 1)
+}
+
+func (f *Flag) Check() Check {
+	return func() error {
+		if atomic.LoadInt32(&f.done) == 0 {
+			return errors.New(f.reason)
+		}
+		return nil
+	}
+}
+
+// set once we've been told to shut down, so load balancers stop sending us
+// traffic before the server stops accepting it
+var shuttingDown int32
+
+// SetShuttingDown makes the readiness check fail from now on
+func SetShuttingDown() {
+	atomic.StoreInt32(&shuttingDown, 1)
+}
+
+func ShutdownCheck() Check {
+	return func() error {
+		if atomic.LoadInt32(&shuttingDown) != 0 {
+			return errors.New("shutting down")
 		}
-		reporter := zipkinHTTP.NewReporter("http://localhost:9411/api/v2/spans")
-		ze := zipkin.NewExporter(reporter, localEndpoint)
-		trace.RegisterExporter(ze)
-		trace.ApplyConfig(trace.Config{DefaultSampler: trace.AlwaysSample()})
-	*/
+		return nil
+	}
+}
+
+// RegisterDefaultChecks adds the checks every instance of the app has
+func RegisterDefaultChecks() {
+	// a leak, or just a load test with thousands of connections open, so
+	// only reported: restarting mid-test wouldn't help either way
+	AddInformationalCheck("goroutine-threshold", healthcheck.GoroutineCountCheck(10000))
+
+	AddReadinessCheck("shutdown", ShutdownCheck())
+}
+
+// LivezHandler, ReadyzHandler and StartupzHandler serve the default registry
+func LivezHandler() http.HandlerFunc {
+	return http.HandlerFunc(DefaultRegistry.LiveEndpoint)
+}
+
+func ReadyzHandler() http.HandlerFunc {
+	return http.HandlerFunc(DefaultRegistry.ReadyEndpoint)
+}
+
+func StartupzHandler() http.HandlerFunc {
+	return http.HandlerFunc(DefaultRegistry.StartupEndpoint)
 }
@@ -0,0 +1,197 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func failing() error {
+	return errors.New("down")
+}
+
+func newTestRegistry() *Registry {
+	reg := NewRegistry()
+	reg.AddLivenessCheck("live", NullHealthCheck())
+	reg.AddStartupCheck("started", NullHealthCheck())
+	reg.AddReadinessCheck("upstream", failing)
+	reg.AddInformationalCheck("info", failing)
+
+	return reg
+}
+
+func TestRegistryRun(t *testing.T) {
+	tests := []struct {
+		name    string
+		kinds   []string
+		healthy bool
+		// the checks reported, and whether each one counted
+		critical map[string]bool
+	}{
+		{
+			name:     "liveness",
+			kinds:    []string{kindLiveness},
+			healthy:  true,
+			critical: map[string]bool{"live": true, "info": false},
+		},
+		{
+			name:     "startup",
+			kinds:    []string{kindStartup},
+			healthy:  true,
+			critical: map[string]bool{"started": true, "info": false},
+		},
+		{
+			name:     "readiness",
+			kinds:    []string{kindLiveness, kindStartup, kindReadiness},
+			healthy:  false,
+			critical: map[string]bool{"live": true, "started": true, "upstream": true, "info": false},
+		},
+	}
+
+	reg := newTestRegistry()
+	for _, tt := range tests {
+		healthy, results := reg.run(tt.kinds...)
+		if healthy != tt.healthy {
+			t.Errorf("%v: healthy = %v, want %v", tt.name, healthy, tt.healthy)
+		}
+
+		if len(results) != len(tt.critical) {
+			t.Errorf("%v: results = %+v, want %v", tt.name, results, tt.critical)
+		}
+		for name, critical := range tt.critical {
+			result, found := results[name]
+			if !found {
+				t.Errorf("%v: %v wasn't run", tt.name, name)
+				continue
+			}
+			if result.Critical != critical {
+				t.Errorf("%v: %v critical = %v, want %v", tt.name, name, result.Critical, critical)
+			}
+		}
+	}
+
+	if _, results := reg.run(kindReadiness); results["upstream"].Status != "failed" || results["upstream"].Error != "down" {
+		t.Errorf("upstream = %+v, want failed with the check's error", results["upstream"])
+	}
+}
+
+func TestEndpoints(t *testing.T) {
+	tests := []struct {
+		name     string
+		endpoint func(reg *Registry) http.HandlerFunc
+		url      string
+		status   int
+		checks   int
+	}{
+		{name: "livez", endpoint: func(reg *Registry) http.HandlerFunc { return reg.LiveEndpoint }, url: "/livez", status: http.StatusOK},
+		{name: "readyz", endpoint: func(reg *Registry) http.HandlerFunc { return reg.ReadyEndpoint }, url: "/readyz", status: http.StatusServiceUnavailable},
+		{name: "readyz verbose", endpoint: func(reg *Registry) http.HandlerFunc { return reg.ReadyEndpoint }, url: "/readyz?verbose=1", status: http.StatusServiceUnavailable, checks: 4},
+		{name: "startupz verbose=false", endpoint: func(reg *Registry) http.HandlerFunc { return reg.StartupEndpoint }, url: "/startupz?verbose=false", status: http.StatusOK},
+	}
+
+	reg := newTestRegistry()
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		tt.endpoint(reg)(w, httptest.NewRequest(http.MethodGet, tt.url, nil))
+
+		if w.Code != tt.status {
+			t.Errorf("%v: status = %v, want %v", tt.name, w.Code, tt.status)
+		}
+
+		var out probeResult
+		if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+			t.Errorf("%v: %v", tt.name, err)
+			continue
+		}
+		if len(out.Checks) != tt.checks {
+			t.Errorf("%v: checks = %+v, want %v of them", tt.name, out.Checks, tt.checks)
+		}
+	}
+}
+
+func TestCached(t *testing.T) {
+	calls := 0
+	var err error
+	check := Cached(func() error {
+		calls++
+		return err
+	}, 200*time.Millisecond)
+
+	if check() != nil || calls != 1 {
+		t.Fatalf("first call: calls = %v", calls)
+	}
+
+	// within the ttl the last result is served, even though it changed
+	err = errors.New("down")
+	if check() != nil || calls != 1 {
+		t.Errorf("within ttl: calls = %v, want the cached result", calls)
+	}
+
+	time.Sleep(250 * time.Millisecond)
+	if check() == nil || calls != 2 {
+		t.Errorf("after ttl: calls = %v, want the check rerun", calls)
+	}
+	if check() == nil || calls != 2 {
+		t.Errorf("failures are cached too: calls = %v", calls)
+	}
+}
+
+func TestFlag(t *testing.T) {
+	f := NewFlag("still starting")
+	check := f.Check()
+
+	if err := check(); err == nil || err.Error() != "still starting" {
+		t.Errorf("before Set: error = %v, want the reason", err)
+	}
+
+	f.Set()
+	if err := check(); err != nil {
+		t.Errorf("after Set: error = %v", err)
+	}
+}
+
+// load tests run thousands of goroutines, which mustn't get the pod restarted
+func TestGoroutineCheckIsInformational(t *testing.T) {
+	saved := DefaultRegistry
+	defer func() { DefaultRegistry = saved }()
+	DefaultRegistry = NewRegistry()
+
+	RegisterDefaultChecks()
+	for _, c := range DefaultRegistry.checks {
+		if c.name == "goroutine-threshold" && c.kind != kindInformational {
+			t.Errorf("goroutine-threshold is a %v check", c.kind)
+		}
+	}
+}
+
+// load balancers have to see readiness fail for as long as the app is
+// still draining, while liveness keeps passing so it isn't killed first
+func TestShutdownFailsReadiness(t *testing.T) {
+	defer atomic.StoreInt32(&shuttingDown, 0)
+
+	reg := NewRegistry()
+	reg.AddLivenessCheck("live", NullHealthCheck())
+	reg.AddReadinessCheck("shutdown", ShutdownCheck())
+
+	probe := func(endpoint http.HandlerFunc) int {
+		w := httptest.NewRecorder()
+		endpoint(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		return w.Code
+	}
+
+	if code := probe(reg.ReadyEndpoint); code != http.StatusOK {
+		t.Errorf("before shutdown: readyz = %v", code)
+	}
+
+	SetShuttingDown()
+	if code := probe(reg.ReadyEndpoint); code != http.StatusServiceUnavailable {
+		t.Errorf("shutting down: readyz = %v, want %v", code, http.StatusServiceUnavailable)
+	}
+	if code := probe(reg.LiveEndpoint); code != http.StatusOK {
+		t.Errorf("shutting down: livez = %v, want %v", code, http.StatusOK)
+	}
+}
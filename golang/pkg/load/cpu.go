@@ -0,0 +1,359 @@
+package load
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+
+	metrics "helloworld-http/pkg/metrics"
+)
+
+const (
+	tracerName = "helloworld-http"
+
+	// each core is busy for Percent of every period, and idle for the rest
+	dutyPeriod = 100 * time.Millisecond
+
+	// how many finished jobs to keep around so their status can still be read
+	finishedJobs = 100
+)
+
+// Job states
+const (
+	STATUS_RUNNING   = "running"
+	STATUS_DONE      = "done"
+	STATUS_CANCELLED = "cancelled"
+)
+
+// CPULoad describes a job that keeps Cores cores Percent busy for Duration
+type CPULoad struct {
+	ID       string     `json:"id"`
+	Cores    int        `json:"cores"`
+	Percent  float64    `json:"percent"`
+	Duration string     `json:"duration"`
+	Status   string     `json:"status"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+// MaxCores is the most cores a single load can ask for, one goroutine each,
+// and how many the loads can keep busy between them
+var MaxCores = runtime.NumCPU()
+
+// ErrTooBusy is returned for a load that would keep more than MaxCores busy
+// on top of the ones already running
+var ErrTooBusy = errors.New("too many cores already busy")
+
+func validateCPU(cores int, percent float64, duration time.Duration) error {
+	if cores <= 0 || cores > MaxCores {
+		return fmt.Errorf("cores must be between 1 and %d, got %d", MaxCores, cores)
+	}
+	if percent <= 0 || percent > 100 {
+		return fmt.Errorf("percent must be more than 0 and at most 100, got %v", percent)
+	}
+	if duration <= 0 {
+		return fmt.Errorf("duration must be positive, got %v", duration)
+	}
+
+	return nil
+}
+
+// dutyCycle keeps one core busy for percent of every dutyPeriod until the
+// deadline or the context is done
+func dutyCycle(ctx context.Context, percent float64, deadline time.Time) {
+	busy := time.Duration(float64(dutyPeriod) * percent / 100)
+
+	for {
+		start := time.Now()
+		if !start.Before(deadline) {
+			return
+		}
+
+		for time.Since(start) < busy {
+			// spin
+		}
+
+		idle := dutyPeriod - time.Since(start)
+		if idle <= 0 {
+			// checking here too so a 100% loop still stops when cancelled
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
+			continue
+		}
+
+		timer := time.NewTimer(idle)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// RunCPU keeps cores cores percent busy for duration, returning early if the
+// context is cancelled. It returns the context's error if it was.
+func RunCPU(ctx context.Context, cores int, percent float64, duration time.Duration) error {
+	if err := validateCPU(cores, percent, duration); err != nil {
+		return err
+	}
+
+	return runCPU(ctx, cores, percent, duration)
+}
+
+// runCPU is RunCPU for a load that's already been validated
+func runCPU(ctx context.Context, cores int, percent float64, duration time.Duration) error {
+	zap.S().Infof("Loading %v cores to %v%% for %v", cores, percent, duration)
+
+	deadline := time.Now().Add(duration)
+
+	var wg sync.WaitGroup
+	for i := 0; i < cores; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dutyCycle(ctx, percent, deadline)
+		}()
+	}
+	wg.Wait()
+
+	return ctx.Err()
+}
+
+func cpuAttributes(cores int, percent float64, duration time.Duration) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.Int("load.cpu.cores", cores),
+		attribute.Float64("load.cpu.percent", percent),
+		attribute.String("load.duration", duration.String()),
+	}
+}
+
+type cpuLoad struct {
+	mu     sync.Mutex
+	info   CPULoad
+	cancel context.CancelFunc
+}
+
+func (c *cpuLoad) snapshot() CPULoad {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.info
+}
+
+func (c *cpuLoad) finish(status string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	c.info.Status = status
+	c.info.Finished = &now
+}
+
+// CPULoads runs CPU load jobs, both in the foreground and in the background
+type CPULoads struct {
+	mu     sync.Mutex
+	jobs   map[string]*cpuLoad
+	order  []string
+	nextID int
+	active int
+	cores  float64
+}
+
+func NewCPULoads() *CPULoads {
+	return &CPULoads{
+		jobs: make(map[string]*cpuLoad),
+	}
+}
+
+// reserve counts a load towards the running jobs and the cores they're
+// keeping busy, unless that would be more than MaxCores
+func (cl *CPULoads) reserve(cores int, percent float64) error {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	busy := float64(cores) * percent / 100
+	// with some slack for the rounding of percents that add up to a core
+	if cl.cores+busy > float64(MaxCores)+1e-9 {
+		return fmt.Errorf("%w: %.2f of %d, asked for %.2f more", ErrTooBusy, cl.cores, MaxCores, busy)
+	}
+
+	cl.active++
+	cl.cores += busy
+	metrics.SetCPULoad(cl.active, cl.cores)
+
+	return nil
+}
+
+// release stops counting a load reserved earlier
+func (cl *CPULoads) release(cores int, percent float64) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	cl.active--
+	cl.cores -= float64(cores) * percent / 100
+	if cl.active == 0 {
+		cl.cores = 0
+	}
+	metrics.SetCPULoad(cl.active, cl.cores)
+}
+
+// Run loads the CPU until duration is up or the context is done, e.g.
+// because the client went away
+func (cl *CPULoads) Run(ctx context.Context, cores int, percent float64, duration time.Duration) error {
+	if err := validateCPU(cores, percent, duration); err != nil {
+		return err
+	}
+
+	if err := cl.reserve(cores, percent); err != nil {
+		return err
+	}
+	defer cl.release(cores, percent)
+
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "cpu load",
+		trace.WithAttributes(cpuAttributes(cores, percent, duration)...))
+	defer span.End()
+
+	err := runCPU(ctx, cores, percent, duration)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	return err
+}
+
+// Start runs a CPU load job in the background. Its span is linked to, rather
+// than a child of, the span in ctx since it outlives the request.
+func (cl *CPULoads) Start(ctx context.Context, cores int, percent float64, duration time.Duration) (CPULoad, error) {
+	if err := validateCPU(cores, percent, duration); err != nil {
+		return CPULoad{}, err
+	}
+	if err := cl.reserve(cores, percent); err != nil {
+		return CPULoad{}, err
+	}
+
+	jobCtx, cancel := context.WithCancel(context.Background())
+	jobCtx, span := otel.Tracer(tracerName).Start(jobCtx, "cpu load job",
+		trace.WithNewRoot(),
+		trace.WithLinks(trace.LinkFromContext(ctx)),
+		trace.WithAttributes(cpuAttributes(cores, percent, duration)...))
+
+	cl.mu.Lock()
+	cl.nextID++
+	c := &cpuLoad{
+		info: CPULoad{
+			ID:       strconv.Itoa(cl.nextID),
+			Cores:    cores,
+			Percent:  percent,
+			Duration: duration.String(),
+			Status:   STATUS_RUNNING,
+			Started:  time.Now(),
+		},
+		cancel: cancel,
+	}
+	cl.jobs[c.info.ID] = c
+	cl.order = append(cl.order, c.info.ID)
+	cl.mu.Unlock()
+
+	span.SetAttributes(attribute.String("load.job.id", c.info.ID))
+	trace.SpanFromContext(ctx).AddEvent("load.cpu.started", trace.WithAttributes(
+		attribute.String("load.job.id", c.info.ID),
+	))
+
+	go func() {
+		defer span.End()
+		defer cl.release(cores, percent)
+		defer cancel()
+
+		if err := runCPU(jobCtx, cores, percent, duration); err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			c.finish(STATUS_CANCELLED)
+		} else {
+			c.finish(STATUS_DONE)
+		}
+
+		cl.prune()
+		zap.S().Infof("CPU load job %v finished", c.info.ID)
+	}()
+
+	return c.snapshot(), nil
+}
+
+// prune forgets the oldest finished jobs beyond the ones we keep for status
+func (cl *CPULoads) prune() {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	finished := 0
+	for i := len(cl.order) - 1; i >= 0; i-- {
+		id := cl.order[i]
+		if cl.jobs[id].snapshot().Status == STATUS_RUNNING {
+			continue
+		}
+
+		finished++
+		if finished > finishedJobs {
+			delete(cl.jobs, id)
+			cl.order = append(cl.order[:i], cl.order[i+1:]...)
+		}
+	}
+}
+
+// Cancel stops a background job, returning false if there is no such job
+func (cl *CPULoads) Cancel(id string) bool {
+	cl.mu.Lock()
+	c, found := cl.jobs[id]
+	cl.mu.Unlock()
+
+	if !found {
+		return false
+	}
+
+	c.cancel()
+	return true
+}
+
+func (cl *CPULoads) Get(id string) (CPULoad, bool) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	c, found := cl.jobs[id]
+	if !found {
+		return CPULoad{}, false
+	}
+
+	return c.snapshot(), true
+}
+
+func (cl *CPULoads) List() []CPULoad {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	out := make([]CPULoad, 0, len(cl.jobs))
+	for _, c := range cl.jobs {
+		out = append(out, c.snapshot())
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Started.Before(out[j].Started)
+	})
+
+	return out
+}
+
+// DefaultCPULoads is what the /busyloop handlers use
+var DefaultCPULoads = NewCPULoads()
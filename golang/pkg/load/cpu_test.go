@@ -0,0 +1,105 @@
+package load
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestValidateCPU(t *testing.T) {
+	defer func(max int) { MaxCores = max }(MaxCores)
+	MaxCores = 2
+
+	tests := []struct {
+		name     string
+		cores    int
+		percent  float64
+		duration time.Duration
+		wantErr  bool
+	}{
+		{name: "one core", cores: 1, percent: 100, duration: time.Second},
+		{name: "every core", cores: 2, percent: 0.5, duration: time.Second},
+		{name: "no cores", cores: 0, percent: 100, duration: time.Second, wantErr: true},
+		{name: "more cores than allowed", cores: 3, percent: 100, duration: time.Second, wantErr: true},
+		{name: "idle", cores: 1, percent: 0, duration: time.Second, wantErr: true},
+		{name: "over 100 percent", cores: 1, percent: 101, duration: time.Second, wantErr: true},
+		{name: "no duration", cores: 1, percent: 100, duration: 0, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		if err := validateCPU(tt.cores, tt.percent, tt.duration); (err != nil) != tt.wantErr {
+			t.Errorf("%v: validateCPU() error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCPULoadsTotal(t *testing.T) {
+	defer func(max int) { MaxCores = max }(MaxCores)
+	MaxCores = 1
+
+	cl := NewCPULoads()
+	defer func() {
+		for _, job := range cl.List() {
+			cl.Cancel(job.ID)
+		}
+	}()
+
+	// ten tenths of a core add up to the whole of the limit, not over it
+	var first CPULoad
+	for i := 0; i < 10; i++ {
+		job, err := cl.Start(context.Background(), 1, 10, time.Minute)
+		if err != nil {
+			t.Fatalf("job %v: %v", i, err)
+		}
+		if i == 0 {
+			first = job
+		}
+	}
+
+	if _, err := cl.Start(context.Background(), 1, 10, time.Minute); !errors.Is(err, ErrTooBusy) {
+		t.Errorf("Start() over the limit error = %v, want ErrTooBusy", err)
+	}
+	if err := cl.Run(context.Background(), 1, 10, time.Millisecond); !errors.Is(err, ErrTooBusy) {
+		t.Errorf("Run() over the limit error = %v, want ErrTooBusy", err)
+	}
+
+	// cancelling a job makes room again once it's stopped
+	if !cl.Cancel(first.ID) {
+		t.Fatalf("Cancel(%v) didn't find the job", first.ID)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, err := cl.Start(context.Background(), 1, 10, time.Minute)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, ErrTooBusy) || time.Now().After(deadline) {
+			t.Fatalf("Start() after cancelling: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if job, _ := cl.Get(first.ID); job.Status != STATUS_CANCELLED || job.Finished == nil {
+		t.Errorf("cancelled job = %+v", job)
+	}
+}
+
+func TestCPULoadsRun(t *testing.T) {
+	cl := NewCPULoads()
+
+	if err := cl.Run(context.Background(), 1, 10, 50*time.Millisecond); err != nil {
+		t.Errorf("Run() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := cl.Run(ctx, 1, 10, time.Minute); !errors.Is(err, context.Canceled) {
+		t.Errorf("Run() cancelled error = %v, want context.Canceled", err)
+	}
+
+	// a finished Run no longer counts towards the limit
+	if cl.active != 0 || cl.cores != 0 {
+		t.Errorf("after Run: %v jobs keeping %v cores busy", cl.active, cl.cores)
+	}
+}
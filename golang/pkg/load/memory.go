@@ -0,0 +1,283 @@
+package load
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	metrics "helloworld-http/pkg/metrics"
+)
+
+const (
+	// memory is allocated and released in chunks of this size
+	memChunkBytes = 1 << 20
+
+	// writing one byte per page is enough to make the kernel back it
+	pageBytes = 4096
+
+	DEFAULT_MAX_MEMORY_MB = 1024
+)
+
+// MemoryLoad describes a block of memory held for a while to simulate
+// memory pressure
+type MemoryLoad struct {
+	ID        string    `json:"id"`
+	Bytes     int64     `json:"bytes"`
+	Allocated int64     `json:"allocated"`
+	Duration  string    `json:"duration"`
+	Ramp      string    `json:"ramp,omitempty"`
+	Started   time.Time `json:"started"`
+}
+
+// memoryLoad is a MemoryLoad plus the memory itself
+type memoryLoad struct {
+	mu       sync.Mutex
+	info     MemoryLoad
+	chunks   [][]byte
+	released bool
+	cancel   context.CancelFunc
+}
+
+func (m *memoryLoad) allocated() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.info.Allocated
+}
+
+func (m *memoryLoad) snapshot() MemoryLoad {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.info
+}
+
+// grow allocates and touches one more chunk, dropping it if the load was
+// released while it was being allocated
+func (m *memoryLoad) grow(n int64) {
+	chunk := make([]byte, n)
+	for i := 0; i < len(chunk); i += pageBytes {
+		chunk[i] = 1
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.released {
+		return
+	}
+	m.chunks = append(m.chunks, chunk)
+	m.info.Allocated += n
+}
+
+func (m *memoryLoad) release() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.chunks = nil
+	m.info.Allocated = 0
+	m.released = true
+}
+
+// MemoryLoads tracks every memory load that's still holding memory
+type MemoryLoads struct {
+	// how much memory the loads can ask for between them, so that a single
+	// request can't take the whole container down unless it's allowed to
+	MaxBytes int64
+
+	mu     sync.Mutex
+	loads  map[string]*memoryLoad
+	nextID int
+}
+
+func NewMemoryLoads() *MemoryLoads {
+	return &MemoryLoads{
+		MaxBytes: DEFAULT_MAX_MEMORY_MB << 20,
+		loads:    make(map[string]*memoryLoad),
+	}
+}
+
+// MaxMegabytes is the most a single load can ask for, for callers taking the
+// size in MB to check before shifting it into bytes
+func (ml *MemoryLoads) MaxMegabytes() int64 {
+	return ml.MaxBytes >> 20
+}
+
+// updateMetrics publishes how much memory is held across all loads
+func (ml *MemoryLoads) updateMetrics() {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+
+	var total int64
+	for _, m := range ml.loads {
+		total += m.allocated()
+	}
+
+	metrics.SetMemLoad(len(ml.loads), total)
+}
+
+// Start allocates bytes of memory, spread evenly over ramp, and holds on to
+// it for duration (counted from when the ramp finishes) or until released
+func (ml *MemoryLoads) Start(bytes int64, duration time.Duration, ramp time.Duration) (MemoryLoad, error) {
+	if bytes <= 0 {
+		return MemoryLoad{}, fmt.Errorf("bytes must be positive, got %d", bytes)
+	}
+	if duration <= 0 {
+		return MemoryLoad{}, fmt.Errorf("duration must be positive, got %v", duration)
+	}
+	if ramp < 0 {
+		return MemoryLoad{}, fmt.Errorf("ramp must not be negative, got %v", ramp)
+	}
+
+	ml.mu.Lock()
+	var held int64
+	for _, m := range ml.loads {
+		held += m.info.Bytes
+	}
+	// held+bytes could overflow, held can't be over MaxBytes
+	if bytes > ml.MaxBytes-held {
+		ml.mu.Unlock()
+		return MemoryLoad{}, fmt.Errorf("%d bytes on top of the %d already asked for is over the limit of %d", bytes, held, ml.MaxBytes)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ml.nextID++
+	m := &memoryLoad{
+		info: MemoryLoad{
+			ID:       strconv.Itoa(ml.nextID),
+			Bytes:    bytes,
+			Duration: duration.String(),
+			Started:  time.Now(),
+		},
+		cancel: cancel,
+	}
+	if ramp > 0 {
+		m.info.Ramp = ramp.String()
+	}
+	ml.loads[m.info.ID] = m
+	ml.mu.Unlock()
+
+	zap.S().Infof("Allocating %v bytes over %v for %v", bytes, ramp, duration)
+
+	go ml.run(ctx, m, duration, ramp)
+
+	return m.snapshot(), nil
+}
+
+func (ml *MemoryLoads) run(ctx context.Context, m *memoryLoad, duration time.Duration, ramp time.Duration) {
+	defer ml.remove(m.info.ID)
+
+	chunks := (m.info.Bytes + memChunkBytes - 1) / memChunkBytes
+	var interval time.Duration
+	if ramp > 0 && chunks > 1 {
+		interval = ramp / time.Duration(chunks-1)
+	}
+
+	remaining := m.info.Bytes
+	for remaining > 0 {
+		// released while still allocating
+		if ctx.Err() != nil {
+			return
+		}
+
+		n := int64(memChunkBytes)
+		if remaining < n {
+			n = remaining
+		}
+
+		m.grow(n)
+		ml.updateMetrics()
+		remaining -= n
+
+		if interval > 0 && remaining > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+		}
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(duration):
+	}
+}
+
+// remove drops a load and hands its memory back to the OS, so RSS actually
+// goes down and autoscalers see it
+func (ml *MemoryLoads) remove(id string) {
+	ml.mu.Lock()
+	m, found := ml.loads[id]
+	delete(ml.loads, id)
+	ml.mu.Unlock()
+
+	if !found {
+		return
+	}
+
+	m.release()
+	ml.updateMetrics()
+	debug.FreeOSMemory()
+
+	zap.S().Infof("Released memory load %v", id)
+}
+
+// Release stops a load early, returning false if there is no such load
+func (ml *MemoryLoads) Release(id string) bool {
+	ml.mu.Lock()
+	m, found := ml.loads[id]
+	ml.mu.Unlock()
+
+	if !found {
+		return false
+	}
+
+	m.cancel()
+	ml.remove(id)
+	return true
+}
+
+// ReleaseAll stops every load early
+func (ml *MemoryLoads) ReleaseAll() {
+	for _, m := range ml.List() {
+		ml.Release(m.ID)
+	}
+}
+
+func (ml *MemoryLoads) Get(id string) (MemoryLoad, bool) {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+
+	m, found := ml.loads[id]
+	if !found {
+		return MemoryLoad{}, false
+	}
+
+	return m.snapshot(), true
+}
+
+func (ml *MemoryLoads) List() []MemoryLoad {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+
+	out := make([]MemoryLoad, 0, len(ml.loads))
+	for _, m := range ml.loads {
+		out = append(out, m.snapshot())
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Started.Before(out[j].Started)
+	})
+
+	return out
+}
+
+// DefaultMemoryLoads is what the /memload handlers use
+var DefaultMemoryLoads = NewMemoryLoads()
@@ -0,0 +1,108 @@
+package load
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestMemoryLoadsStart(t *testing.T) {
+	tests := []struct {
+		name     string
+		bytes    int64
+		duration time.Duration
+		ramp     time.Duration
+		wantErr  bool
+	}{
+		{name: "up to the limit", bytes: 3 << 20, duration: time.Minute},
+		{name: "one byte over the limit", bytes: 3<<20 + 1, duration: time.Minute, wantErr: true},
+		// added to what's held this wraps negative
+		{name: "overflowing the limit", bytes: math.MaxInt64 - 1<<20 + 1, duration: time.Minute, wantErr: true},
+		{name: "nothing", bytes: 0, duration: time.Minute, wantErr: true},
+		{name: "negative", bytes: -1, duration: time.Minute, wantErr: true},
+		{name: "no duration", bytes: 1 << 20, wantErr: true},
+		{name: "negative ramp", bytes: 1 << 20, duration: time.Minute, ramp: -time.Second, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ml := NewMemoryLoads()
+			ml.MaxBytes = 4 << 20
+			defer ml.ReleaseAll()
+
+			if _, err := ml.Start(1<<20, time.Minute, 0); err != nil {
+				t.Fatal(err)
+			}
+
+			m, err := ml.Start(tt.bytes, tt.duration, tt.ramp)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Start(%d) error = %v, want error %v", tt.bytes, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if m.Bytes != tt.bytes {
+				t.Errorf("Bytes = %d, want %d", m.Bytes, tt.bytes)
+			}
+			if _, found := ml.Get(m.ID); !found {
+				t.Errorf("Get(%v) didn't find the load", m.ID)
+			}
+		})
+	}
+}
+
+func TestMemoryLoadsRelease(t *testing.T) {
+	ml := NewMemoryLoads()
+	ml.MaxBytes = 2 << 20
+	defer ml.ReleaseAll()
+
+	m, err := ml.Start(2<<20, time.Minute, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ml.Start(1, time.Minute, 0); err == nil {
+		t.Error("Start() went over the limit")
+	}
+
+	if !ml.Release(m.ID) {
+		t.Fatalf("Release(%v) didn't find the load", m.ID)
+	}
+	if ml.Release(m.ID) {
+		t.Errorf("Release(%v) found the load twice", m.ID)
+	}
+	if loads := ml.List(); len(loads) != 0 {
+		t.Errorf("List() = %+v after releasing everything", loads)
+	}
+
+	// released memory no longer counts towards the limit
+	if _, err := ml.Start(2<<20, time.Minute, 0); err != nil {
+		t.Errorf("Start() after release: %v", err)
+	}
+}
+
+func TestMemoryLoadAllocates(t *testing.T) {
+	ml := NewMemoryLoads()
+	defer ml.ReleaseAll()
+
+	m, err := ml.Start(3<<20+1, time.Minute, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got, found := ml.Get(m.ID)
+		if !found {
+			t.Fatalf("load %v went away", m.ID)
+		}
+		if got.Allocated == got.Bytes {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("allocated %d of %d bytes", got.Allocated, got.Bytes)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,289 @@
+package logging
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Access log formats that can be picked with ACCESS_LOG_FORMAT
+const (
+	// a structured log entry with Cloud Logging's httpRequest field
+	ACCESS_LOG_CLOUD = "cloud"
+	// Apache's combined log format, on stdout
+	ACCESS_LOG_COMBINED = "combined"
+	// key=value pairs, on stdout
+	ACCESS_LOG_LOGFMT = "logfmt"
+	ACCESS_LOG_OFF    = "off"
+
+	DEFAULT_ACCESS_LOG_FORMAT  = ACCESS_LOG_CLOUD
+	DEFAULT_ACCESS_LOG_EXCLUDE = "/healthz,/livez,/readyz,/startupz,/metrics"
+)
+
+// AccessLog logs one entry per request once it's been served
+type AccessLog struct {
+	Format string
+
+	// path.Match patterns of requests not to log
+	Exclude []string
+
+	// fraction of requests to log, 0-1. Server errors are always logged.
+	SampleRatio float64
+
+	// for correlating cloud entries with the request's trace
+	ProjectID string
+
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// NewAccessLog logs every request in format, except those excluded by default
+func NewAccessLog(format string, projectID string) (*AccessLog, error) {
+	switch format {
+	case ACCESS_LOG_CLOUD, ACCESS_LOG_COMBINED, ACCESS_LOG_LOGFMT, ACCESS_LOG_OFF:
+	default:
+		return nil, fmt.Errorf("unknown access log format %q, use %v, %v, %v or %v",
+			format, ACCESS_LOG_CLOUD, ACCESS_LOG_COMBINED, ACCESS_LOG_LOGFMT, ACCESS_LOG_OFF)
+	}
+
+	return &AccessLog{
+		Format:      format,
+		Exclude:     ParseExclude(DEFAULT_ACCESS_LOG_EXCLUDE),
+		SampleRatio: 1,
+		ProjectID:   projectID,
+		out:         os.Stdout,
+	}, nil
+}
+
+// ParseExclude splits a comma separated list of path patterns
+func ParseExclude(s string) []string {
+	var patterns []string
+	for _, pattern := range strings.Split(s, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+
+	return patterns
+}
+
+// NewAccessLogFromEnv configures the access log from ACCESS_LOG_FORMAT,
+// ACCESS_LOG_EXCLUDE and ACCESS_LOG_SAMPLE
+func NewAccessLogFromEnv(projectID string) (*AccessLog, error) {
+	format := DEFAULT_ACCESS_LOG_FORMAT
+	if fromEnv := os.Getenv("ACCESS_LOG_FORMAT"); fromEnv != "" {
+		format = fromEnv
+	}
+
+	a, err := NewAccessLog(format, projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	if fromEnv, exists := os.LookupEnv("ACCESS_LOG_EXCLUDE"); exists {
+		a.Exclude = ParseExclude(fromEnv)
+		for _, pattern := range a.Exclude {
+			if _, err := path.Match(pattern, "/"); err != nil {
+				return nil, fmt.Errorf("invalid pattern in ACCESS_LOG_EXCLUDE: %q", pattern)
+			}
+		}
+	}
+
+	if fromEnv := os.Getenv("ACCESS_LOG_SAMPLE"); fromEnv != "" {
+		ratio, err := strconv.ParseFloat(fromEnv, 64)
+		if err != nil || ratio < 0 || ratio > 1 {
+			return nil, fmt.Errorf("invalid value for ACCESS_LOG_SAMPLE: %v", fromEnv)
+		}
+		a.SampleRatio = ratio
+	}
+
+	return a, nil
+}
+
+func (a *AccessLog) excluded(r *http.Request) bool {
+	for _, pattern := range a.Exclude {
+		if matched, _ := path.Match(pattern, r.URL.Path); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// accessResponseWriter records what was sent back for the access log
+type accessResponseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *accessResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+
+	return n, err
+}
+
+// Flush and Hijack pass through to the real ResponseWriter so handlers
+// further down can still stream or take over the connection
+func (w *accessResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *accessResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("%T does not support hijacking", w.ResponseWriter)
+	}
+
+	return h.Hijack()
+}
+
+// Middleware logs each request once it's been served. It goes after
+// trace.Middleware, so that entries can be correlated with the request's span.
+func (a *AccessLog) Middleware(next http.Handler) http.Handler {
+	if a.Format == ACCESS_LOG_OFF {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.excluded(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		started := time.Now()
+		rw := &accessResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(rw, r)
+		// net/http sends a 200 for handlers that don't write anything
+		if rw.status == 0 {
+			rw.status = http.StatusOK
+		}
+
+		if rw.status < 500 && a.SampleRatio < 1 && rand.Float64() >= a.SampleRatio {
+			return
+		}
+
+		req := NewHTTPRequest(r)
+		req.Status = rw.status
+		req.ResponseSize = rw.bytes
+		req.Latency = time.Since(started)
+
+		switch a.Format {
+		case ACCESS_LOG_COMBINED:
+			a.write(combined(r, req, started))
+		case ACCESS_LOG_LOGFMT:
+			a.write(logfmt(r, req, started))
+		default:
+			a.cloud(r, req)
+		}
+	})
+}
+
+func (a *AccessLog) write(line string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	io.WriteString(a.out, line+"\n")
+}
+
+func (a *AccessLog) cloud(r *http.Request, req HTTPRequest) {
+	level := zapcore.InfoLevel
+	switch {
+	case req.Status >= 500:
+		level = zapcore.ErrorLevel
+	case req.Status >= 400:
+		level = zapcore.WarnLevel
+	}
+
+	fields := append(TraceFields(r.Context(), a.ProjectID),
+		zap.Object(KEY_HTTP_REQUEST, req))
+	if ce := zap.L().Check(level, fmt.Sprintf("%v %v %d", r.Method, r.URL.RequestURI(), req.Status)); ce != nil {
+		ce.Write(fields...)
+	}
+}
+
+// combined formats the request like Apache's combined log format
+func combined(r *http.Request, req HTTPRequest, started time.Time) string {
+	size := "-"
+	if req.ResponseSize > 0 {
+		size = strconv.FormatInt(req.ResponseSize, 10)
+	}
+
+	return fmt.Sprintf("%v - - [%v] \"%v %v %v\" %d %v %q %q",
+		req.RemoteIP,
+		started.Format("02/Jan/2006:15:04:05 -0700"),
+		r.Method, r.URL.RequestURI(), r.Proto,
+		req.Status, size,
+		orDash(req.Referer), orDash(req.UserAgent))
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
+
+// logfmt formats the request as key=value pairs, quoting values that need it
+func logfmt(r *http.Request, req HTTPRequest, started time.Time) string {
+	pairs := [][2]string{
+		{"time", started.Format(time.RFC3339Nano)},
+		{"method", r.Method},
+		{"path", r.URL.RequestURI()},
+		{"protocol", r.Proto},
+		{"status", strconv.Itoa(req.Status)},
+		{"bytes", strconv.FormatInt(req.ResponseSize, 10)},
+		{"latency", req.Latency.String()},
+		{"remote_ip", req.RemoteIP},
+		{"user_agent", req.UserAgent},
+		{"referer", req.Referer},
+	}
+	if sc := trace.SpanContextFromContext(r.Context()); sc.IsValid() {
+		pairs = append(pairs,
+			[2]string{"trace_id", sc.TraceID().String()},
+			[2]string{"span_id", sc.SpanID().String()})
+	}
+
+	var b strings.Builder
+	for i, pair := range pairs {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(pair[0])
+		b.WriteByte('=')
+		if pair[1] == "" || strings.ContainsAny(pair[1], " \"=\\") {
+			b.WriteString(strconv.Quote(pair[1]))
+		} else {
+			b.WriteString(pair[1])
+		}
+	}
+
+	return b.String()
+}
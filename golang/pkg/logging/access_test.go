@@ -0,0 +1,221 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+var testStarted = time.Date(2023, time.March, 4, 15, 16, 17, 0, time.UTC)
+
+func testRequest() *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/hello?name=a%20b", nil)
+	r.RemoteAddr = "10.1.2.3:4567"
+	r.Header.Set("User-Agent", "curl/7.88.1")
+
+	return r
+}
+
+func TestCombined(t *testing.T) {
+	tests := []struct {
+		name string
+		req  HTTPRequest
+		want string
+	}{
+		{
+			name: "with a body",
+			req:  HTTPRequest{RemoteIP: "10.1.2.3", Status: 200, ResponseSize: 1234, UserAgent: "curl/7.88.1"},
+			want: `10.1.2.3 - - [04/Mar/2023:15:16:17 +0000] "GET /hello?name=a%20b HTTP/1.1" 200 1234 "-" "curl/7.88.1"`,
+		},
+		{
+			name: "empty",
+			req:  HTTPRequest{RemoteIP: "10.1.2.3", Status: 204, Referer: "https://example.com/"},
+			want: `10.1.2.3 - - [04/Mar/2023:15:16:17 +0000] "GET /hello?name=a%20b HTTP/1.1" 204 - "https://example.com/" "-"`,
+		},
+		{
+			name: "quotes escaped",
+			req:  HTTPRequest{RemoteIP: "10.1.2.3", Status: 404, UserAgent: `say "hi"`},
+			want: `10.1.2.3 - - [04/Mar/2023:15:16:17 +0000] "GET /hello?name=a%20b HTTP/1.1" 404 - "-" "say \"hi\""`,
+		},
+	}
+
+	for _, tt := range tests {
+		if got := combined(testRequest(), tt.req, testStarted); got != tt.want {
+			t.Errorf("%v:\n got %v\nwant %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLogfmt(t *testing.T) {
+	req := HTTPRequest{
+		RemoteIP:     "10.1.2.3",
+		Status:       200,
+		ResponseSize: 12,
+		Latency:      1500 * time.Microsecond,
+		UserAgent:    "curl/7.88.1",
+	}
+
+	want := `time=2023-03-04T15:16:17Z method=GET path="/hello?name=a%20b" protocol=HTTP/1.1 status=200 bytes=12 latency=1.5ms remote_ip=10.1.2.3 user_agent=curl/7.88.1 referer=""`
+	if got := logfmt(testRequest(), req, testStarted); got != want {
+		t.Errorf("\n got %v\nwant %v", got, want)
+	}
+
+	req.UserAgent = `Mozilla/5.0 (X11; "Linux")`
+	if got := logfmt(testRequest(), req, testStarted); !strings.Contains(got, `user_agent="Mozilla/5.0 (X11; \"Linux\")"`) {
+		t.Errorf("user agent not quoted: %v", got)
+	}
+
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: trace.TraceID{1},
+		SpanID:  trace.SpanID{2},
+	})
+	r := testRequest().WithContext(trace.ContextWithSpanContext(context.Background(), sc))
+	if got := logfmt(r, req, testStarted); !strings.HasSuffix(got, " trace_id="+sc.TraceID().String()+" span_id="+sc.SpanID().String()) {
+		t.Errorf("no trace: %v", got)
+	}
+}
+
+func TestAccessLogMiddleware(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		path    string
+		handler http.HandlerFunc
+		sample  float64
+		// part of the line logged, "" if nothing should be
+		want string
+	}{
+		{
+			name:    "nothing written",
+			format:  ACCESS_LOG_COMBINED,
+			path:    "/",
+			handler: func(w http.ResponseWriter, r *http.Request) {},
+			sample:  1,
+			want:    `"GET / HTTP/1.1" 200 -`,
+		},
+		{
+			name:    "body written",
+			format:  ACCESS_LOG_COMBINED,
+			path:    "/",
+			handler: func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("hello")) },
+			sample:  1,
+			want:    `"GET / HTTP/1.1" 200 5`,
+		},
+		{
+			name:    "status written",
+			format:  ACCESS_LOG_LOGFMT,
+			path:    "/",
+			handler: func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusTeapot) },
+			sample:  1,
+			want:    `status=418 bytes=0`,
+		},
+		{
+			name:    "nothing written logfmt",
+			format:  ACCESS_LOG_LOGFMT,
+			path:    "/",
+			handler: func(w http.ResponseWriter, r *http.Request) {},
+			sample:  1,
+			want:    `status=200 bytes=0`,
+		},
+		{
+			name:    "excluded",
+			format:  ACCESS_LOG_COMBINED,
+			path:    "/livez",
+			handler: func(w http.ResponseWriter, r *http.Request) {},
+			sample:  1,
+		},
+		{
+			name:    "sampled out",
+			format:  ACCESS_LOG_COMBINED,
+			path:    "/",
+			handler: func(w http.ResponseWriter, r *http.Request) {},
+			sample:  0,
+		},
+		{
+			name:    "server errors never sampled out",
+			format:  ACCESS_LOG_COMBINED,
+			path:    "/",
+			handler: func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusBadGateway) },
+			sample:  0,
+			want:    `"GET / HTTP/1.1" 502 -`,
+		},
+	}
+
+	for _, tt := range tests {
+		a, err := NewAccessLog(tt.format, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		a.out = &out
+		a.SampleRatio = tt.sample
+
+		a.Middleware(tt.handler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+		if tt.want == "" {
+			if out.Len() != 0 {
+				t.Errorf("%v: logged %q", tt.name, out.String())
+			}
+			continue
+		}
+		if !strings.Contains(out.String(), tt.want) {
+			t.Errorf("%v: logged %q, want %q", tt.name, out.String(), tt.want)
+		}
+	}
+}
+
+func TestAccessLogCloud(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	defer zap.ReplaceGlobals(zap.New(core))()
+
+	a, err := NewAccessLog(ACCESS_LOG_CLOUD, "project")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		status int
+		level  zapcore.Level
+	}{
+		{status: 0, level: zapcore.InfoLevel},
+		{status: http.StatusNotFound, level: zapcore.WarnLevel},
+		{status: http.StatusServiceUnavailable, level: zapcore.ErrorLevel},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			if tt.status != 0 {
+				w.WriteHeader(tt.status)
+			}
+		}
+		a.Middleware(http.HandlerFunc(handler)).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+		entries := logs.TakeAll()
+		if len(entries) != 1 {
+			t.Fatalf("status %v: got %v entries", tt.status, len(entries))
+		}
+		entry := entries[0]
+
+		want := tt.status
+		if want == 0 {
+			want = http.StatusOK
+		}
+		req, ok := entry.ContextMap()[KEY_HTTP_REQUEST].(map[string]interface{})
+		if !ok || req["status"] != want {
+			t.Errorf("status %v: %v = %v, want a status of %v", tt.status, KEY_HTTP_REQUEST, entry.ContextMap()[KEY_HTTP_REQUEST], want)
+		}
+		if entry.Level != tt.level {
+			t.Errorf("status %v: level = %v, want %v", tt.status, entry.Level, tt.level)
+		}
+	}
+}
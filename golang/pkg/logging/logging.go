@@ -0,0 +1,222 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Log formats that can be picked with LOG_FORMAT
+const (
+	// Cloud Logging's structured JSON, with severity, message and timestamp
+	// where it looks for them
+	FORMAT_CLOUD = "cloud"
+	// zap's production JSON
+	FORMAT_JSON = "json"
+
+	DEFAULT_FORMAT = FORMAT_CLOUD
+)
+
+// Fields Cloud Logging lifts out of a structured log entry to correlate it
+// with the trace it was written under
+const (
+	KEY_TRACE         = "logging.googleapis.com/trace"
+	KEY_SPAN_ID       = "logging.googleapis.com/spanId"
+	KEY_TRACE_SAMPLED = "logging.googleapis.com/trace_sampled"
+	KEY_HTTP_REQUEST  = "httpRequest"
+)
+
+// cloudSeverity maps zap levels onto Cloud Logging's severities
+func cloudSeverity(l zapcore.Level, enc zapcore.PrimitiveArrayEncoder) {
+	switch l {
+	case zapcore.DebugLevel:
+		enc.AppendString("DEBUG")
+	case zapcore.InfoLevel:
+		enc.AppendString("INFO")
+	case zapcore.WarnLevel:
+		enc.AppendString("WARNING")
+	case zapcore.ErrorLevel:
+		enc.AppendString("ERROR")
+	case zapcore.DPanicLevel:
+		enc.AppendString("CRITICAL")
+	case zapcore.PanicLevel:
+		enc.AppendString("ALERT")
+	case zapcore.FatalLevel:
+		enc.AppendString("EMERGENCY")
+	default:
+		enc.AppendString("DEFAULT")
+	}
+}
+
+// NewConfigFromEnv returns a production zap config at LOG_LEVEL, formatted as
+// LOG_FORMAT says
+func NewConfigFromEnv() (zap.Config, error) {
+	cfg := zap.NewProductionConfig()
+
+	a := zap.NewAtomicLevel()
+	a.UnmarshalText([]byte(os.Getenv("LOG_LEVEL")))
+	cfg.Level.SetLevel(a.Level())
+
+	format := DEFAULT_FORMAT
+	if fromEnv := os.Getenv("LOG_FORMAT"); fromEnv != "" {
+		format = fromEnv
+	}
+
+	switch format {
+	case FORMAT_CLOUD:
+		cfg.EncoderConfig.LevelKey = "severity"
+		cfg.EncoderConfig.EncodeLevel = cloudSeverity
+		cfg.EncoderConfig.MessageKey = "message"
+		cfg.EncoderConfig.TimeKey = "timestamp"
+		cfg.EncoderConfig.EncodeTime = zapcore.RFC3339NanoTimeEncoder
+	case FORMAT_JSON:
+	default:
+		return cfg, fmt.Errorf("invalid value for LOG_FORMAT: %v, use %v or %v", format, FORMAT_CLOUD, FORMAT_JSON)
+	}
+
+	return cfg, nil
+}
+
+// HTTPRequest is a request as Cloud Logging's httpRequest field describes
+// it. Whatever isn't known yet, like the status while the request is still
+// being served, is left out.
+type HTTPRequest struct {
+	Method       string
+	URL          string
+	RequestSize  int64
+	Status       int
+	ResponseSize int64
+	UserAgent    string
+	RemoteIP     string
+	Referer      string
+	Latency      time.Duration
+	Protocol     string
+}
+
+// NewHTTPRequest describes what's known of r before it's served
+func NewHTTPRequest(r *http.Request) HTTPRequest {
+	url := r.URL.String()
+	if r.URL.Host == "" {
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		url = fmt.Sprintf("%v://%v%v", scheme, r.Host, r.URL.RequestURI())
+	}
+
+	return HTTPRequest{
+		Method:      r.Method,
+		URL:         url,
+		RequestSize: r.ContentLength,
+		UserAgent:   r.UserAgent(),
+		RemoteIP:    RemoteIP(r),
+		Referer:     r.Referer(),
+		Protocol:    r.Proto,
+	}
+}
+
+func (h HTTPRequest) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("requestMethod", h.Method)
+	enc.AddString("requestUrl", h.URL)
+	if h.RequestSize > 0 {
+		enc.AddString("requestSize", fmt.Sprintf("%d", h.RequestSize))
+	}
+	if h.Status != 0 {
+		enc.AddInt("status", h.Status)
+	}
+	if h.ResponseSize > 0 {
+		enc.AddString("responseSize", fmt.Sprintf("%d", h.ResponseSize))
+	}
+	if h.UserAgent != "" {
+		enc.AddString("userAgent", h.UserAgent)
+	}
+	if h.RemoteIP != "" {
+		enc.AddString("remoteIp", h.RemoteIP)
+	}
+	if h.Referer != "" {
+		enc.AddString("referer", h.Referer)
+	}
+	if h.Latency > 0 {
+		enc.AddString("latency", fmt.Sprintf("%.9fs", h.Latency.Seconds()))
+	}
+	enc.AddString("protocol", h.Protocol)
+
+	return nil
+}
+
+// RemoteIP is the client's address, the first hop in X-Forwarded-For when
+// behind a load balancer
+func RemoteIP(r *http.Request) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// TraceFields are the fields that correlate a log entry with the span in
+// ctx, none if there isn't one
+func TraceFields(ctx context.Context, projectID string) []zap.Field {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return nil
+	}
+
+	traceName := sc.TraceID().String()
+	if projectID != "" {
+		traceName = fmt.Sprintf("projects/%v/traces/%v", projectID, traceName)
+	}
+
+	return []zap.Field{
+		zap.String(KEY_TRACE, traceName),
+		zap.String(KEY_SPAN_ID, sc.SpanID().String()),
+		zap.Bool(KEY_TRACE_SAMPLED, sc.IsSampled()),
+	}
+}
+
+type loggerKey struct{}
+
+// WithLogger returns a context that FromContext returns the logger from
+func WithLogger(ctx context.Context, logger *zap.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext returns the request's logger, or the global one outside of a
+// request
+func FromContext(ctx context.Context) *zap.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*zap.Logger); ok {
+		return logger
+	}
+
+	return zap.L()
+}
+
+// Middleware gives each request a logger that adds the request and the trace
+// it's served under to everything logged with it. It goes after
+// trace.Middleware, so that the request's span has been started.
+func Middleware(projectID string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+
+			fields := append(TraceFields(ctx, projectID),
+				zap.Object(KEY_HTTP_REQUEST, NewHTTPRequest(r)))
+			logger := zap.L().With(fields...)
+
+			next.ServeHTTP(w, r.WithContext(WithLogger(ctx, logger)))
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func spanContext(sampled bool) trace.SpanContext {
+	var flags trace.TraceFlags
+	if sampled {
+		flags = trace.FlagsSampled
+	}
+
+	return trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{0xab},
+		SpanID:     trace.SpanID{0xcd},
+		TraceFlags: flags,
+	})
+}
+
+func TestTraceFields(t *testing.T) {
+	sc := spanContext(true)
+	ctx := trace.ContextWithSpanContext(context.Background(), sc)
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		project string
+		want    map[string]interface{}
+	}{
+		{name: "no span", ctx: context.Background(), project: "p"},
+		{
+			name:    "with a project",
+			ctx:     ctx,
+			project: "p",
+			want: map[string]interface{}{
+				KEY_TRACE:         "projects/p/traces/" + sc.TraceID().String(),
+				KEY_SPAN_ID:       sc.SpanID().String(),
+				KEY_TRACE_SAMPLED: true,
+			},
+		},
+		{
+			name: "without a project",
+			ctx:  trace.ContextWithSpanContext(context.Background(), spanContext(false)),
+			want: map[string]interface{}{
+				KEY_TRACE:         sc.TraceID().String(),
+				KEY_SPAN_ID:       sc.SpanID().String(),
+				KEY_TRACE_SAMPLED: false,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		enc := zapcore.NewMapObjectEncoder()
+		for _, f := range TraceFields(tt.ctx, tt.project) {
+			f.AddTo(enc)
+		}
+
+		if len(enc.Fields) != len(tt.want) {
+			t.Errorf("%v: got %v, want %v", tt.name, enc.Fields, tt.want)
+			continue
+		}
+		for k, v := range tt.want {
+			if enc.Fields[k] != v {
+				t.Errorf("%v: %v = %v, want %v", tt.name, k, enc.Fields[k], v)
+			}
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	defer zap.ReplaceGlobals(zap.New(core))()
+
+	handler := Middleware("p")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		FromContext(r.Context()).Info("handling")
+	}))
+
+	r := httptest.NewRequest(http.MethodPost, "/echo?a=1", nil)
+	r.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.1")
+	r = r.WithContext(trace.ContextWithSpanContext(r.Context(), spanContext(true)))
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	entries := logs.TakeAll()
+	if len(entries) != 1 {
+		t.Fatalf("got %v entries", len(entries))
+	}
+	fields := entries[0].ContextMap()
+
+	if fields[KEY_TRACE] != "projects/p/traces/"+spanContext(true).TraceID().String() {
+		t.Errorf("%v = %v", KEY_TRACE, fields[KEY_TRACE])
+	}
+	req, _ := fields[KEY_HTTP_REQUEST].(map[string]interface{})
+	if req["requestMethod"] != http.MethodPost || req["requestUrl"] != "http://example.com/echo?a=1" || req["remoteIp"] != "203.0.113.7" {
+		t.Errorf("%v = %v", KEY_HTTP_REQUEST, req)
+	}
+	if _, found := req["status"]; found {
+		t.Errorf("status logged before the request was served: %v", req)
+	}
+
+	// outside of a request it's the global logger
+	if FromContext(context.Background()) != zap.L() {
+		t.Errorf("FromContext() without a logger isn't the global one")
+	}
+}
+
+func TestRemoteIP(t *testing.T) {
+	tests := []struct {
+		remoteAddr string
+		forwarded  string
+		want       string
+	}{
+		{remoteAddr: "10.1.2.3:4567", want: "10.1.2.3"},
+		{remoteAddr: "[::1]:4567", want: "::1"},
+		{remoteAddr: "unix", want: "unix"},
+		{remoteAddr: "10.1.2.3:4567", forwarded: " 203.0.113.7 , 10.0.0.1", want: "203.0.113.7"},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tt.remoteAddr
+		if tt.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+
+		if got := RemoteIP(r); got != tt.want {
+			t.Errorf("%v (forwarded for %q): got %v, want %v", tt.remoteAddr, tt.forwarded, got, tt.want)
+		}
+	}
+}
+
+func TestNewConfigFromEnv(t *testing.T) {
+	entry := zapcore.Entry{Level: zapcore.WarnLevel, Time: time.Date(2023, time.March, 4, 15, 16, 17, 0, time.UTC), Message: "hi"}
+
+	tests := []struct {
+		format  string
+		want    map[string]interface{}
+		wantErr bool
+	}{
+		{format: "", want: map[string]interface{}{"severity": "WARNING", "message": "hi", "timestamp": "2023-03-04T15:16:17Z"}},
+		{format: FORMAT_CLOUD, want: map[string]interface{}{"severity": "WARNING", "message": "hi"}},
+		{format: FORMAT_JSON, want: map[string]interface{}{"level": "warn", "msg": "hi"}},
+		{format: "xml", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			t.Setenv("LOG_FORMAT", tt.format)
+			t.Setenv("LOG_LEVEL", "warn")
+
+			cfg, err := NewConfigFromEnv()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if cfg.Level.Level() != zapcore.WarnLevel {
+				t.Errorf("level = %v, want warn", cfg.Level.Level())
+			}
+
+			buf, err := zapcore.NewJSONEncoder(cfg.EncoderConfig).EncodeEntry(entry, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got map[string]interface{}
+			if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			for k, v := range tt.want {
+				if got[k] != v {
+					t.Errorf("%v = %v, want %v in %s", k, got[k], v, buf.Bytes())
+				}
+			}
+		})
+	}
+}
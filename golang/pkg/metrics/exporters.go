@@ -0,0 +1,144 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	mexporter "github.com/GoogleCloudPlatform/opentelemetry-operations-go/exporter/metric"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/prometheus"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.uber.org/zap"
+
+	exporter "helloworld-http/pkg/exporter"
+)
+
+// Metric exporters that can be picked with METRICS_EXPORTERS
+const (
+	EXPORTER_PROMETHEUS      = "prometheus"
+	EXPORTER_OTLP_GRPC       = "otlp-grpc"
+	EXPORTER_OTLP_HTTP       = "otlp-http"
+	EXPORTER_CLOUDMONITORING = "cloudmonitoring"
+
+	DEFAULT_EXPORTERS       = EXPORTER_PROMETHEUS
+	DEFAULT_EXPORT_INTERVAL = 60 * time.Second
+)
+
+// ExporterConfig is what exporters are created with, on top of anything they
+// read from the environment themselves
+type ExporterConfig struct {
+	ProjectID string
+
+	// how often push exporters send metrics
+	Interval time.Duration
+}
+
+// ExporterFactory creates a reader that metrics are collected through, either
+// served when scraped or pushed on an interval
+type ExporterFactory func(ctx context.Context, cfg ExporterConfig) (sdkmetric.Reader, error)
+
+var registry = exporter.NewRegistry("metrics", map[string]ExporterFactory{
+	// served at /metrics from Registry
+	EXPORTER_PROMETHEUS: func(ctx context.Context, cfg ExporterConfig) (sdkmetric.Reader, error) {
+		return prometheus.New(prometheus.WithRegisterer(Registry), prometheus.WithoutScopeInfo())
+	},
+	// both OTLP exporters read the standard OTEL_EXPORTER_OTLP_* variables
+	EXPORTER_OTLP_GRPC: func(ctx context.Context, cfg ExporterConfig) (sdkmetric.Reader, error) {
+		exp, err := otlpmetricgrpc.New(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return sdkmetric.NewPeriodicReader(exp, sdkmetric.WithInterval(cfg.Interval)), nil
+	},
+	EXPORTER_OTLP_HTTP: func(ctx context.Context, cfg ExporterConfig) (sdkmetric.Reader, error) {
+		exp, err := otlpmetrichttp.New(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return sdkmetric.NewPeriodicReader(exp, sdkmetric.WithInterval(cfg.Interval)), nil
+	},
+	EXPORTER_CLOUDMONITORING: newCloudMonitoringReader,
+})
+
+// RegisterExporter adds, or replaces, an exporter that can be picked by name
+func RegisterExporter(name string, factory ExporterFactory) {
+	registry.Register(name, factory)
+}
+
+// ExporterNames lists the exporters that can be picked
+func ExporterNames() []string {
+	return registry.Names()
+}
+
+// newCloudMonitoringReader pushes metrics to Cloud Monitoring as
+// workload.googleapis.com/ custom metrics, on whichever monitored resource
+// the GCP resource detector found
+func newCloudMonitoringReader(ctx context.Context, cfg ExporterConfig) (sdkmetric.Reader, error) {
+	if cfg.ProjectID == "" {
+		return nil, fmt.Errorf("no project ID, set PROJECT_ID or run on GCP")
+	}
+
+	// Cloud Monitoring rejects points written more often than every 5s
+	interval := cfg.Interval
+	if interval < 5*time.Second {
+		interval = 5 * time.Second
+	}
+
+	exp, err := mexporter.New(mexporter.WithProjectID(cfg.ProjectID))
+	if err != nil {
+		return nil, err
+	}
+
+	return sdkmetric.NewPeriodicReader(exp, sdkmetric.WithInterval(interval)), nil
+}
+
+// newExporterConfig reads how often to push metrics from
+// METRICS_EXPORT_INTERVAL_SECS
+func newExporterConfig(projectID string) (ExporterConfig, error) {
+	cfg := ExporterConfig{
+		ProjectID: projectID,
+		Interval:  DEFAULT_EXPORT_INTERVAL,
+	}
+
+	if fromEnv := os.Getenv("METRICS_EXPORT_INTERVAL_SECS"); fromEnv != "" {
+		secs, err := strconv.Atoi(fromEnv)
+		if err != nil || secs <= 0 {
+			return cfg, fmt.Errorf("invalid value for METRICS_EXPORT_INTERVAL_SECS: %v", fromEnv)
+		}
+		cfg.Interval = time.Duration(secs) * time.Second
+	}
+
+	return cfg, nil
+}
+
+// namedReader is an exporter's reader and the name it was picked by
+type namedReader struct {
+	sdkmetric.Reader
+	name string
+}
+
+// newReaders creates the named exporters' readers, leaving out any that are
+// unknown or fail to start
+func newReaders(ctx context.Context, names []string, cfg ExporterConfig) []namedReader {
+	var readers []namedReader
+	registry.Create(names, func(name string, factory ExporterFactory) error {
+		reader, err := factory(ctx, cfg)
+		if err != nil {
+			return err
+		}
+
+		zap.S().Infof("Exporting metrics to %v", name)
+		readers = append(readers, namedReader{Reader: reader, name: name})
+		return nil
+	})
+
+	if len(readers) == 0 {
+		zap.S().Warnf("No metrics exporters configured from %v, metrics won't be exported", names)
+	}
+
+	return readers
+}
@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+)
+
+func TestNewExporterConfig(t *testing.T) {
+	tests := []struct {
+		interval string
+		want     time.Duration
+		wantErr  bool
+	}{
+		{interval: "", want: DEFAULT_EXPORT_INTERVAL},
+		{interval: "10", want: 10 * time.Second},
+		{interval: "0", wantErr: true},
+		{interval: "10s", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("METRICS_EXPORT_INTERVAL_SECS", tt.interval)
+
+		cfg, err := newExporterConfig("p")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: error = %v, want error %v", tt.interval, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && (cfg.Interval != tt.want || cfg.ProjectID != "p") {
+			t.Errorf("%q: got %+v, want an interval of %v", tt.interval, cfg, tt.want)
+		}
+	}
+}
+
+func TestNewReaders(t *testing.T) {
+	RegisterExporter("test-manual", func(ctx context.Context, cfg ExporterConfig) (sdkmetric.Reader, error) {
+		return sdkmetric.NewManualReader(), nil
+	})
+
+	tests := []struct {
+		name  string
+		names []string
+		cfg   ExporterConfig
+		want  []string
+	}{
+		{name: "registered", names: []string{"test-manual"}, want: []string{"test-manual"}},
+		{name: "unknown left out", names: []string{"statsd", "test-manual"}, want: []string{"test-manual"}},
+		{name: "cloudmonitoring needs a project", names: []string{EXPORTER_CLOUDMONITORING}},
+		{name: "none", names: nil},
+	}
+
+	for _, tt := range tests {
+		var got []string
+		for _, reader := range newReaders(context.Background(), tt.names, tt.cfg) {
+			got = append(got, reader.name)
+		}
+
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%v: created %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// what's scraped from /metrics keeps the names dashboards already use, plus
+// the semconv ones with their units
+func TestPrometheusExport(t *testing.T) {
+	readers := newReaders(context.Background(), []string{EXPORTER_PROMETHEUS}, ExporterConfig{})
+	if len(readers) != 1 {
+		t.Fatalf("got %v readers", len(readers))
+	}
+	mp := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(readers[0]),
+		sdkmetric.WithView(histogramView([]float64{0.5, 1}, METRIC_SERVER_DURATION, "http_response_time_seconds")...),
+		sdkmetric.WithView(histogramView(sizeBuckets, METRIC_SERVER_REQUEST_SIZE, METRIC_SERVER_RESPONSE_SIZE)...),
+	)
+	defer mp.Shutdown(context.Background())
+
+	in, err := newInstruments(mp.Meter(meterName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	savedRecorders := recorders
+	defer func() { recorders = savedRecorders }()
+	recorders = []*instruments{in}
+
+	Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).
+		ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	w := httptest.NewRecorder()
+	MetricsHandler()(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body, _ := ioutil.ReadAll(w.Body)
+
+	for _, want := range []string{
+		`http_server_request_duration_seconds_bucket{http_request_method="GET",http_response_status_class="2xx",http_route="unmatched",le="0.5"} 1`,
+		`http_response_time_seconds_bucket{method="GET",route="unmatched",le="1"} 1`,
+		`http_server_response_body_size_bytes_bucket{http_request_method="GET",http_response_status_class="2xx",http_route="unmatched",le="64"} 1`,
+		`http_server_active_requests{http_request_method="GET",url_scheme="http"} 0`,
+		`response_status_total{method="GET",route="unmatched",status="200"} 1`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("/metrics is missing %v", want)
+		}
+	}
+	if t.Failed() {
+		t.Logf("/metrics:\n%s", body)
+	}
+}
@@ -1,39 +1,172 @@
 package metrics
 
 import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
 	"net/http"
+	"os"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+
+	exporter "helloworld-http/pkg/exporter"
 )
-/*
-var totalRequests = prometheus.NewCounterVec(
-	prometheus.CounterOpts{
-		Name: "http_requests_total",
-		Help: "Number of get requests.",
-	},
-	[]string{"path"},
-)
-*/
-
-var responseStatus = prometheus.NewCounterVec(
-	prometheus.CounterOpts{
-		Name: "response_status",
-		Help: "Status of HTTP response",
-	},
-	[]string{"path", "status"},
-)
 
-var httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
-	Name: "http_response_time_seconds",
-	Help: "Duration of HTTP requests.",
-}, []string{"path"})
+const meterName = "helloworld-http"
+
+// Registry is what /metrics serves: the metrics recorded through the
+// MeterProvider, when the prometheus exporter is picked, and Go runtime and
+// process metrics
+var Registry = prometheus.NewRegistry()
+
+// MetricsConfig holds the MeterProviders the app's metrics are recorded with,
+// one per exporter
+type MetricsConfig struct {
+	MeterProviders []*sdkmetric.MeterProvider
+}
+
+// Shutdown pushes anything not yet sent by the push exporters
+func (m *MetricsConfig) Shutdown(ctx context.Context) {
+	for _, mp := range m.MeterProviders {
+		_ = mp.Shutdown(ctx)
+	}
+}
+
+// instruments are what the app records with on one meter
+type instruments struct {
+	// requests are labelled with the route that served them rather than their
+	// path, so that the number of series stays bounded
+	responseStatus metric.Int64Counter
+	httpDuration   metric.Float64Histogram
+
+	serverDuration       metric.Float64Histogram
+	serverActiveRequests metric.Int64UpDownCounter
+	serverRequestSize    metric.Int64Histogram
+	serverResponseSize   metric.Int64Histogram
+
+	faultsInjected metric.Int64Counter
+}
+
+// recorders start out empty, recording nothing, so that metrics can be
+// recorded from code that runs without InitMetrics, like a batch job
+var recorders []*instruments
+
+// newInstruments creates the instruments the app records with on meter. The
+// gauges are observed from what was last set when metrics are collected.
+func newInstruments(meter metric.Meter) (*instruments, error) {
+	in := &instruments{}
+	var err error
+
+	if in.responseStatus, err = meter.Int64Counter("response_status",
+		metric.WithDescription("Status of HTTP response")); err != nil {
+		return nil, err
+	}
+	if in.httpDuration, err = meter.Float64Histogram("http_response_time_seconds",
+		metric.WithDescription("Duration of HTTP requests."),
+		metric.WithUnit("s")); err != nil {
+		return nil, err
+	}
+
+	if in.serverDuration, err = meter.Float64Histogram(METRIC_SERVER_DURATION,
+		metric.WithDescription("Duration of HTTP requests"),
+		metric.WithUnit("s")); err != nil {
+		return nil, err
+	}
+	if in.serverActiveRequests, err = meter.Int64UpDownCounter(METRIC_SERVER_ACTIVE_REQUESTS,
+		metric.WithDescription("Number of HTTP requests currently being served"),
+		metric.WithUnit("{request}")); err != nil {
+		return nil, err
+	}
+	if in.serverRequestSize, err = meter.Int64Histogram(METRIC_SERVER_REQUEST_SIZE,
+		metric.WithDescription("Size of HTTP request bodies"),
+		metric.WithUnit("By")); err != nil {
+		return nil, err
+	}
+	if in.serverResponseSize, err = meter.Int64Histogram(METRIC_SERVER_RESPONSE_SIZE,
+		metric.WithDescription("Size of HTTP response bodies"),
+		metric.WithUnit("By")); err != nil {
+		return nil, err
+	}
+
+	if in.faultsInjected, err = meter.Int64Counter("faults.injected",
+		metric.WithDescription("Number of faults injected into responses")); err != nil {
+		return nil, err
+	}
+
+	if _, err = meter.Int64ObservableGauge("memload.allocated",
+		metric.WithDescription("Bytes of memory currently held by /memload"),
+		metric.WithUnit("By"),
+		metric.WithInt64Callback(func(ctx context.Context, o metric.Int64Observer) error {
+			o.Observe(gauges.get().memLoadBytes)
+			return nil
+		})); err != nil {
+		return nil, err
+	}
+	if _, err = meter.Int64ObservableGauge("memload.active",
+		metric.WithDescription("Number of /memload allocations not yet released"),
+		metric.WithInt64Callback(func(ctx context.Context, o metric.Int64Observer) error {
+			o.Observe(int64(gauges.get().memLoadActive))
+			return nil
+		})); err != nil {
+		return nil, err
+	}
+	if _, err = meter.Int64ObservableGauge("cpuload.active_jobs",
+		metric.WithDescription("Number of /busyloop CPU load jobs currently running"),
+		metric.WithInt64Callback(func(ctx context.Context, o metric.Int64Observer) error {
+			o.Observe(int64(gauges.get().cpuLoadJobs))
+			return nil
+		})); err != nil {
+		return nil, err
+	}
+	if _, err = meter.Float64ObservableGauge("cpuload.target_cores",
+		metric.WithDescription("Cores' worth of CPU the running /busyloop jobs are trying to use"),
+		metric.WithFloat64Callback(func(ctx context.Context, o metric.Float64Observer) error {
+			o.Observe(gauges.get().cpuLoadCores)
+			return nil
+		})); err != nil {
+		return nil, err
+	}
+
+	return in, nil
+}
+
+type gaugeValues struct {
+	memLoadActive int
+	memLoadBytes  int64
+	cpuLoadJobs   int
+	cpuLoadCores  float64
+}
+
+// gaugeState is what the load generators last reported, read when the
+// gauges are collected
+type gaugeState struct {
+	mu     sync.Mutex
+	values gaugeValues
+}
+
+func (g *gaugeState) get() gaugeValues {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.values
+}
+
+var gauges = &gaugeState{}
 
 type responseWriterInterceptor struct {
 	http.ResponseWriter
 	statusCode int
+	bytes      int64
 }
 
 func (w *responseWriterInterceptor) WriteHeader(statusCode int) {
@@ -41,53 +174,222 @@ func (w *responseWriterInterceptor) WriteHeader(statusCode int) {
 	w.ResponseWriter.WriteHeader(statusCode)
 }
 
-func NewResponseWriterInterceptor(w http.ResponseWriter) (*responseWriterInterceptor) {
+func (w *responseWriterInterceptor) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+
+	return n, err
+}
+
+// Flush and Hijack pass through to the real ResponseWriter so handlers
+// further down can still stream or take over the connection
+func (w *responseWriterInterceptor) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *responseWriterInterceptor) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("%T does not support hijacking", w.ResponseWriter)
+	}
+
+	return h.Hijack()
+}
+
+func NewResponseWriterInterceptor(w http.ResponseWriter) *responseWriterInterceptor {
 	return &responseWriterInterceptor{
 		ResponseWriter: w,
-		statusCode: http.StatusOK,
+		statusCode:     http.StatusOK,
+	}
+}
+
+// FaultInjected counts a fault of the given type, injected by a rule or a request header
+func FaultInjected(faultType string, source string) {
+	for _, in := range recorders {
+		in.faultsInjected.Add(context.Background(), 1, metric.WithAttributes(
+			attribute.String("type", faultType),
+			attribute.String("source", source)))
+	}
+}
+
+// SetMemLoad publishes the outstanding /memload allocations and their total size
+func SetMemLoad(active int, bytes int64) {
+	gauges.mu.Lock()
+	defer gauges.mu.Unlock()
+
+	gauges.values.memLoadActive = active
+	gauges.values.memLoadBytes = bytes
+}
+
+// SetCPULoad publishes the running /busyloop jobs and the CPU they're targeting
+func SetCPULoad(active int, cores float64) {
+	gauges.mu.Lock()
+	defer gauges.mu.Unlock()
+
+	gauges.values.cpuLoadJobs = active
+	gauges.values.cpuLoadCores = cores
+}
+
+var routes = NewRouteLabeler()
+
+// ParseBuckets reads histogram bucket upper bounds, in seconds, from a comma
+// separated list
+func ParseBuckets(s string) ([]float64, error) {
+	var buckets []float64
+	for _, b := range strings.Split(s, ",") {
+		if b = strings.TrimSpace(b); b == "" {
+			continue
+		}
+
+		bucket, err := strconv.ParseFloat(b, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bucket %q", b)
+		}
+		if len(buckets) > 0 && bucket <= buckets[len(buckets)-1] {
+			return nil, fmt.Errorf("buckets must be increasing, %v after %v", bucket, buckets[len(buckets)-1])
+		}
+		buckets = append(buckets, bucket)
+	}
+
+	if len(buckets) == 0 {
+		return nil, fmt.Errorf("no buckets")
+	}
+
+	return buckets, nil
+}
+
+// methodLabel keeps the method label to the standard methods, anything else
+// being OTHER
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	default:
+		return "OTHER"
+	}
+}
+
+// histogramView sets the buckets of the named histograms
+func histogramView(buckets []float64, names ...string) []sdkmetric.View {
+	var views []sdkmetric.View
+	for _, name := range names {
+		views = append(views, sdkmetric.NewView(
+			sdkmetric.Instrument{Name: name},
+			sdkmetric.Stream{Aggregation: sdkmetric.AggregationExplicitBucketHistogram{Boundaries: buckets}},
+		))
 	}
+
+	return views
 }
 
-func InitMetrics() {
-	//prometheus.Register(totalRequests)
-	prometheus.Register(responseStatus)
-	prometheus.Register(httpDuration)
+// InitMetrics records the app's metrics with a MeterProvider that exports
+// to the exporters named in METRICS_EXPORTERS, the prometheus one served at
+// /metrics by default. Request durations are bucketed by METRICS_BUCKETS,
+// and routes labelled as NewRouteLabelerFromEnv says.
+func InitMetrics(ctx context.Context, res *resource.Resource, projectID string) (*MetricsConfig, error) {
+	durationBuckets := prometheus.DefBuckets
+	if fromEnv := os.Getenv("METRICS_BUCKETS"); fromEnv != "" {
+		var err error
+		if durationBuckets, err = ParseBuckets(fromEnv); err != nil {
+			return nil, fmt.Errorf("invalid value for METRICS_BUCKETS: %v", err)
+		}
+	}
+
+	labeler, err := NewRouteLabelerFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	routes = labeler
+
+	cfg, err := newExporterConfig(projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	names := exporter.NamesFromEnv("METRICS_EXPORTERS", DEFAULT_EXPORTERS)
+
+	config := &MetricsConfig{}
+	for _, reader := range newReaders(ctx, names, cfg) {
+		mp := sdkmetric.NewMeterProvider(
+			sdkmetric.WithResource(res),
+			sdkmetric.WithReader(reader),
+			sdkmetric.WithView(histogramView(durationBuckets, METRIC_SERVER_DURATION, "http_response_time_seconds")...),
+			sdkmetric.WithView(histogramView(sizeBuckets, METRIC_SERVER_REQUEST_SIZE, METRIC_SERVER_RESPONSE_SIZE)...),
+		)
+		config.MeterProviders = append(config.MeterProviders, mp)
+
+		in, err := newInstruments(mp.Meter(meterName))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create instruments: %v", err)
+		}
+		recorders = append(recorders, in)
+	}
+
+	// how the Go runtime and the process are doing, alongside the requests
+	Registry.MustRegister(prometheus.NewGoCollector())
+	Registry.MustRegister(prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+
+	return config, nil
 }
 
 func Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		path := r.RequestURI
-
+		ctx := r.Context()
 		rw := NewResponseWriterInterceptor(w)
+		method := methodLabel(r.Method)
+
+		activeAttrs := []attribute.KeyValue{
+			attribute.String(ATTR_METHOD, method),
+			attribute.String(ATTR_SCHEME, scheme(r)),
+		}
+		for _, in := range recorders {
+			in.serverActiveRequests.Add(ctx, 1, metric.WithAttributes(activeAttrs...))
+		}
+		defer func() {
+			for _, in := range recorders {
+				in.serverActiveRequests.Add(ctx, -1, metric.WithAttributes(activeAttrs...))
+			}
+		}()
+
+		body := &countingBody{ReadCloser: r.Body}
+		if r.Body != nil {
+			r.Body = body
+		}
 
 		// record time
-		timer := prometheus.NewTimer(httpDuration.WithLabelValues(path))
-		defer timer.ObserveDuration()
+		started := time.Now()
 		next.ServeHTTP(rw, r)
+		elapsed := time.Since(started).Seconds()
 
-		// record status codes
+		// the route is only known once the router has matched the request
+		route := routes.Label(r)
 		statusCode := rw.statusCode
-		responseStatus.WithLabelValues(path, strconv.Itoa(statusCode)).Inc()
 
-		// increment total requests
-		//totalRequests.WithLabelValues(path).Inc()
+		attrs := []attribute.KeyValue{
+			attribute.String(ATTR_METHOD, method),
+			attribute.String(ATTR_ROUTE, route),
+		}
+		statusAttrs := append(attrs[:len(attrs):len(attrs)], attribute.String(ATTR_STATUS_CLASS, statusClass(statusCode)))
 
-	})
-}
+		for _, in := range recorders {
+			in.httpDuration.Record(ctx, elapsed, metric.WithAttributes(
+				attribute.String("method", method),
+				attribute.String("route", route)))
+			in.responseStatus.Add(ctx, 1, metric.WithAttributes(
+				attribute.String("method", method),
+				attribute.String("route", route),
+				attribute.String("status", strconv.Itoa(statusCode))))
 
-func MetricsHandler() (http.HandlerFunc) {
-	// Stats exporter: Prometheus
-	/*
-	pe, err := prometheus.NewExporter(prometheus.Options{
-		Namespace: "helloweb",
+			in.serverRequestSize.Record(ctx, body.bytes, metric.WithAttributes(attrs...))
+			in.serverDuration.Record(ctx, elapsed, metric.WithAttributes(statusAttrs...))
+			in.serverResponseSize.Record(ctx, rw.bytes, metric.WithAttributes(statusAttrs...))
+		}
 	})
-	if err != nil {
-		zap.S().Fatalf("Failed to create the Prometheus stats exporter: %v", err)
-	}
-
-	view.RegisterExporter(pe)
-	*/
-
-	return promhttp.Handler().ServeHTTP
+}
 
+func MetricsHandler() http.HandlerFunc {
+	return promhttp.InstrumentMetricHandler(Registry, promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})).ServeHTTP
 }
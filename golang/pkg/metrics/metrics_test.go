@@ -0,0 +1,258 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	chi "github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// point is one series of a collected metric: a histogram's count and sum,
+// or a counter's value
+type point struct {
+	attrs map[string]string
+	count uint64
+	value float64
+}
+
+func attrMap(set attribute.Set) map[string]string {
+	attrs := make(map[string]string, set.Len())
+	for _, kv := range set.ToSlice() {
+		attrs[string(kv.Key)] = kv.Value.Emit()
+	}
+
+	return attrs
+}
+
+// recordTo makes the app record to a manual reader, instead of whatever
+// InitMetrics set up, for the rest of the test
+func recordTo(t *testing.T) sdkmetric.Reader {
+	t.Helper()
+
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	in, err := newInstruments(mp.Meter(meterName))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	savedRecorders, savedRoutes := recorders, routes
+	t.Cleanup(func() { recorders, routes = savedRecorders, savedRoutes })
+	recorders = []*instruments{in}
+	routes = NewRouteLabeler()
+
+	return reader
+}
+
+// collect reads every metric recorded so far, by name
+func collect(t *testing.T, reader sdkmetric.Reader) map[string][]point {
+	t.Helper()
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+
+	collected := make(map[string][]point)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			var points []point
+			switch data := m.Data.(type) {
+			case metricdata.Histogram[float64]:
+				for _, dp := range data.DataPoints {
+					points = append(points, point{attrs: attrMap(dp.Attributes), count: dp.Count, value: dp.Sum})
+				}
+			case metricdata.Histogram[int64]:
+				for _, dp := range data.DataPoints {
+					points = append(points, point{attrs: attrMap(dp.Attributes), count: dp.Count, value: float64(dp.Sum)})
+				}
+			case metricdata.Sum[int64]:
+				for _, dp := range data.DataPoints {
+					points = append(points, point{attrs: attrMap(dp.Attributes), value: float64(dp.Value)})
+				}
+			case metricdata.Gauge[int64]:
+				for _, dp := range data.DataPoints {
+					points = append(points, point{attrs: attrMap(dp.Attributes), value: float64(dp.Value)})
+				}
+			case metricdata.Gauge[float64]:
+				for _, dp := range data.DataPoints {
+					points = append(points, point{attrs: attrMap(dp.Attributes), value: dp.Value})
+				}
+			}
+			collected[m.Name] = points
+		}
+	}
+
+	return collected
+}
+
+func TestMiddleware(t *testing.T) {
+	reader := recordTo(t)
+
+	r := chi.NewRouter()
+	r.Use(Middleware)
+
+	// the request is in flight while it's being served
+	r.Post("/echo", func(w http.ResponseWriter, r *http.Request) {
+		active := collect(t, reader)[METRIC_SERVER_ACTIVE_REQUESTS]
+		if len(active) != 1 || active[0].value != 1 {
+			t.Errorf("%v while serving = %+v, want 1", METRIC_SERVER_ACTIVE_REQUESTS, active)
+		}
+
+		buf := make([]byte, 1024)
+		for {
+			if _, err := r.Body.Read(buf); err != nil {
+				break
+			}
+		}
+		w.Write([]byte("hello"))
+	})
+	r.Get("/missing/{id}", func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/echo", strings.NewReader(strings.Repeat("a", 100))))
+	for _, path := range []string{"/missing/1", "/missing/2"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("PURGE", "/echo", nil))
+
+	collected := collect(t, reader)
+
+	tests := []struct {
+		metric string
+		attrs  map[string]string
+		count  uint64
+		value  float64
+	}{
+		{
+			metric: METRIC_SERVER_DURATION,
+			attrs:  map[string]string{ATTR_METHOD: "POST", ATTR_ROUTE: "/echo", ATTR_STATUS_CLASS: "2xx"},
+			count:  1,
+		},
+		{
+			metric: METRIC_SERVER_DURATION,
+			attrs:  map[string]string{ATTR_METHOD: "GET", ATTR_ROUTE: "/missing/{id}", ATTR_STATUS_CLASS: "4xx"},
+			count:  2,
+		},
+		{
+			metric: METRIC_SERVER_DURATION,
+			attrs:  map[string]string{ATTR_METHOD: "OTHER", ATTR_ROUTE: ROUTE_UNMATCHED, ATTR_STATUS_CLASS: "4xx"},
+			count:  1,
+		},
+		{
+			metric: METRIC_SERVER_REQUEST_SIZE,
+			attrs:  map[string]string{ATTR_METHOD: "POST", ATTR_ROUTE: "/echo"},
+			count:  1,
+			value:  100,
+		},
+		{
+			metric: METRIC_SERVER_RESPONSE_SIZE,
+			attrs:  map[string]string{ATTR_METHOD: "POST", ATTR_ROUTE: "/echo", ATTR_STATUS_CLASS: "2xx"},
+			count:  1,
+			value:  5,
+		},
+		{
+			metric: METRIC_SERVER_ACTIVE_REQUESTS,
+			attrs:  map[string]string{ATTR_METHOD: "POST", ATTR_SCHEME: "http"},
+			value:  0,
+		},
+		{
+			metric: "response_status",
+			attrs:  map[string]string{"method": "GET", "route": "/missing/{id}", "status": "404"},
+			value:  2,
+		},
+	}
+
+	for _, tt := range tests {
+		found := false
+		for _, p := range collected[tt.metric] {
+			if !reflect.DeepEqual(p.attrs, tt.attrs) {
+				continue
+			}
+			found = true
+
+			if p.count != tt.count || (tt.metric != METRIC_SERVER_DURATION && p.value != tt.value) {
+				t.Errorf("%v %v: got count %v, value %v, want %v and %v", tt.metric, tt.attrs, p.count, p.value, tt.count, tt.value)
+			}
+		}
+		if !found {
+			t.Errorf("%v: no series with %v in %+v", tt.metric, tt.attrs, collected[tt.metric])
+		}
+	}
+}
+
+func TestGauges(t *testing.T) {
+	reader := recordTo(t)
+	defer SetMemLoad(0, 0)
+	defer SetCPULoad(0, 0)
+
+	SetMemLoad(2, 3<<20)
+	SetCPULoad(1, 0.5)
+	FaultInjected("delay", "rule")
+
+	collected := collect(t, reader)
+	want := map[string]float64{
+		"memload.allocated":    3 << 20,
+		"memload.active":       2,
+		"cpuload.active_jobs":  1,
+		"cpuload.target_cores": 0.5,
+		"faults.injected":      1,
+	}
+	for name, value := range want {
+		if points := collected[name]; len(points) != 1 || points[0].value != value {
+			t.Errorf("%v = %+v, want %v", name, points, value)
+		}
+	}
+}
+
+func TestStatusClass(t *testing.T) {
+	tests := map[int]string{
+		100: "1xx",
+		200: "2xx",
+		304: "3xx",
+		429: "4xx",
+		599: "5xx",
+		0:   "unknown",
+		600: "unknown",
+	}
+
+	for code, want := range tests {
+		if got := statusClass(code); got != want {
+			t.Errorf("statusClass(%v) = %v, want %v", code, got, want)
+		}
+	}
+}
+
+func TestParseBuckets(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []float64
+		wantErr bool
+	}{
+		{in: "0.1,0.5, 1,", want: []float64{0.1, 0.5, 1}},
+		{in: "1", want: []float64{1}},
+		{in: "", wantErr: true},
+		{in: "0.1,fast", wantErr: true},
+		{in: "1,0.5", wantErr: true},
+		{in: "1,1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseBuckets(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseBuckets(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseBuckets(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
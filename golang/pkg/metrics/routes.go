@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	chi "github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+const (
+	// requests that didn't match any route, e.g. a 404 or 405
+	ROUTE_UNMATCHED = "unmatched"
+	// requests for routes past the cap on how many are labelled separately
+	ROUTE_OVERFLOW = "other"
+
+	DEFAULT_MAX_ROUTES = 100
+)
+
+// RouteLabeler turns a request into the route label of its metrics, which
+// has to come from a bounded set so that requests for arbitrary paths don't
+// create a new series each
+type RouteLabeler struct {
+	// path.Match patterns of request paths to label with the pattern rather
+	// than the route, to tell apart paths a wildcard route like /* serves
+	Paths []string
+
+	// how many distinct routes to label, the rest are ROUTE_OVERFLOW
+	MaxRoutes int
+
+	mu         sync.Mutex
+	seen       map[string]bool
+	overflowed bool
+}
+
+// NewRouteLabeler labels by route, up to DEFAULT_MAX_ROUTES of them
+func NewRouteLabeler() *RouteLabeler {
+	return &RouteLabeler{
+		MaxRoutes: DEFAULT_MAX_ROUTES,
+		seen:      make(map[string]bool),
+	}
+}
+
+// NewRouteLabelerFromEnv configures a RouteLabeler from METRICS_PATHS, a comma
+// separated list of path patterns, and METRICS_MAX_ROUTES
+func NewRouteLabelerFromEnv() (*RouteLabeler, error) {
+	l := NewRouteLabeler()
+
+	for _, pattern := range strings.Split(os.Getenv("METRICS_PATHS"), ",") {
+		if pattern = strings.TrimSpace(pattern); pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, "/"); err != nil {
+			return nil, fmt.Errorf("invalid pattern in METRICS_PATHS: %q", pattern)
+		}
+		l.Paths = append(l.Paths, pattern)
+	}
+
+	if fromEnv := os.Getenv("METRICS_MAX_ROUTES"); fromEnv != "" {
+		max, err := strconv.Atoi(fromEnv)
+		if err != nil || max <= 0 {
+			return nil, fmt.Errorf("invalid value for METRICS_MAX_ROUTES: %v", fromEnv)
+		}
+		l.MaxRoutes = max
+	}
+
+	return l, nil
+}
+
+// normalize picks the label for a request that has been routed: the first
+// path pattern it matches, or else the pattern of the route that served it
+func (l *RouteLabeler) normalize(r *http.Request) string {
+	for _, pattern := range l.Paths {
+		if matched, _ := path.Match(pattern, r.URL.Path); matched {
+			return pattern
+		}
+	}
+
+	if rctx := chi.RouteContext(r.Context()); rctx != nil {
+		if route := rctx.RoutePattern(); route != "" {
+			return route
+		}
+	}
+
+	return ROUTE_UNMATCHED
+}
+
+// Label returns the route label for a request that has been routed
+func (l *RouteLabeler) Label(r *http.Request) string {
+	route := l.normalize(r)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.seen[route] {
+		return route
+	}
+	if len(l.seen) >= l.MaxRoutes {
+		if !l.overflowed {
+			l.overflowed = true
+			zap.S().Warnf("More than %d routes seen, labelling the rest of them %q in metrics", l.MaxRoutes, ROUTE_OVERFLOW)
+		}
+		return ROUTE_OVERFLOW
+	}
+	l.seen[route] = true
+
+	return route
+}
@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	chi "github.com/go-chi/chi/v5"
+)
+
+// labelRoutes serves path through a router like the app's, returning the
+// label the request was given once it had been routed
+func labelRoutes(l *RouteLabeler, method string, path string) string {
+	var label string
+
+	r := chi.NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r)
+			label = l.Label(r)
+		})
+	})
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	r.Post("/busyloop", ok)
+	r.Get("/echo/*", ok)
+	r.Get("/memload/{id}", ok)
+	r.Get("/*", ok)
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, path, nil))
+
+	return label
+}
+
+func TestRouteLabel(t *testing.T) {
+	l := NewRouteLabeler()
+	l.Paths = []string{"/hello/*"}
+
+	tests := []struct {
+		method string
+		path   string
+		want   string
+	}{
+		{method: http.MethodPost, path: "/busyloop", want: "/busyloop"},
+		{method: http.MethodGet, path: "/memload/1234", want: "/memload/{id}"},
+		{method: http.MethodGet, path: "/echo/a/b/c", want: "/echo/*"},
+		{method: http.MethodGet, path: "/anything/else", want: "/*"},
+		{method: http.MethodGet, path: "/hello/there", want: "/hello/*"},
+		{method: http.MethodDelete, path: "/busyloop", want: ROUTE_UNMATCHED},
+	}
+
+	for _, tt := range tests {
+		if got := labelRoutes(l, tt.method, tt.path); got != tt.want {
+			t.Errorf("%v %v: label = %v, want %v", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestRouteLabelCap(t *testing.T) {
+	l := NewRouteLabeler()
+	l.MaxRoutes = 2
+	for i := 0; i < 5; i++ {
+		l.Paths = append(l.Paths, fmt.Sprintf("/p%d", i))
+	}
+
+	want := []string{"/p0", "/p1", ROUTE_OVERFLOW, ROUTE_OVERFLOW, ROUTE_OVERFLOW}
+	for i, w := range want {
+		if got := labelRoutes(l, http.MethodGet, fmt.Sprintf("/p%d", i)); got != w {
+			t.Errorf("/p%d: label = %v, want %v", i, got, w)
+		}
+	}
+
+	// routes seen before the cap was hit keep their label
+	if got := labelRoutes(l, http.MethodGet, "/p1"); got != "/p1" {
+		t.Errorf("/p1 again: label = %v", got)
+	}
+}
+
+func TestNewRouteLabelerFromEnv(t *testing.T) {
+	tests := []struct {
+		name      string
+		paths     string
+		maxRoutes string
+		wantPaths int
+		wantMax   int
+		wantErr   bool
+	}{
+		{name: "defaults", wantMax: DEFAULT_MAX_ROUTES},
+		{name: "paths", paths: "/a/*, ,/b", wantPaths: 2, wantMax: DEFAULT_MAX_ROUTES},
+		{name: "max routes", maxRoutes: "10", wantMax: 10},
+		{name: "bad pattern", paths: "/[", wantErr: true},
+		{name: "zero max routes", maxRoutes: "0", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("METRICS_PATHS", tt.paths)
+			t.Setenv("METRICS_MAX_ROUTES", tt.maxRoutes)
+
+			l, err := NewRouteLabelerFromEnv()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(l.Paths) != tt.wantPaths || l.MaxRoutes != tt.wantMax {
+				t.Errorf("paths %q, max routes %v, want %v paths and %v", l.Paths, l.MaxRoutes, tt.wantPaths, tt.wantMax)
+			}
+		})
+	}
+}
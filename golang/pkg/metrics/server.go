@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// HTTP server metrics and their attributes, named after OpenTelemetry's HTTP
+// semantic conventions. Scraped from /metrics, the dots become underscores
+// and the sizes and durations get _bytes and _seconds suffixes.
+const (
+	METRIC_SERVER_DURATION        = "http.server.request.duration"
+	METRIC_SERVER_ACTIVE_REQUESTS = "http.server.active_requests"
+	METRIC_SERVER_REQUEST_SIZE    = "http.server.request.body.size"
+	METRIC_SERVER_RESPONSE_SIZE   = "http.server.response.body.size"
+
+	ATTR_METHOD       = "http.request.method"
+	ATTR_ROUTE        = "http.route"
+	ATTR_STATUS_CLASS = "http.response.status_class"
+	ATTR_SCHEME       = "url.scheme"
+)
+
+// sizes from 64 bytes up to 16MiB
+var sizeBuckets = []float64{64, 256, 1024, 4096, 16384, 65536, 262144, 1048576, 4194304, 16777216}
+
+// statusClass buckets a status code into 1xx to 5xx
+func statusClass(code int) string {
+	if code < 100 || code > 599 {
+		return "unknown"
+	}
+
+	return strconv.Itoa(code/100) + "xx"
+}
+
+func scheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+
+	return "http"
+}
+
+// countingBody counts the bytes of the request body the handler reads, which
+// works whether or not the request said how long it was
+type countingBody struct {
+	io.ReadCloser
+	bytes int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.bytes += int64(n)
+
+	return n, err
+}
@@ -0,0 +1,280 @@
+package platform
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+
+	gcp "helloworld-http/pkg/gcp"
+)
+
+// Platforms the detector can tell apart
+const (
+	PLATFORM_CF      = "cf"
+	PLATFORM_RUN_JOB = "run-job"
+	PLATFORM_RUN     = "run"
+	PLATFORM_GAE     = "gae"
+	PLATFORM_GKE     = "gke"
+	PLATFORM_K8S     = "k8s"
+	PLATFORM_GCE     = "gce"
+	PLATFORM_UNKNOWN = "unknown"
+)
+
+// Confidence is how sure a detector is of its platform
+type Confidence int
+
+const (
+	CONFIDENCE_NONE Confidence = iota
+	// a heuristic, like the OAuth scopes the default service account has
+	CONFIDENCE_LOW
+	// some of the platform's documented signals, but not all of them
+	CONFIDENCE_MEDIUM
+	// the documented signals the platform always sets
+	CONFIDENCE_HIGH
+)
+
+func (c Confidence) String() string {
+	switch c {
+	case CONFIDENCE_LOW:
+		return "low"
+	case CONFIDENCE_MEDIUM:
+		return "medium"
+	case CONFIDENCE_HIGH:
+		return "high"
+	default:
+		return "none"
+	}
+}
+
+// Candidate is a platform we might be running on, with the signals that
+// point at it
+type Candidate struct {
+	Platform   string
+	Confidence Confidence
+	Signals    []string
+}
+
+// Detection is every platform that matched, most likely first
+type Detection struct {
+	Candidates []Candidate
+}
+
+// Best returns the most likely platform, or PLATFORM_UNKNOWN if nothing matched
+func (d Detection) Best() Candidate {
+	if len(d.Candidates) == 0 {
+		return Candidate{Platform: PLATFORM_UNKNOWN, Confidence: CONFIDENCE_NONE}
+	}
+
+	return d.Candidates[0]
+}
+
+// signals collects the evidence for one platform
+type signals struct {
+	lookup     func(string) string
+	fileExists func(string) bool
+	metadata   map[string]interface{}
+	found      []string
+}
+
+// FileExists is what Detect should be given to look for files outside tests
+func FileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// env records and reports whether all the environment variables are set
+func (s *signals) env(names ...string) bool {
+	for _, name := range names {
+		if s.lookup(name) == "" {
+			return false
+		}
+	}
+	for _, name := range names {
+		s.found = append(s.found, "env:"+name)
+	}
+
+	return true
+}
+
+// file records and reports whether the file exists
+func (s *signals) file(path string) bool {
+	if !s.fileExists(path) {
+		return false
+	}
+	s.found = append(s.found, "file:"+path)
+
+	return true
+}
+
+// metadataAttr records and reports whether any of the metadata paths exist
+func (s *signals) metadataAttr(paths ...string) bool {
+	for _, path := range paths {
+		_, err := gcp.GetMetaDataStr(path, s.metadata)
+		if err == nil {
+			s.found = append(s.found, "metadata:"+path)
+			return true
+		}
+		if !errors.Is(err, gcp.ErrNotFound) {
+			zap.S().Warnf("Unexpected metadata: %v", err)
+		}
+	}
+
+	return false
+}
+
+// scope records and reports whether the default service account has a scope
+func (s *signals) scope(scope string) bool {
+	scopes, err := gcp.GetMetaDataArr("instance/serviceAccounts/default/scopes", s.metadata)
+	if err != nil {
+		return false
+	}
+
+	for _, v := range scopes {
+		if v == scope {
+			s.found = append(s.found, "scope:"+strings.TrimPrefix(scope, "https://www.googleapis.com/auth/"))
+			return true
+		}
+	}
+
+	return false
+}
+
+// detectors in order of specificity, which breaks ties between candidates
+// with the same confidence: a Cloud Run service on GKE is also a pod, and a
+// GKE node is also a GCE VM
+var detectors = []struct {
+	platform string
+	detect   func(s *signals) Confidence
+}{
+	{PLATFORM_CF, detectCf},
+	{PLATFORM_RUN_JOB, detectRunJob},
+	{PLATFORM_RUN, detectRun},
+	{PLATFORM_GAE, detectGae},
+	{PLATFORM_GKE, detectGke},
+	{PLATFORM_K8S, detectK8s},
+	{PLATFORM_GCE, detectGce},
+}
+
+// 1st gen functions set FUNCTION_NAME on the older runtimes and
+// FUNCTION_TARGET on the newer ones, as do 2nd gen functions
+func detectCf(s *signals) Confidence {
+	switch {
+	case s.env("FUNCTION_TARGET"), s.env("FUNCTION_NAME"), s.env("X_GOOGLE_FUNCTION_NAME"):
+		s.env("FUNCTION_SIGNATURE_TYPE")
+		return CONFIDENCE_HIGH
+	default:
+		return CONFIDENCE_NONE
+	}
+}
+
+// Cloud Run jobs set CLOUD_RUN_JOB and CLOUD_RUN_EXECUTION on every task
+func detectRunJob(s *signals) Confidence {
+	switch {
+	case s.env("CLOUD_RUN_JOB", "CLOUD_RUN_EXECUTION"):
+		s.env("CLOUD_RUN_TASK_INDEX")
+		return CONFIDENCE_HIGH
+	case s.env("CLOUD_RUN_JOB"):
+		return CONFIDENCE_MEDIUM
+	default:
+		return CONFIDENCE_NONE
+	}
+}
+
+// Cloud Run services set K_SERVICE, K_REVISION and K_CONFIGURATION. So does
+// Knative, which runs on Kubernetes.
+func detectRun(s *signals) Confidence {
+	switch {
+	case s.env("K_SERVICE", "K_REVISION", "K_CONFIGURATION"):
+		if s.lookup("KUBERNETES_SERVICE_HOST") != "" {
+			return CONFIDENCE_MEDIUM
+		}
+		return CONFIDENCE_HIGH
+	case s.env("K_SERVICE"):
+		return CONFIDENCE_MEDIUM
+	case s.scope("https://www.googleapis.com/auth/calendar"):
+		// what this app used to go by, only right for the way we deployed it
+		return CONFIDENCE_LOW
+	default:
+		return CONFIDENCE_NONE
+	}
+}
+
+// App Engine standard and flexible both set GAE_APPLICATION, GAE_SERVICE and
+// GAE_VERSION
+func detectGae(s *signals) Confidence {
+	switch {
+	case s.env("GAE_APPLICATION", "GAE_SERVICE", "GAE_VERSION"):
+		s.env("GAE_ENV")
+		return CONFIDENCE_HIGH
+	case s.env("GAE_SERVICE"), s.env("GAE_INSTANCE"):
+		return CONFIDENCE_MEDIUM
+	case s.scope("https://www.googleapis.com/auth/appengine.apis"):
+		return CONFIDENCE_LOW
+	default:
+		return CONFIDENCE_NONE
+	}
+}
+
+// GKE nodes have the cluster name in their instance attributes, whether
+// we're in a pod or a process on the node. It ties with the node's own GCE
+// signals, and wins as the more specific.
+func detectGke(s *signals) Confidence {
+	if !s.metadataAttr("instance/attributes/cluster-name", "instance/attributes/clusterName") {
+		return CONFIDENCE_NONE
+	}
+	s.env("KUBERNETES_SERVICE_HOST")
+
+	return CONFIDENCE_HIGH
+}
+
+// every pod gets KUBERNETES_SERVICE_HOST, unless the service links are
+// turned off, and the downward API variables if the manifest asks for them
+func detectK8s(s *signals) Confidence {
+	switch {
+	case s.env("KUBERNETES_SERVICE_HOST"):
+		return CONFIDENCE_HIGH
+	case s.env("K8S_POD_NAME"):
+		return CONFIDENCE_MEDIUM
+	case s.file("/var/run/secrets/kubernetes.io/serviceaccount"):
+		return CONFIDENCE_MEDIUM
+	default:
+		return CONFIDENCE_NONE
+	}
+}
+
+// VMs have a machine type, the serverless platforms' metadata servers don't
+func detectGce(s *signals) Confidence {
+	switch {
+	case s.metadataAttr("instance/machineType"):
+		return CONFIDENCE_HIGH
+	case s.metadataAttr("instance/zone"):
+		return CONFIDENCE_LOW
+	default:
+		return CONFIDENCE_NONE
+	}
+}
+
+// Detect runs every detector against the environment, files and metadata
+// tree, ranking the platforms that matched by confidence, then by specificity
+func Detect(env func(string) string, fileExists func(string) bool, metadata map[string]interface{}) Detection {
+	var d Detection
+	for _, detector := range detectors {
+		s := &signals{lookup: env, fileExists: fileExists, metadata: metadata}
+		if confidence := detector.detect(s); confidence > CONFIDENCE_NONE {
+			d.Candidates = append(d.Candidates, Candidate{
+				Platform:   detector.platform,
+				Confidence: confidence,
+				Signals:    s.found,
+			})
+		}
+	}
+
+	sort.SliceStable(d.Candidates, func(i, j int) bool {
+		return d.Candidates[i].Confidence > d.Candidates[j].Confidence
+	})
+
+	return d
+}
@@ -0,0 +1,182 @@
+package platform
+
+import (
+	"testing"
+
+	gcp "helloworld-http/pkg/gcp"
+)
+
+func testEnv(vars map[string]string) func(string) string {
+	return func(name string) string {
+		return vars[name]
+	}
+}
+
+func testFiles(paths ...string) func(string) bool {
+	return func(path string) bool {
+		for _, p := range paths {
+			if p == path {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name       string
+		env        map[string]string
+		files      []string
+		metadata   string
+		want       string
+		confidence Confidence
+		// other platforms that should be candidates too
+		also []string
+	}{
+		{
+			name:       "nothing",
+			want:       PLATFORM_UNKNOWN,
+			confidence: CONFIDENCE_NONE,
+		},
+		{
+			name:       "gce",
+			metadata:   gcp.FAKE_PLATFORM_GCE,
+			want:       PLATFORM_GCE,
+			confidence: CONFIDENCE_HIGH,
+		},
+		{
+			name:       "gke pod",
+			env:        map[string]string{"KUBERNETES_SERVICE_HOST": "10.0.0.1"},
+			metadata:   gcp.FAKE_PLATFORM_GKE,
+			want:       PLATFORM_GKE,
+			confidence: CONFIDENCE_HIGH,
+			also:       []string{PLATFORM_K8S, PLATFORM_GCE},
+		},
+		{
+			name:       "gke node",
+			metadata:   gcp.FAKE_PLATFORM_GKE,
+			want:       PLATFORM_GKE,
+			confidence: CONFIDENCE_HIGH,
+			also:       []string{PLATFORM_GCE},
+		},
+		{
+			name:       "k8s elsewhere",
+			env:        map[string]string{"KUBERNETES_SERVICE_HOST": "10.0.0.1"},
+			want:       PLATFORM_K8S,
+			confidence: CONFIDENCE_HIGH,
+		},
+		{
+			name:       "k8s by service account",
+			files:      []string{"/var/run/secrets/kubernetes.io/serviceaccount"},
+			want:       PLATFORM_K8S,
+			confidence: CONFIDENCE_MEDIUM,
+		},
+		{
+			name:       "run",
+			env:        map[string]string{"K_SERVICE": "hello", "K_REVISION": "hello-00001", "K_CONFIGURATION": "hello"},
+			metadata:   gcp.FAKE_PLATFORM_RUN,
+			want:       PLATFORM_RUN,
+			confidence: CONFIDENCE_HIGH,
+			also:       []string{PLATFORM_GCE},
+		},
+		{
+			name:       "knative",
+			env:        map[string]string{"K_SERVICE": "hello", "K_REVISION": "hello-00001", "K_CONFIGURATION": "hello", "KUBERNETES_SERVICE_HOST": "10.0.0.1"},
+			want:       PLATFORM_K8S,
+			confidence: CONFIDENCE_HIGH,
+			also:       []string{PLATFORM_RUN},
+		},
+		{
+			name:       "run by scope",
+			metadata:   gcp.FAKE_PLATFORM_RUN,
+			want:       PLATFORM_RUN,
+			confidence: CONFIDENCE_LOW,
+			also:       []string{PLATFORM_GCE},
+		},
+		{
+			name:       "run job",
+			env:        map[string]string{"CLOUD_RUN_JOB": "batch", "CLOUD_RUN_EXECUTION": "batch-abcde", "CLOUD_RUN_TASK_INDEX": "0"},
+			metadata:   gcp.FAKE_PLATFORM_RUN,
+			want:       PLATFORM_RUN_JOB,
+			confidence: CONFIDENCE_HIGH,
+		},
+		{
+			name:       "run job without execution",
+			env:        map[string]string{"CLOUD_RUN_JOB": "batch"},
+			want:       PLATFORM_RUN_JOB,
+			confidence: CONFIDENCE_MEDIUM,
+		},
+		{
+			name:       "function",
+			env:        map[string]string{"FUNCTION_TARGET": "HelloWorld", "K_SERVICE": "hello"},
+			metadata:   gcp.FAKE_PLATFORM_GCF,
+			want:       PLATFORM_CF,
+			confidence: CONFIDENCE_HIGH,
+			also:       []string{PLATFORM_RUN},
+		},
+		{
+			name:       "gae",
+			env:        map[string]string{"GAE_APPLICATION": "s~project", "GAE_SERVICE": "default", "GAE_VERSION": "1"},
+			metadata:   gcp.FAKE_PLATFORM_GAE,
+			want:       PLATFORM_GAE,
+			confidence: CONFIDENCE_HIGH,
+		},
+		{
+			name:       "gae by scope",
+			metadata:   gcp.FAKE_PLATFORM_GAE,
+			want:       PLATFORM_GAE,
+			confidence: CONFIDENCE_LOW,
+			also:       []string{PLATFORM_GCE},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var metadata map[string]interface{}
+			if tt.metadata != "" {
+				var err error
+				if metadata, err = gcp.FakeMetadataTree(tt.metadata); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			d := Detect(testEnv(tt.env), testFiles(tt.files...), metadata)
+
+			best := d.Best()
+			if best.Platform != tt.want || best.Confidence != tt.confidence {
+				t.Errorf("Best() = %v (%v), want %v (%v), candidates %+v", best.Platform, best.Confidence, tt.want, tt.confidence, d.Candidates)
+			}
+
+			for _, platform := range tt.also {
+				found := false
+				for _, c := range d.Candidates {
+					found = found || c.Platform == platform
+				}
+				if !found {
+					t.Errorf("%v isn't a candidate, candidates %+v", platform, d.Candidates)
+				}
+			}
+		})
+	}
+}
+
+func TestDetectSignals(t *testing.T) {
+	env := testEnv(map[string]string{"KUBERNETES_SERVICE_HOST": "10.0.0.1"})
+	metadata, err := gcp.FakeMetadataTree(gcp.FAKE_PLATFORM_GKE)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	best := Detect(env, testFiles(), metadata).Best()
+
+	want := []string{"metadata:instance/attributes/clusterName", "env:KUBERNETES_SERVICE_HOST"}
+	if len(best.Signals) != len(want) {
+		t.Fatalf("Signals = %v, want %v", best.Signals, want)
+	}
+	for i := range want {
+		if best.Signals[i] != want[i] {
+			t.Errorf("Signals = %v, want %v", best.Signals, want)
+		}
+	}
+}
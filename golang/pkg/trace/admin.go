@@ -0,0 +1,61 @@
+package trace
+
+import (
+	"encoding/json"
+	"net/http"
+
+	chi "github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+// SamplerAdminRouter serves the sampling control plane:
+//
+//	GET  /         show the sampler config in effect
+//	PUT  /         replace it, e.g. {"type":"ratio","ratio":0.1,"parentBased":true,"rules":[{"path":"/busyloop*","type":"never"}]}
+//	POST /reload   read it again from TRACE_SAMPLER_FILE
+func SamplerAdminRouter(s *Sampler) http.Handler {
+	r := chi.NewRouter()
+
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.Config())
+	})
+
+	r.Put("/", func(w http.ResponseWriter, r *http.Request) {
+		cfg := DefaultSamplerConfig
+		if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := s.Set(cfg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		zap.L().Info("Replaced sampler config",
+			zap.Any("sampler", cfg))
+
+		writeJSON(w, http.StatusOK, s.Config())
+	})
+
+	r.Post("/reload", func(w http.ResponseWriter, r *http.Request) {
+		if err := s.Reload(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		zap.L().Info("Reloaded sampler config",
+			zap.String("file", s.file),
+			zap.Any("sampler", s.Config()))
+
+		writeJSON(w, http.StatusOK, s.Config())
+	})
+
+	return r
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,125 @@
+package trace
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	texporter "github.com/GoogleCloudPlatform/opentelemetry-operations-go/exporter/trace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/exporters/zipkin"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.uber.org/zap"
+
+	exporter "helloworld-http/pkg/exporter"
+)
+
+// Trace exporters that can be picked with TRACE_EXPORTERS
+const (
+	EXPORTER_CLOUDTRACE = "cloudtrace"
+	EXPORTER_OTLP_GRPC  = "otlp-grpc"
+	EXPORTER_OTLP_HTTP  = "otlp-http"
+	EXPORTER_ZIPKIN     = "zipkin"
+	EXPORTER_STDOUT     = "stdout"
+	EXPORTER_NOOP       = "noop"
+
+	DEFAULT_EXPORTERS       = EXPORTER_CLOUDTRACE
+	DEFAULT_ZIPKIN_ENDPOINT = "http://localhost:9411/api/v2/spans"
+)
+
+// ExporterConfig is what exporters are created with, on top of anything they
+// read from the environment themselves
+type ExporterConfig struct {
+	ProjectID string
+}
+
+// ExporterFactory creates a span exporter. A nil exporter with no error
+// means spans aren't exported anywhere.
+type ExporterFactory func(ctx context.Context, cfg ExporterConfig) (sdktrace.SpanExporter, error)
+
+var registry = exporter.NewRegistry("trace", map[string]ExporterFactory{
+	EXPORTER_CLOUDTRACE: newCloudTraceExporter,
+	// both OTLP exporters read the standard OTEL_EXPORTER_OTLP_* variables
+	EXPORTER_OTLP_GRPC: func(ctx context.Context, cfg ExporterConfig) (sdktrace.SpanExporter, error) {
+		return otlptracegrpc.New(ctx)
+	},
+	EXPORTER_OTLP_HTTP: func(ctx context.Context, cfg ExporterConfig) (sdktrace.SpanExporter, error) {
+		return otlptracehttp.New(ctx)
+	},
+	EXPORTER_ZIPKIN: newZipkinExporter,
+	EXPORTER_STDOUT: newStdoutExporter,
+	EXPORTER_NOOP: func(ctx context.Context, cfg ExporterConfig) (sdktrace.SpanExporter, error) {
+		return nil, nil
+	},
+})
+
+// RegisterExporter adds, or replaces, an exporter that can be picked by name
+func RegisterExporter(name string, factory ExporterFactory) {
+	registry.Register(name, factory)
+}
+
+// ExporterNames lists the exporters that can be picked
+func ExporterNames() []string {
+	return registry.Names()
+}
+
+func newCloudTraceExporter(ctx context.Context, cfg ExporterConfig) (sdktrace.SpanExporter, error) {
+	if cfg.ProjectID == "" {
+		return nil, fmt.Errorf("no project ID, set PROJECT_ID or run on GCP")
+	}
+
+	return texporter.New(texporter.WithProjectID(cfg.ProjectID))
+}
+
+func newZipkinExporter(ctx context.Context, cfg ExporterConfig) (sdktrace.SpanExporter, error) {
+	endpoint := DEFAULT_ZIPKIN_ENDPOINT
+	if fromEnv := os.Getenv("ZIPKIN_ENDPOINT"); fromEnv != "" {
+		endpoint = fromEnv
+	}
+
+	return zipkin.New(endpoint)
+}
+
+// newStdoutExporter writes spans to stdout as JSON, one per line unless
+// TRACE_STDOUT_PRETTY asks for them indented
+func newStdoutExporter(ctx context.Context, cfg ExporterConfig) (sdktrace.SpanExporter, error) {
+	opts := []stdouttrace.Option{stdouttrace.WithWriter(os.Stdout)}
+
+	if fromEnv := os.Getenv("TRACE_STDOUT_PRETTY"); fromEnv != "" {
+		pretty, err := strconv.ParseBool(fromEnv)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for TRACE_STDOUT_PRETTY: %v", fromEnv)
+		}
+		if pretty {
+			opts = append(opts, stdouttrace.WithPrettyPrint())
+		}
+	}
+
+	return stdouttrace.New(opts...)
+}
+
+// newExporters creates the named exporters. One that is unknown or fails to
+// start is logged and left out rather than stopping the app, so with none
+// left spans are still created, for propagation and logs, but not exported.
+func newExporters(ctx context.Context, names []string, cfg ExporterConfig) []*healthExporter {
+	var created []*healthExporter
+	registry.Create(names, func(name string, factory ExporterFactory) error {
+		exp, err := factory(ctx, cfg)
+		if err != nil || exp == nil {
+			return err
+		}
+
+		zap.S().Infof("Exporting traces to %v", name)
+		created = append(created, &healthExporter{SpanExporter: exp, name: name})
+		return nil
+	})
+
+	if len(created) == 0 {
+		zap.S().Warnf("No trace exporters configured from %v, spans won't be exported", names)
+	}
+
+	return created
+}
@@ -0,0 +1,73 @@
+package trace
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// failingExporter fails every export, like a backend that's down
+type failingExporter struct {
+	*tracetest.InMemoryExporter
+}
+
+func (failingExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	return errors.New("backend down")
+}
+
+func TestNewExporters(t *testing.T) {
+	RegisterExporter("test-memory", func(ctx context.Context, cfg ExporterConfig) (sdktrace.SpanExporter, error) {
+		return tracetest.NewInMemoryExporter(), nil
+	})
+
+	tests := []struct {
+		name  string
+		names []string
+		cfg   ExporterConfig
+		want  []string
+	}{
+		{name: "noop exports nowhere", names: []string{EXPORTER_NOOP}},
+		{name: "cloudtrace needs a project", names: []string{EXPORTER_CLOUDTRACE, "test-memory"}, want: []string{"test-memory"}},
+		{name: "unknown left out", names: []string{"jaeger", "test-memory"}, want: []string{"test-memory"}},
+		{name: "stdout", names: []string{EXPORTER_STDOUT}, want: []string{EXPORTER_STDOUT}},
+		{name: "none", names: nil},
+	}
+
+	for _, tt := range tests {
+		var got []string
+		for _, e := range newExporters(context.Background(), tt.names, tt.cfg) {
+			got = append(got, e.name)
+		}
+
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%v: created %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExporterCheck(t *testing.T) {
+	ok := &healthExporter{SpanExporter: tracetest.NewInMemoryExporter(), name: "ok"}
+	failing := &healthExporter{SpanExporter: failingExporter{tracetest.NewInMemoryExporter()}, name: "failing"}
+	tc := &TraceConfig{exporters: []*healthExporter{ok, failing}}
+
+	if err := tc.ExporterCheck(); err != nil {
+		t.Errorf("before any export: %v", err)
+	}
+
+	ok.ExportSpans(context.Background(), nil)
+	failing.ExportSpans(context.Background(), nil)
+	if err := tc.ExporterCheck(); err == nil || !strings.Contains(err.Error(), "failing: backend down") || strings.Contains(err.Error(), "ok:") {
+		t.Errorf("after a failed export: %v", err)
+	}
+
+	// the check clears once an export works again
+	failing.SpanExporter = tracetest.NewInMemoryExporter()
+	failing.ExportSpans(context.Background(), nil)
+	if err := tc.ExporterCheck(); err != nil {
+		t.Errorf("after recovering: %v", err)
+	}
+}
@@ -0,0 +1,418 @@
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Sampler types, for the default sampler and for rules
+const (
+	SAMPLER_ALWAYS = "always"
+	SAMPLER_NEVER  = "never"
+	SAMPLER_RATIO  = "ratio"
+	SAMPLER_RATE   = "rate"
+)
+
+// SamplerSpec is one way of deciding whether to sample a trace
+type SamplerSpec struct {
+	Type string `json:"type"`
+
+	// ratio: the fraction of traces to sample, by trace ID
+	Ratio float64 `json:"ratio,omitempty"`
+
+	// rate: how many traces a second to sample at most
+	Rate float64 `json:"rate,omitempty"`
+}
+
+func (s SamplerSpec) validate() error {
+	switch s.Type {
+	case SAMPLER_ALWAYS, SAMPLER_NEVER:
+	case SAMPLER_RATIO:
+		if s.Ratio < 0 || s.Ratio > 1 {
+			return fmt.Errorf("ratio must be between 0 and 1, got %v", s.Ratio)
+		}
+	case SAMPLER_RATE:
+		if s.Rate <= 0 {
+			return fmt.Errorf("rate must be positive, got %v", s.Rate)
+		}
+	default:
+		return fmt.Errorf("unknown sampler type %q", s.Type)
+	}
+
+	return nil
+}
+
+func (s SamplerSpec) sampler() sdktrace.Sampler {
+	switch s.Type {
+	case SAMPLER_NEVER:
+		return sdktrace.NeverSample()
+	case SAMPLER_RATIO:
+		return sdktrace.TraceIDRatioBased(s.Ratio)
+	case SAMPLER_RATE:
+		return newRateLimited(s.Rate)
+	default:
+		return sdktrace.AlwaysSample()
+	}
+}
+
+// SamplingRule picks the sampler for requests whose path matches Path
+// (path.Match syntax, e.g. "/busyloop*") and that have the Header, with
+// Value if one is given. Whatever is left out matches anything.
+type SamplingRule struct {
+	Path   string `json:"path,omitempty"`
+	Header string `json:"header,omitempty"`
+	Value  string `json:"value,omitempty"`
+
+	SamplerSpec
+}
+
+func (r SamplingRule) validate() error {
+	if r.Path == "" && r.Header == "" {
+		return fmt.Errorf("rule needs a path or a header to match")
+	}
+	if r.Path != "" {
+		if _, err := path.Match(r.Path, "/"); err != nil {
+			return fmt.Errorf("invalid path pattern %q: %v", r.Path, err)
+		}
+	}
+
+	return r.SamplerSpec.validate()
+}
+
+func (r SamplingRule) matches(req *samplingRequest) bool {
+	if r.Path != "" {
+		if matched, _ := path.Match(r.Path, req.path); !matched {
+			return false
+		}
+	}
+
+	if r.Header != "" {
+		values, found := req.header[http.CanonicalHeaderKey(r.Header)]
+		if !found {
+			return false
+		}
+		if r.Value != "" {
+			matched := false
+			for _, v := range values {
+				matched = matched || v == r.Value
+			}
+			return matched
+		}
+	}
+
+	return true
+}
+
+func (r SamplingRule) String() string {
+	switch {
+	case r.Path != "" && r.Header != "":
+		return fmt.Sprintf("path=%v header=%v", r.Path, r.Header)
+	case r.Path != "":
+		return "path=" + r.Path
+	default:
+		return "header=" + r.Header
+	}
+}
+
+// SamplerConfig decides which requests are traced. The first rule that
+// matches a request wins, even over the caller's decision; otherwise the
+// caller's decision is followed if ParentBased, and then the default.
+type SamplerConfig struct {
+	SamplerSpec
+
+	ParentBased bool           `json:"parentBased"`
+	Rules       []SamplingRule `json:"rules,omitempty"`
+}
+
+func (c SamplerConfig) Validate() error {
+	if err := c.SamplerSpec.validate(); err != nil {
+		return err
+	}
+
+	for i, rule := range c.Rules {
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("rule %d: %v", i, err)
+		}
+	}
+
+	return nil
+}
+
+// DefaultSamplerConfig samples everything the caller hasn't decided not to,
+// the SDK's default
+var DefaultSamplerConfig = SamplerConfig{
+	SamplerSpec: SamplerSpec{Type: SAMPLER_ALWAYS},
+	ParentBased: true,
+}
+
+// rateLimited samples up to rate traces a second, with a burst of a second's worth
+type rateLimited struct {
+	mu       sync.Mutex
+	rate     float64
+	tokens   float64
+	burst    float64
+	lastFill time.Time
+}
+
+func newRateLimited(rate float64) *rateLimited {
+	burst := rate
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &rateLimited{
+		rate:     rate,
+		tokens:   burst,
+		burst:    burst,
+		lastFill: time.Now(),
+	}
+}
+
+func (r *rateLimited) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	r.mu.Lock()
+	now := time.Now()
+	r.tokens += now.Sub(r.lastFill).Seconds() * r.rate
+	if r.tokens > r.burst {
+		r.tokens = r.burst
+	}
+	r.lastFill = now
+
+	decision := sdktrace.Drop
+	if r.tokens >= 1 {
+		r.tokens--
+		decision = sdktrace.RecordAndSample
+	}
+	r.mu.Unlock()
+
+	return sdktrace.SamplingResult{
+		Decision:   decision,
+		Tracestate: trace.SpanContextFromContext(p.ParentContext).TraceState(),
+	}
+}
+
+func (r *rateLimited) Description() string {
+	return fmt.Sprintf("RateLimited{%v/s}", r.rate)
+}
+
+// samplingRequest is what the rules can see of the request being traced
+type samplingRequest struct {
+	path   string
+	header http.Header
+}
+
+type samplingRequestKey struct{}
+
+// withSamplingRequest lets the sampler match rules against the request the
+// server span is started for
+func withSamplingRequest(ctx context.Context, r *http.Request) context.Context {
+	return context.WithValue(ctx, samplingRequestKey{}, &samplingRequest{
+		path:   r.URL.Path,
+		header: r.Header,
+	})
+}
+
+type compiledRule struct {
+	rule    SamplingRule
+	sampler sdktrace.Sampler
+	attr    attribute.KeyValue
+}
+
+type compiledSampler struct {
+	config      SamplerConfig
+	rules       []compiledRule
+	fallback    sdktrace.Sampler
+	parentBased bool
+}
+
+// Sampler is an sdktrace.Sampler whose config can be swapped while running
+type Sampler struct {
+	mu       sync.RWMutex
+	compiled *compiledSampler
+
+	// where Reload reads the config from, if anywhere
+	file string
+}
+
+// NewSampler returns a Sampler with the given config
+func NewSampler(cfg SamplerConfig) (*Sampler, error) {
+	s := &Sampler{}
+	if err := s.Set(cfg); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Set replaces the sampler's config, keeping the old one if it's invalid
+func (s *Sampler) Set(cfg SamplerConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	compiled := &compiledSampler{
+		config:      cfg,
+		fallback:    cfg.SamplerSpec.sampler(),
+		parentBased: cfg.ParentBased,
+	}
+	for _, rule := range cfg.Rules {
+		compiled.rules = append(compiled.rules, compiledRule{
+			rule:    rule,
+			sampler: rule.SamplerSpec.sampler(),
+			attr:    attribute.String("sampling.rule", rule.String()),
+		})
+	}
+
+	s.mu.Lock()
+	s.compiled = compiled
+	s.mu.Unlock()
+
+	return nil
+}
+
+// Config returns the config in effect
+func (s *Sampler) Config() SamplerConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.compiled.config
+}
+
+// Reload reads the config from the file the sampler was configured from
+func (s *Sampler) Reload() error {
+	if s.file == "" {
+		return fmt.Errorf("no sampler config file, set TRACE_SAMPLER_FILE")
+	}
+
+	cfg, err := readSamplerFile(s.file)
+	if err != nil {
+		return err
+	}
+
+	return s.Set(cfg)
+}
+
+func (s *Sampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	s.mu.RLock()
+	compiled := s.compiled
+	s.mu.RUnlock()
+
+	// spans inside a trace we've already decided on go the same way
+	parent := trace.SpanContextFromContext(p.ParentContext)
+	if parent.IsValid() && !parent.IsRemote() {
+		decision := sdktrace.Drop
+		if parent.IsSampled() {
+			decision = sdktrace.RecordAndSample
+		}
+		return sdktrace.SamplingResult{Decision: decision, Tracestate: parent.TraceState()}
+	}
+
+	if req, ok := p.ParentContext.Value(samplingRequestKey{}).(*samplingRequest); ok {
+		for _, rule := range compiled.rules {
+			if rule.rule.matches(req) {
+				result := rule.sampler.ShouldSample(p)
+				result.Attributes = append(result.Attributes, rule.attr)
+				return result
+			}
+		}
+	}
+
+	if compiled.parentBased && parent.IsValid() {
+		decision := sdktrace.Drop
+		if parent.IsSampled() {
+			decision = sdktrace.RecordAndSample
+		}
+		return sdktrace.SamplingResult{Decision: decision, Tracestate: parent.TraceState()}
+	}
+
+	return compiled.fallback.ShouldSample(p)
+}
+
+func (s *Sampler) Description() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return fmt.Sprintf("HelloSampler{default=%v,parentBased=%v,rules=%d}",
+		s.compiled.fallback.Description(), s.compiled.parentBased, len(s.compiled.rules))
+}
+
+func readSamplerFile(file string) (SamplerConfig, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return SamplerConfig{}, err
+	}
+
+	cfg := DefaultSamplerConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return SamplerConfig{}, fmt.Errorf("error parsing %v: %v", file, err)
+	}
+
+	return cfg, nil
+}
+
+// NewSamplerFromEnv configures the sampler from the JSON file in
+// TRACE_SAMPLER_FILE, or else from TRACE_SAMPLER, TRACE_SAMPLER_ARG and
+// TRACE_SAMPLER_PARENT_BASED
+func NewSamplerFromEnv() (*Sampler, error) {
+	if file := os.Getenv("TRACE_SAMPLER_FILE"); file != "" {
+		cfg, err := readSamplerFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		s, err := NewSampler(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sampler config in %v: %v", file, err)
+		}
+		s.file = file
+
+		return s, nil
+	}
+
+	cfg := DefaultSamplerConfig
+	if fromEnv := os.Getenv("TRACE_SAMPLER"); fromEnv != "" {
+		cfg.Type = fromEnv
+	}
+
+	// without an arg a ratio sampler samples everything, as OTel's
+	// OTEL_TRACES_SAMPLER_ARG does, rather than silently nothing
+	if cfg.Type == SAMPLER_RATIO {
+		cfg.Ratio = 1
+	}
+
+	if fromEnv := os.Getenv("TRACE_SAMPLER_ARG"); fromEnv != "" {
+		arg, err := strconv.ParseFloat(fromEnv, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for TRACE_SAMPLER_ARG: %v", fromEnv)
+		}
+		cfg.Ratio = arg
+		cfg.Rate = arg
+		if cfg.Type != SAMPLER_RATIO {
+			cfg.Ratio = 0
+		}
+		if cfg.Type != SAMPLER_RATE {
+			cfg.Rate = 0
+		}
+	}
+
+	if fromEnv := os.Getenv("TRACE_SAMPLER_PARENT_BASED"); fromEnv != "" {
+		parentBased, err := strconv.ParseBool(fromEnv)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for TRACE_SAMPLER_PARENT_BASED: %v", fromEnv)
+		}
+		cfg.ParentBased = parentBased
+	}
+
+	return NewSampler(cfg)
+}
@@ -0,0 +1,237 @@
+package trace
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func parentContext(sampled bool, remote bool) context.Context {
+	var flags trace.TraceFlags
+	if sampled {
+		flags = trace.FlagsSampled
+	}
+
+	return trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1},
+		SpanID:     trace.SpanID{2},
+		TraceFlags: flags,
+		Remote:     remote,
+	}))
+}
+
+func TestSamplerShouldSample(t *testing.T) {
+	cfg := SamplerConfig{
+		SamplerSpec: SamplerSpec{Type: SAMPLER_ALWAYS},
+		ParentBased: true,
+		Rules: []SamplingRule{
+			{Path: "/busyloop*", SamplerSpec: SamplerSpec{Type: SAMPLER_NEVER}},
+			{Header: "x-debug", Value: "1", SamplerSpec: SamplerSpec{Type: SAMPLER_ALWAYS}},
+			{Path: "/echo", Header: "X-Skip", SamplerSpec: SamplerSpec{Type: SAMPLER_NEVER}},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		parent      context.Context
+		path        string
+		header      http.Header
+		parentBased bool
+		want        sdktrace.SamplingDecision
+		rule        string
+	}{
+		{name: "no rule, no parent", path: "/", parentBased: true, want: sdktrace.RecordAndSample},
+		{name: "path rule", path: "/busyloop", parentBased: true, want: sdktrace.Drop, rule: "path=/busyloop*"},
+		{name: "path rule beats a sampled caller", parent: parentContext(true, true), path: "/busyloop", parentBased: true, want: sdktrace.Drop, rule: "path=/busyloop*"},
+		{name: "header rule with its value", path: "/", header: http.Header{"X-Debug": {"0", "1"}}, parentBased: true, want: sdktrace.RecordAndSample, rule: "header=x-debug"},
+		{name: "header rule with another value", parent: parentContext(false, true), path: "/", header: http.Header{"X-Debug": {"0"}}, parentBased: true, want: sdktrace.Drop},
+		{name: "path and header both have to match", path: "/echo", header: http.Header{"X-Skip": {""}}, parentBased: true, want: sdktrace.Drop, rule: "path=/echo header=X-Skip"},
+		{name: "only the path matches", path: "/echo", parentBased: true, want: sdktrace.RecordAndSample},
+		{name: "caller sampled", parent: parentContext(true, true), path: "/", parentBased: true, want: sdktrace.RecordAndSample},
+		{name: "caller didn't sample", parent: parentContext(false, true), path: "/", parentBased: true, want: sdktrace.Drop},
+		{name: "caller ignored", parent: parentContext(false, true), path: "/", want: sdktrace.RecordAndSample},
+		// a child span goes the way of its trace, rules and all
+		{name: "local parent", parent: parentContext(false, false), path: "/busyloop", header: http.Header{"X-Debug": {"1"}}, parentBased: true, want: sdktrace.Drop},
+	}
+
+	for _, tt := range tests {
+		cfg.ParentBased = tt.parentBased
+		s, err := NewSampler(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ctx := tt.parent
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		r.Header = tt.header
+		ctx = withSamplingRequest(ctx, r)
+
+		result := s.ShouldSample(sdktrace.SamplingParameters{ParentContext: ctx, TraceID: trace.TraceID{1}, Name: tt.path})
+		if result.Decision != tt.want {
+			t.Errorf("%v: decision = %v, want %v", tt.name, result.Decision, tt.want)
+		}
+
+		rule := ""
+		for _, attr := range result.Attributes {
+			if attr.Key == "sampling.rule" {
+				rule = attr.Value.AsString()
+			}
+		}
+		if rule != tt.rule {
+			t.Errorf("%v: sampling.rule = %q, want %q", tt.name, rule, tt.rule)
+		}
+	}
+}
+
+func TestRateLimited(t *testing.T) {
+	r := newRateLimited(5)
+	p := sdktrace.SamplingParameters{ParentContext: context.Background()}
+
+	sampled := 0
+	for i := 0; i < 20; i++ {
+		if r.ShouldSample(p).Decision == sdktrace.RecordAndSample {
+			sampled++
+		}
+	}
+	if sampled != 5 {
+		t.Errorf("sampled %v of a burst of 20, want 5", sampled)
+	}
+
+	// ten seconds later there's only a second's worth again, the burst
+	r.mu.Lock()
+	r.lastFill = r.lastFill.Add(-10 * time.Second)
+	r.mu.Unlock()
+
+	sampled = 0
+	for i := 0; i < 20; i++ {
+		if r.ShouldSample(p).Decision == sdktrace.RecordAndSample {
+			sampled++
+		}
+	}
+	if sampled != 5 {
+		t.Errorf("sampled %v after waiting, want 5", sampled)
+	}
+
+	// under one a second still lets the odd trace through
+	if slow := newRateLimited(0.1); slow.ShouldSample(p).Decision != sdktrace.RecordAndSample || slow.ShouldSample(p).Decision != sdktrace.Drop {
+		t.Errorf("0.1/s: want the first trace sampled and the next dropped")
+	}
+}
+
+func TestSamplerConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     SamplerConfig
+		wantErr bool
+	}{
+		{name: "default", cfg: DefaultSamplerConfig},
+		{name: "ratio", cfg: SamplerConfig{SamplerSpec: SamplerSpec{Type: SAMPLER_RATIO, Ratio: 0.5}}},
+		{name: "ratio over 1", cfg: SamplerConfig{SamplerSpec: SamplerSpec{Type: SAMPLER_RATIO, Ratio: 2}}, wantErr: true},
+		{name: "no rate", cfg: SamplerConfig{SamplerSpec: SamplerSpec{Type: SAMPLER_RATE}}, wantErr: true},
+		{name: "unknown type", cfg: SamplerConfig{SamplerSpec: SamplerSpec{Type: "sometimes"}}, wantErr: true},
+		{
+			name:    "rule matching everything",
+			cfg:     SamplerConfig{SamplerSpec: SamplerSpec{Type: SAMPLER_ALWAYS}, Rules: []SamplingRule{{SamplerSpec: SamplerSpec{Type: SAMPLER_NEVER}}}},
+			wantErr: true,
+		},
+		{
+			name:    "bad pattern",
+			cfg:     SamplerConfig{SamplerSpec: SamplerSpec{Type: SAMPLER_ALWAYS}, Rules: []SamplingRule{{Path: "/[", SamplerSpec: SamplerSpec{Type: SAMPLER_NEVER}}}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		if err := tt.cfg.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%v: Validate() error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+
+	// an invalid config leaves the old one in place
+	s, _ := NewSampler(DefaultSamplerConfig)
+	if err := s.Set(SamplerConfig{SamplerSpec: SamplerSpec{Type: "sometimes"}}); err == nil || s.Config().Type != SAMPLER_ALWAYS {
+		t.Errorf("Set() with an invalid config: error = %v, config %+v", err, s.Config())
+	}
+}
+
+func TestNewSamplerFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    SamplerSpec
+		parent  bool
+		wantErr bool
+	}{
+		{name: "default", want: SamplerSpec{Type: SAMPLER_ALWAYS}, parent: true},
+		{name: "ratio without an arg", env: map[string]string{"TRACE_SAMPLER": SAMPLER_RATIO}, want: SamplerSpec{Type: SAMPLER_RATIO, Ratio: 1}, parent: true},
+		{name: "ratio", env: map[string]string{"TRACE_SAMPLER": SAMPLER_RATIO, "TRACE_SAMPLER_ARG": "0.25"}, want: SamplerSpec{Type: SAMPLER_RATIO, Ratio: 0.25}, parent: true},
+		{name: "rate", env: map[string]string{"TRACE_SAMPLER": SAMPLER_RATE, "TRACE_SAMPLER_ARG": "10", "TRACE_SAMPLER_PARENT_BASED": "false"}, want: SamplerSpec{Type: SAMPLER_RATE, Rate: 10}},
+		{name: "bad arg", env: map[string]string{"TRACE_SAMPLER": SAMPLER_RATE, "TRACE_SAMPLER_ARG": "lots"}, wantErr: true},
+		{name: "rate without an arg", env: map[string]string{"TRACE_SAMPLER": SAMPLER_RATE}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, k := range []string{"TRACE_SAMPLER_FILE", "TRACE_SAMPLER", "TRACE_SAMPLER_ARG", "TRACE_SAMPLER_PARENT_BASED"} {
+				t.Setenv(k, tt.env[k])
+			}
+
+			s, err := NewSamplerFromEnv()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if cfg := s.Config(); cfg.SamplerSpec != tt.want || cfg.ParentBased != tt.parent {
+				t.Errorf("got %+v, want %+v with parentBased %v", cfg, tt.want, tt.parent)
+			}
+		})
+	}
+}
+
+func TestSamplerReload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "sampler.json")
+	write := func(body string) {
+		if err := ioutil.WriteFile(file, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`{"type": "ratio", "ratio": 0.1, "rules": [{"path": "/echo", "type": "never"}]}`)
+	t.Setenv("TRACE_SAMPLER_FILE", file)
+
+	s, err := NewSamplerFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg := s.Config(); cfg.Ratio != 0.1 || !cfg.ParentBased || len(cfg.Rules) != 1 {
+		t.Errorf("from the file: %+v, want parentBased left at its default", cfg)
+	}
+
+	write(`{"type": "never"}`)
+	if err := s.Reload(); err != nil || s.Config().Type != SAMPLER_NEVER {
+		t.Errorf("Reload() error = %v, config %+v", err, s.Config())
+	}
+
+	write(`{"type": "never"`)
+	if err := s.Reload(); err == nil || s.Config().Type != SAMPLER_NEVER {
+		t.Errorf("Reload() of a broken file: error = %v, config %+v", err, s.Config())
+	}
+
+	noFile, _ := NewSampler(DefaultSamplerConfig)
+	if err := noFile.Reload(); err == nil {
+		t.Errorf("Reload() without a file didn't fail")
+	}
+}
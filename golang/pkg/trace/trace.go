@@ -2,12 +2,11 @@ package trace
 
 import (
 	"context"
-	//"fmt"
-	"log"
+	"fmt"
 	"net/http"
-	//"strings"
+	"strings"
+	"sync"
 
-	texporter "github.com/GoogleCloudPlatform/opentelemetry-operations-go/exporter/trace"
 	gcppropagator "github.com/GoogleCloudPlatform/opentelemetry-operations-go/propagator"
 	"go.opentelemetry.io/contrib/detectors/gcp"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
@@ -17,12 +16,57 @@ import (
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
 	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+
+	exporter "helloworld-http/pkg/exporter"
 )
 
 const name = "helloworld-http"
 
 type TraceConfig struct {
-	TracerProvider sdktrace.TracerProvider
+	TracerProvider *sdktrace.TracerProvider
+	Sampler        *Sampler
+	Resource       *resource.Resource
+	exporters      []*healthExporter
+}
+
+// healthExporter remembers whether the last export worked, so the exporter
+// can be reported on by the health checks
+type healthExporter struct {
+	sdktrace.SpanExporter
+	name string
+
+	mu      sync.Mutex
+	lastErr error
+}
+
+func (e *healthExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	err := e.SpanExporter.ExportSpans(ctx, spans)
+
+	e.mu.Lock()
+	e.lastErr = err
+	e.mu.Unlock()
+
+	return err
+}
+
+// ExporterCheck returns the errors from the last span export of each
+// exporter, if any of them failed
+func (t *TraceConfig) ExporterCheck() error {
+	var failed []string
+	for _, e := range t.exporters {
+		e.mu.Lock()
+		if e.lastErr != nil {
+			failed = append(failed, fmt.Sprintf("%v: %v", e.name, e.lastErr))
+		}
+		e.mu.Unlock()
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("last span export failed: %v", strings.Join(failed, "; "))
+	}
+
+	return nil
 }
 
 type Span struct {
@@ -34,12 +78,16 @@ func Middleware(next http.Handler) http.Handler {
 		//method = r.Method
 		path := r.RequestURI
 
-		if path == "/healthz" || path == "/metrics" {
+		switch r.URL.Path {
+		case "/healthz", "/livez", "/readyz", "/startupz", "/metrics":
 			// don't send traces for health or metrics
 			next.ServeHTTP(w, r)
 			return
 		}
 
+		// the sampler's rules match on the path and headers
+		r = r.WithContext(withSamplingRequest(r.Context(), r))
+
 		handler := otelhttp.NewHandler(
 			next,
 			path,
@@ -47,9 +95,8 @@ func Middleware(next http.Handler) http.Handler {
 			otelhttp.WithPropagators(otel.GetTextMapPropagator()),
 		)
 
-		// add the traceId and spanId to the context for logger to read later
-		//newCtx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
-
+		// logging.Middleware, after this, picks the span up from the
+		// context for the request's logger
 		handler.ServeHTTP(w, r)
 	})
 
@@ -84,14 +131,10 @@ func Middleware(next http.Handler) http.Handler {
 
 }
 
-func InitTrace(ctx context.Context, projectID string) (*TraceConfig, error) {
-	exporter, err := texporter.New(texporter.WithProjectID(projectID))
-	if err != nil {
-		log.Fatalf("texporter.New: %v", err)
-		return nil, err
-	}
-
-	// Identify your application using resource detection
+// NewResource identifies the app to whatever its telemetry is exported to,
+// with what the GCP detector finds out about where it's running. Traces and
+// metrics share it.
+func NewResource(ctx context.Context) *resource.Resource {
 	res, err := resource.New(ctx,
 			// Use the GCP resource detector to detect information about the GCP platform
 			resource.WithDetectors(gcp.NewDetector()),
@@ -103,21 +146,38 @@ func InitTrace(ctx context.Context, projectID string) (*TraceConfig, error) {
 			),
 	)
 	if err != nil {
-		log.Fatalf("resource.New: %v", err)
-		return nil, err
+		// what was detected is still usable, e.g. off GCP
+		zap.S().Warnf("Failed to detect all resource attributes: %v", err)
 	}
 
-	// Create trace provider with the exporter.
-	//
-	// By default it uses AlwaysSample() which samples all traces.
-	// In a production environment or high QPS setup please use
-	// probabilistic sampling.
-	// Example:
-	//   tp := sdktrace.NewTracerProvider(sdktrace.WithSampler(sdktrace.TraceIDRatioBased(0.0001)), ...)
-	tp := sdktrace.NewTracerProvider(
-			sdktrace.WithBatcher(exporter),
-			sdktrace.WithResource(res),
-	)
+	return res
+}
+
+// InitTrace sets up tracing with the exporters named in TRACE_EXPORTERS,
+// Cloud Trace by default, sampled as configured by NewSamplerFromEnv
+func InitTrace(ctx context.Context, projectID string) (*TraceConfig, error) {
+	sampler, err := NewSamplerFromEnv()
+	if err != nil {
+		return nil, fmt.Errorf("invalid sampler config: %v", err)
+	}
+
+	names := exporter.NamesFromEnv("TRACE_EXPORTERS", DEFAULT_EXPORTERS)
+	exporters := newExporters(ctx, names, ExporterConfig{ProjectID: projectID})
+
+	res := NewResource(ctx)
+
+	// Create trace provider with the exporters. The sampler samples everything
+	// unless configured otherwise, and can be changed while running through
+	// /admin/sampler.
+	zap.S().Infof("Sampling traces with %v", sampler.Description())
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sampler),
+	}
+	for _, exporter := range exporters {
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	}
+	tp := sdktrace.NewTracerProvider(opts...)
 	defer tp.ForceFlush(ctx) // flushes any pending spans
 	otel.SetTracerProvider(tp)
 
@@ -129,7 +189,10 @@ func InitTrace(ctx context.Context, projectID string) (*TraceConfig, error) {
   	otel.SetTextMapPropagator(compositePropagator)
 
 	return &TraceConfig{
-		*tp,
+		TracerProvider: tp,
+		Sampler:        sampler,
+		Resource:       res,
+		exporters:      exporters,
 	}, nil
 	
 }
@@ -138,10 +201,12 @@ func (t *TraceConfig) Shutdown(ctx context.Context) {
 	_ = t.TracerProvider.Shutdown(ctx);
 }
 
-func (t *TraceConfig) StartTrace(ctx context.Context, spanName string) (Span) {
+// StartTrace starts a span under the one in ctx, returning the context to
+// pass on so that anything done in the span is traced under it
+func (t *TraceConfig) StartTrace(ctx context.Context, spanName string) (context.Context, Span) {
 	ctx, span := otel.Tracer(name).Start(ctx, spanName)
 
-	return Span{
+	return ctx, Span{
 		Span: span,
 	}
 }
\ No newline at end of file
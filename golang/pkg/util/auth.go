@@ -0,0 +1,39 @@
+package util
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// bearerToken reads the token from the Authorization header, which has to
+// give the Bearer scheme rather than just the token
+func bearerToken(r *http.Request) (string, bool) {
+	const scheme = "Bearer "
+
+	auth := r.Header.Get("Authorization")
+	// the scheme is case-insensitive (RFC 7235 section 2.1)
+	if len(auth) <= len(scheme) || !strings.EqualFold(auth[:len(scheme)], scheme) {
+		return "", false
+	}
+
+	return auth[len(scheme):], true
+}
+
+// RequireToken only lets through requests that carry the token as
+// "Authorization: Bearer <token>", for APIs that change how the app behaves
+// for everyone else
+func RequireToken(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			given, ok := bearerToken(r)
+			if !ok || token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
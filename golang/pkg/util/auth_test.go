@@ -0,0 +1,42 @@
+package util
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		token  string
+		auth   string
+		status int
+	}{
+		{name: "right token", token: "s3cret", auth: "Bearer s3cret", status: http.StatusOK},
+		{name: "scheme in lower case", token: "s3cret", auth: "bearer s3cret", status: http.StatusOK},
+		{name: "wrong token", token: "s3cret", auth: "Bearer guess", status: http.StatusUnauthorized},
+		{name: "token without the scheme", token: "s3cret", auth: "s3cret", status: http.StatusUnauthorized},
+		{name: "other scheme", token: "s3cret", auth: "Basic s3cret", status: http.StatusUnauthorized},
+		{name: "scheme only", token: "s3cret", auth: "Bearer ", status: http.StatusUnauthorized},
+		{name: "no header", token: "s3cret", status: http.StatusUnauthorized},
+		{name: "no token configured", token: "", auth: "Bearer ", status: http.StatusUnauthorized},
+	}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/admin", nil)
+		if tt.auth != "" {
+			r.Header.Set("Authorization", tt.auth)
+		}
+		w := httptest.NewRecorder()
+
+		RequireToken(tt.token)(ok).ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%v: status = %v, want %v", tt.name, w.Code, tt.status)
+		}
+		if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") != "Bearer" {
+			t.Errorf("%v: WWW-Authenticate = %q", tt.name, w.Header().Get("WWW-Authenticate"))
+		}
+	}
+}
@@ -4,29 +4,11 @@ import (
 	"context"
 	"time"
 
-	"go.uber.org/zap"
+	load "helloworld-http/pkg/load"
 )
 
+// BusyLoop keeps one core fully busy for busyloopSecs, or until the context
+// is done
 func BusyLoop(ctx context.Context, busyloopSecs int) {
-	zap.S().Infof("Busy Looping for %v seconds", busyloopSecs)
-	done := make(chan bool)
-
-	go func() {
-		// start a timer in a separate thread that will signal the busyloop to exit
-		time.Sleep(time.Duration(busyloopSecs) * time.Second)
-		done <- true
-	}()
-
-	// run an infinite loop on this thread
-	for {
-		select {
-		case <-done:
-			return
-		default:
-			// do nothing
-		}
-	}
-
-
-
-}
\ No newline at end of file
+	load.RunCPU(ctx, 1, 100, time.Duration(busyloopSecs)*time.Second)
+}
@@ -1,29 +1,295 @@
 package util
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"math/rand"
 	"net/http"
+	"sync"
+	"time"
 
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
-	"go.opencensus.io/plugin/ochttp"
 )
 
-func MakeRequest(r *http.Request) (int, []byte) {
-	//transport := http.Transport{DisableKeepAlives: true}
-	octr := &ochttp.Transport{}
-	client := &http.Client{Transport: octr}
+const (
+	DEFAULT_TIMEOUT            = 10 * time.Second
+	DEFAULT_RETRIES            = 3
+	DEFAULT_BACKOFF_BASE       = 100 * time.Millisecond
+	DEFAULT_BACKOFF_MAX        = 2 * time.Second
+	DEFAULT_MAX_RESPONSE_BYTES = 10 << 20
+)
+
+// ErrResponseTooLarge is returned when a response body is bigger than the
+// client's response size cap
+var ErrResponseTooLarge = errors.New("response body too large")
+
+// RequestError is returned when a request couldn't be completed at all,
+// i.e. the transport failed or the context ran out, after all retries
+type RequestError struct {
+	Method   string
+	URL      string
+	Attempts int
+	Err      error
+}
+
+func (e *RequestError) Error() string {
+	return fmt.Sprintf("%v %v failed after %d attempt(s): %v", e.Method, e.URL, e.Attempts, e.Err)
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+// Timeout reports whether the request failed because a deadline passed
+func (e *RequestError) Timeout() bool {
+	if errors.Is(e.Err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var t interface{ Timeout() bool }
+	return errors.As(e.Err, &t) && t.Timeout()
+}
+
+// StatusError is returned alongside the Response when the server answered
+// with a non-2xx status
+type StatusError struct {
+	Method     string
+	URL        string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%v %v returned %d %v", e.Method, e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// Client makes outbound HTTP calls over a shared, pooled transport. Calls
+// that are safe to repeat are retried with jittered exponential backoff.
+type Client struct {
+	httpClient       *http.Client
+	timeout          time.Duration
+	retries          int
+	backoffBase      time.Duration
+	backoffMax       time.Duration
+	maxResponseBytes int64
+}
+
+type ClientOption func(*Client)
+
+// WithTimeout bounds each attempt, unless the request's context already has a deadline
+func WithTimeout(d time.Duration) ClientOption {
+	return func(c *Client) {
+		c.timeout = d
+	}
+}
+
+// WithRetries sets how many times an idempotent call is retried
+func WithRetries(n int) ClientOption {
+	return func(c *Client) {
+		c.retries = n
+	}
+}
+
+func WithBackoff(base time.Duration, max time.Duration) ClientOption {
+	return func(c *Client) {
+		c.backoffBase = base
+		c.backoffMax = max
+	}
+}
+
+func WithMaxResponseBytes(n int64) ClientOption {
+	return func(c *Client) {
+		c.maxResponseBytes = n
+	}
+}
+
+func WithTransport(rt http.RoundTripper) ClientOption {
+	return func(c *Client) {
+		c.httpClient = &http.Client{Transport: rt}
+	}
+}
+
+// sharedTransport is used by every Client that doesn't bring its own, so
+// connections are pooled across all outbound calls
+var sharedTransport = newTransport()
+
+// newTransport traces outbound calls under the span in the request's context.
+// Calls made outside of one, like the metadata refresh in the background,
+// aren't traced rather than each starting a trace of their own.
+func newTransport() http.RoundTripper {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.MaxIdleConns = 100
+	t.MaxIdleConnsPerHost = 10
+
+	return otelhttp.NewTransport(t,
+		otelhttp.WithFilter(func(r *http.Request) bool {
+			return trace.SpanFromContext(r.Context()).SpanContext().IsValid()
+		}),
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return fmt.Sprintf("HTTP %v %v", r.Method, r.URL.Host)
+		}))
+}
+
+func NewClient(opts ...ClientOption) *Client {
+	c := &Client{
+		httpClient:       &http.Client{Transport: sharedTransport},
+		timeout:          DEFAULT_TIMEOUT,
+		retries:          DEFAULT_RETRIES,
+		backoffBase:      DEFAULT_BACKOFF_BASE,
+		backoffMax:       DEFAULT_BACKOFF_MAX,
+		maxResponseBytes: DEFAULT_MAX_RESPONSE_BYTES,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// DefaultClient is what MakeRequest uses
+var DefaultClient = NewClient()
+
+// MakeRequest sends r with DefaultClient
+func MakeRequest(r *http.Request) (*Response, error) {
+	return DefaultClient.Do(r)
+}
+
+var (
+	jitterMu   sync.Mutex
+	jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// backoff returns a "full jitter" delay for the given retry attempt
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.backoffBase << uint(attempt)
+	if d <= 0 || d > c.backoffMax {
+		d = c.backoffMax
+	}
+
+	jitterMu.Lock()
+	defer jitterMu.Unlock()
+
+	return time.Duration(jitterRand.Int63n(int64(d) + 1))
+}
+
+func isIdempotent(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+
+	return r.Header.Get("Idempotency-Key") != ""
+}
+
+func isRetryableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+
+	return false
+}
+
+// attempt sends the request once and reads the (capped) body
+func (c *Client) attempt(r *http.Request) (*Response, error) {
+	ctx := r.Context()
+	if _, hasDeadline := ctx.Deadline(); !hasDeadline && c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	req := r.WithContext(ctx)
+	if r.GetBody != nil {
+		body, err := r.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = body
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
 
-	zap.S().Debugf("Calling: %v %v", r.Method,  r.URL.Path)
-	resp, err := client.Do(r)
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, c.maxResponseBytes+1))
 	if err != nil {
-		message := "Unable to call backend: " + err.Error()
-		panic(message)
+		return nil, err
+	}
+
+	if int64(len(body)) > c.maxResponseBytes {
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrResponseTooLarge, c.maxResponseBytes)
 	}
-	body, err := ioutil.ReadAll(resp.Body)
+
+	return &Response{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       body,
+	}, nil
+}
+
+// Do sends the request, retrying transport errors and 429/502/503/504
+// responses if the request is idempotent and its body can be replayed.
+// A non-2xx response is returned together with a *StatusError.
+func (c *Client) Do(r *http.Request) (*Response, error) {
+	retries := 0
+	if isIdempotent(r) && (r.Body == nil || r.Body == http.NoBody || r.GetBody != nil) {
+		retries = c.retries
+	}
+
+	url := r.URL.Redacted()
+
+	var resp *Response
+	var err error
+	attempts := 0
+	for {
+		attempts++
+
+		zap.S().Debugf("Calling: %v %v (attempt %d)", r.Method, url, attempts)
+		resp, err = c.attempt(r)
+
+		retryable := false
+		if err != nil {
+			// don't bother retrying if the caller has given up
+			retryable = r.Context().Err() == nil && !errors.Is(err, ErrResponseTooLarge)
+		} else {
+			retryable = isRetryableStatus(resp.StatusCode)
+		}
+
+		if !retryable || attempts > retries {
+			break
+		}
+
+		delay := c.backoff(attempts - 1)
+		zap.S().Debugf("Retrying %v %v in %v", r.Method, url, delay)
+
+		select {
+		case <-r.Context().Done():
+			return nil, &RequestError{Method: r.Method, URL: url, Attempts: attempts, Err: r.Context().Err()}
+		case <-time.After(delay):
+		}
+	}
+
 	if err != nil {
-		message := "Unable to read response body: " + err.Error()
-		panic(message)
+		return nil, &RequestError{Method: r.Method, URL: url, Attempts: attempts, Err: err}
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp, &StatusError{Method: r.Method, URL: url, StatusCode: resp.StatusCode}
 	}
 
-	return resp.StatusCode, body
-}
\ No newline at end of file
+	return resp, nil
+}
@@ -0,0 +1,127 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flaky answers with the given statuses in turn, then 200
+func flaky(t *testing.T, statuses ...int) (*httptest.Server, *int32) {
+	t.Helper()
+
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt32(&calls, 1))
+		if n <= len(statuses) {
+			w.WriteHeader(statuses[n-1])
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, &calls
+}
+
+func testClient(opts ...ClientOption) *Client {
+	return NewClient(append([]ClientOption{WithBackoff(time.Millisecond, time.Millisecond)}, opts...)...)
+}
+
+func TestClientRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		header   http.Header
+		statuses []int
+		retries  int
+		calls    int32
+		status   int
+	}{
+		{name: "first time", method: http.MethodGet, calls: 1, status: http.StatusOK},
+		{name: "retried until it works", method: http.MethodGet, statuses: []int{503, 502}, retries: 3, calls: 3, status: http.StatusOK},
+		{name: "out of retries", method: http.MethodGet, statuses: []int{503, 503, 503}, retries: 1, calls: 2, status: http.StatusServiceUnavailable},
+		{name: "client errors aren't retried", method: http.MethodGet, statuses: []int{404}, retries: 3, calls: 1, status: http.StatusNotFound},
+		{name: "POST isn't retried", method: http.MethodPost, statuses: []int{503}, retries: 3, calls: 1, status: http.StatusServiceUnavailable},
+		{name: "POST with an idempotency key", method: http.MethodPost, header: http.Header{"Idempotency-Key": {"1"}}, statuses: []int{429}, retries: 3, calls: 2, status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		srv, calls := flaky(t, tt.statuses...)
+
+		r, err := http.NewRequest(tt.method, srv.URL, strings.NewReader("body"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range tt.header {
+			r.Header[k] = v
+		}
+
+		resp, err := testClient(WithRetries(tt.retries)).Do(r)
+		if got := atomic.LoadInt32(calls); got != tt.calls {
+			t.Errorf("%v: %v calls, want %v", tt.name, got, tt.calls)
+		}
+		if resp == nil || resp.StatusCode != tt.status {
+			t.Errorf("%v: response %+v, want status %v", tt.name, resp, tt.status)
+		}
+
+		var statusErr *StatusError
+		if isError := errors.As(err, &statusErr); isError != (tt.status != http.StatusOK) {
+			t.Errorf("%v: error = %v", tt.name, err)
+		}
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+		w.Write([]byte(strings.Repeat("a", 100)))
+	}))
+	defer srv.Close()
+
+	r, _ := http.NewRequest(http.MethodGet, srv.URL+"/big", nil)
+	if _, err := testClient(WithMaxResponseBytes(10)).Do(r); !errors.Is(err, ErrResponseTooLarge) {
+		t.Errorf("big response: error = %v, want ErrResponseTooLarge", err)
+	}
+
+	r, _ = http.NewRequest(http.MethodGet, srv.URL+"/slow", nil)
+	_, err := testClient(WithTimeout(50*time.Millisecond), WithRetries(1)).Do(r)
+	var reqErr *RequestError
+	if !errors.As(err, &reqErr) || !reqErr.Timeout() || reqErr.Attempts != 2 {
+		t.Errorf("slow response: error = %v, want a timeout after 2 attempts", err)
+	}
+
+	// a caller that's given up isn't retried
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r, _ = http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	if _, err := testClient().Do(r); !errors.As(err, &reqErr) || reqErr.Attempts != 1 {
+		t.Errorf("cancelled: error = %v, want 1 attempt", err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	c := NewClient(WithBackoff(100*time.Millisecond, time.Second))
+
+	for attempt := 0; attempt < 70; attempt++ {
+		max := 100 * time.Millisecond << uint(attempt)
+		if max <= 0 || max > time.Second {
+			max = time.Second
+		}
+
+		if d := c.backoff(attempt); d < 0 || d > max {
+			t.Errorf("attempt %v: backoff = %v, want up to %v", attempt, d, max)
+		}
+	}
+}
@@ -191,7 +191,7 @@ resource "google_compute_health_check" "http-health-check" {
 
   http_health_check {
     port_specification  = "USE_SERVING_PORT"
-    request_path        = "/healthz"
+    request_path        = "/readyz"
   }
 
   log_config {